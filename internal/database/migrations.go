@@ -93,6 +93,176 @@ func (db *Database) RunMigrations() error {
 			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		)`,
 
+		`ALTER TABLE trading_orders ADD COLUMN IF NOT EXISTS mode VARCHAR(10) DEFAULT 'live'`,
+
+		`CREATE TABLE IF NOT EXISTS paper_accounts (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+			balance DECIMAL(20,2) NOT NULL,
+			initial_balance DECIMAL(20,2) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS paper_positions (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			position_id VARCHAR(100) NOT NULL,
+			side VARCHAR(10) NOT NULL,
+			order_type VARCHAR(1) DEFAULT 'm',
+			quantity DECIMAL(15,2) NOT NULL,
+			entry_price DECIMAL(15,2) NOT NULL,
+			leverage DECIMAL(5,2) NOT NULL,
+			margin DECIMAL(20,2) NOT NULL,
+			liquidation DECIMAL(15,2) DEFAULT 0,
+			take_profit DECIMAL(15,2) DEFAULT 0,
+			stop_loss DECIMAL(15,2) DEFAULT 0,
+			opening_fee DECIMAL(20,2) DEFAULT 0,
+			closing_fee DECIMAL(20,2) DEFAULT 0,
+			exit_price DECIMAL(15,2) DEFAULT 0,
+			pl DECIMAL(20,2) DEFAULT 0,
+			status VARCHAR(20) DEFAULT 'running',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			filled_at TIMESTAMP,
+			closed_at TIMESTAMP,
+			UNIQUE (user_id, position_id)
+		)`,
+
+		`ALTER TABLE price_alert ADD COLUMN IF NOT EXISTS currency VARCHAR(3) DEFAULT 'USD'`,
+		`ALTER TABLE margin_protection ADD COLUMN IF NOT EXISTS max_daily_margin BIGINT DEFAULT 100000`,
+		`ALTER TABLE margin_protection ADD COLUMN IF NOT EXISTS cooldown_seconds INTEGER DEFAULT 300`,
+		`ALTER TABLE margin_protection ADD COLUMN IF NOT EXISTS skip_hedged BOOLEAN DEFAULT false`,
+
+		`CREATE TABLE IF NOT EXISTS margin_interventions (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			mode VARCHAR(10) NOT NULL,
+			position_id VARCHAR(100) NOT NULL,
+			price DECIMAL(15,2) NOT NULL,
+			liquidation_before DECIMAL(15,2) NOT NULL,
+			liquidation_after DECIMAL(15,2) DEFAULT 0,
+			target_liquidation DECIMAL(15,2) NOT NULL,
+			margin_needed BIGINT NOT NULL,
+			margin_added BIGINT DEFAULT 0,
+			status VARCHAR(10) NOT NULL,
+			detail TEXT DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS take_profit_updates (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			mode VARCHAR(10) NOT NULL,
+			position_id VARCHAR(100) NOT NULL,
+			side VARCHAR(10) NOT NULL,
+			price DECIMAL(15,2) NOT NULL,
+			previous_take_profit DECIMAL(15,2) DEFAULT 0,
+			take_profit DECIMAL(15,2) NOT NULL,
+			status VARCHAR(10) NOT NULL,
+			detail TEXT DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS withdrawal_limits (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+			daily_limit BIGINT NOT NULL,
+			pending_daily_limit BIGINT,
+			pending_effective_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS withdrawals (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			amount BIGINT NOT NULL,
+			destination TEXT NOT NULL,
+			status VARCHAR(20) DEFAULT 'pending',
+			confirmation_token VARCHAR(64) NOT NULL,
+			external_id VARCHAR(100) DEFAULT '',
+			payment_hash VARCHAR(100) DEFAULT '',
+			fee BIGINT DEFAULT 0,
+			error TEXT DEFAULT '',
+			expires_at TIMESTAMP NOT NULL,
+			confirmed_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS feed_events (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			mode VARCHAR(10) NOT NULL,
+			event VARCHAR(20) NOT NULL,
+			source VARCHAR(20) NOT NULL,
+			detail TEXT DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS strategy_instances (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			strategy VARCHAR(50) NOT NULL,
+			params JSONB NOT NULL DEFAULT '{}',
+			is_enabled BOOLEAN DEFAULT true,
+			position INTEGER DEFAULT 0,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS trailing_stops (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			mode VARCHAR(10) NOT NULL,
+			position_id VARCHAR(100) NOT NULL,
+			side VARCHAR(10) NOT NULL,
+			entry_price DECIMAL(15,2) NOT NULL,
+			water_mark DECIMAL(15,2) NOT NULL,
+			stop_loss DECIMAL(15,2) DEFAULT 0,
+			is_active BOOLEAN DEFAULT false,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(user_id, mode, position_id)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS price_divergence (
+			id SERIAL PRIMARY KEY,
+			network VARCHAR(10) NOT NULL DEFAULT 'mainnet',
+			index_price DECIMAL(15,2) NOT NULL,
+			last_price DECIMAL(15,2) NOT NULL,
+			aggregate_price DECIMAL(15,2) NOT NULL,
+			index_basis DECIMAL(10,4) NOT NULL,
+			last_basis DECIMAL(10,4) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS divergence_events (
+			id SERIAL PRIMARY KEY,
+			network VARCHAR(10) NOT NULL DEFAULT 'mainnet',
+			event VARCHAR(20) NOT NULL,
+			index_price DECIMAL(15,2) NOT NULL,
+			last_price DECIMAL(15,2) NOT NULL,
+			aggregate_price DECIMAL(15,2) NOT NULL,
+			index_basis DECIMAL(10,4) NOT NULL,
+			last_basis DECIMAL(10,4) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS price_candles (
+			id SERIAL PRIMARY KEY,
+			resolution VARCHAR(5) NOT NULL,
+			open_time TIMESTAMP NOT NULL,
+			open DECIMAL(15,2) NOT NULL,
+			high DECIMAL(15,2) NOT NULL,
+			low DECIMAL(15,2) NOT NULL,
+			close DECIMAL(15,2) NOT NULL,
+			samples INTEGER DEFAULT 0,
+			sources JSONB DEFAULT '{}',
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(resolution, open_time)
+		)`,
+
 		`CREATE INDEX IF NOT EXISTS idx_users_username ON users(username)`,
 		`CREATE INDEX IF NOT EXISTS idx_users_email ON users(email)`,
 		`CREATE INDEX IF NOT EXISTS idx_ln_markets_config_user_id ON ln_markets_config(user_id)`,
@@ -102,6 +272,14 @@ func (db *Database) RunMigrations() error {
 		`CREATE INDEX IF NOT EXISTS idx_price_alert_user_id ON price_alert(user_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_trading_orders_user_id ON trading_orders(user_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_trading_orders_status ON trading_orders(status)`,
+		`CREATE INDEX IF NOT EXISTS idx_paper_positions_user_id ON paper_positions(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_withdrawals_user_id ON withdrawals(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_feed_events_user_id ON feed_events(user_id, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_price_divergence_network ON price_divergence(network, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_divergence_events_network ON divergence_events(network, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_strategy_instances_user_id ON strategy_instances(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_margin_interventions_user_id ON margin_interventions(user_id, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_take_profit_updates_user_id ON take_profit_updates(user_id, created_at)`,
 	}
 
 	for i, migration := range migrations {
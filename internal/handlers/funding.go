@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"btc-trading-bot/internal/models"
+	"btc-trading-bot/internal/services"
+
+	"github.com/gorilla/mux"
+)
+
+type FundingHandler struct {
+	fundingService *services.FundingService
+}
+
+func NewFundingHandler(fundingService *services.FundingService) *FundingHandler {
+	return &FundingHandler{
+		fundingService: fundingService,
+	}
+}
+
+// withdrawalRequestResponse is returned once, when the withdrawal is
+// requested, and carries the token needed to confirm it.
+type withdrawalRequestResponse struct {
+	*models.Withdrawal
+	ConfirmationToken string `json:"confirmation_token"`
+}
+
+type withdrawalLimitResponse struct {
+	*models.WithdrawalLimit
+	UsedLast24h int64 `json:"used_last_24h"`
+	Remaining   int64 `json:"remaining"`
+}
+
+func (h *FundingHandler) CreateDeposit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("user_id").(int)
+
+	var request models.DepositRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if request.Amount <= 0 {
+		http.Error(w, "Amount must be a positive number of sats", http.StatusBadRequest)
+		return
+	}
+
+	invoice, err := h.fundingService.CreateDeposit(r.Context(), userID, request.Amount)
+	if err != nil {
+		writeFundingError(w, "Failed to create deposit", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(invoice)
+}
+
+func (h *FundingHandler) GetDeposits(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("user_id").(int)
+
+	deposits, err := h.fundingService.GetDeposits(r.Context(), userID)
+	if err != nil {
+		writeFundingError(w, "Failed to get deposits", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deposits)
+}
+
+func (h *FundingHandler) GetWithdrawals(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("user_id").(int)
+
+	withdrawals, err := h.fundingService.GetWithdrawals(r.Context(), userID)
+	if err != nil {
+		writeFundingError(w, "Failed to get withdrawals", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(withdrawals)
+}
+
+func (h *FundingHandler) GetWithdrawalRequests(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("user_id").(int)
+
+	withdrawals, err := h.fundingService.GetWithdrawalRequests(userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch withdrawals", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(withdrawals)
+}
+
+func (h *FundingHandler) RequestWithdrawal(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("user_id").(int)
+
+	var request models.WithdrawalRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if request.Amount < 0 {
+		http.Error(w, "Amount must be a positive number of sats", http.StatusBadRequest)
+		return
+	}
+
+	withdrawal, err := h.fundingService.RequestWithdrawal(userID, request.Amount, request.Destination)
+	if err != nil {
+		writeFundingError(w, "Failed to request withdrawal", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(withdrawalRequestResponse{
+		Withdrawal:        withdrawal,
+		ConfirmationToken: withdrawal.ConfirmationToken,
+	})
+}
+
+func (h *FundingHandler) ConfirmWithdrawal(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("user_id").(int)
+
+	vars := mux.Vars(r)
+	withdrawalID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid withdrawal id", http.StatusBadRequest)
+		return
+	}
+
+	var request models.WithdrawalConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if request.ConfirmationToken == "" {
+		http.Error(w, "confirmation_token is required", http.StatusBadRequest)
+		return
+	}
+
+	withdrawal, err := h.fundingService.ConfirmWithdrawal(r.Context(), userID, withdrawalID, request.ConfirmationToken)
+	if err != nil {
+		writeFundingError(w, "Failed to withdraw", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(withdrawal)
+}
+
+func (h *FundingHandler) GetWithdrawalLimit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("user_id").(int)
+
+	limit, used, err := h.fundingService.GetWithdrawalLimit(userID)
+	if err != nil {
+		http.Error(w, "Failed to get withdrawal limit", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newWithdrawalLimitResponse(limit, used))
+}
+
+func (h *FundingHandler) SetWithdrawalLimit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("user_id").(int)
+
+	var request models.WithdrawalLimitRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	limit, err := h.fundingService.SetWithdrawalLimit(userID, request.DailyLimit)
+	if err != nil {
+		writeFundingError(w, "Failed to save withdrawal limit", err)
+		return
+	}
+
+	_, used, err := h.fundingService.GetWithdrawalLimit(userID)
+	if err != nil {
+		http.Error(w, "Failed to get withdrawal limit", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newWithdrawalLimitResponse(limit, used))
+}
+
+func newWithdrawalLimitResponse(limit *models.WithdrawalLimit, used int64) withdrawalLimitResponse {
+	remaining := limit.DailyLimit - used
+	if remaining < 0 {
+		remaining = 0
+	}
+	return withdrawalLimitResponse{WithdrawalLimit: limit, UsedLast24h: used, Remaining: remaining}
+}
+
+// writeFundingError maps funding failures to HTTP statuses, falling back to
+// the exchange error mapping.
+func writeFundingError(w http.ResponseWriter, message string, err error) {
+	switch {
+	case errors.Is(err, services.ErrLNMarketsNotConfigured), errors.Is(err, services.ErrWithdrawalNotFound):
+		http.Error(w, message+": "+err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrInvalidWithdrawal), errors.Is(err, services.ErrInvalidConfirmation):
+		http.Error(w, message+": "+err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrWithdrawalNotPending), errors.Is(err, services.ErrWithdrawalNotConfirmed):
+		http.Error(w, message+": "+err.Error(), http.StatusConflict)
+	case errors.Is(err, services.ErrWithdrawalExpired):
+		http.Error(w, message+": "+err.Error(), http.StatusGone)
+	case errors.Is(err, services.ErrWithdrawalLimitExceeded):
+		http.Error(w, message+": "+err.Error(), http.StatusUnprocessableEntity)
+	default:
+		writeExchangeError(w, message, err)
+	}
+}
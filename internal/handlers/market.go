@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"btc-trading-bot/internal/services"
+	"btc-trading-bot/pkg/lnmarkets"
+)
+
+type MarketHandler struct {
+	marketData *services.MarketDataService
+	candles    *services.CandleService
+	aggregator *services.PriceAggregator
+	divergence *services.DivergenceMonitor
+}
+
+func NewMarketHandler(marketData *services.MarketDataService, candles *services.CandleService,
+	aggregator *services.PriceAggregator, divergence *services.DivergenceMonitor) *MarketHandler {
+	return &MarketHandler{
+		marketData: marketData,
+		candles:    candles,
+		aggregator: aggregator,
+		divergence: divergence,
+	}
+}
+
+var ohlcIntervals = map[string]bool{
+	"1m": true, "3m": true, "5m": true, "10m": true, "15m": true, "30m": true, "45m": true,
+	"1h": true, "2h": true, "3h": true, "4h": true,
+	"1d": true, "1w": true, "1M": true, "3M": true,
+}
+
+func (h *MarketHandler) GetTicker(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ticker, err := h.marketData.Ticker(r.Context())
+	if err != nil {
+		writeExchangeError(w, "Failed to get ticker", err)
+		return
+	}
+
+	writeMarketData(w, services.TickerTTL, ticker)
+}
+
+func (h *MarketHandler) GetIndexHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	historyRange, ok := parseHistoryRange(w, r)
+	if !ok {
+		return
+	}
+
+	history, err := h.marketData.IndexHistory(r.Context(), historyRange)
+	if err != nil {
+		writeExchangeError(w, "Failed to get index history", err)
+		return
+	}
+
+	writeMarketData(w, services.HistoryTTL, history)
+}
+
+func (h *MarketHandler) GetPriceHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	historyRange, ok := parseHistoryRange(w, r)
+	if !ok {
+		return
+	}
+
+	history, err := h.marketData.PriceHistory(r.Context(), historyRange)
+	if err != nil {
+		writeExchangeError(w, "Failed to get price history", err)
+		return
+	}
+
+	writeMarketData(w, services.HistoryTTL, history)
+}
+
+func (h *MarketHandler) GetOHLC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	interval := r.URL.Query().Get("interval")
+	if interval == "" {
+		interval = "1h"
+	}
+	if !ohlcIntervals[interval] {
+		http.Error(w, "Invalid interval parameter. Allowed values: 1m, 3m, 5m, 10m, 15m, 30m, 45m, 1h, 2h, 3h, 4h, 1d, 1w, 1M, 3M", http.StatusBadRequest)
+		return
+	}
+
+	historyRange, ok := parseHistoryRange(w, r)
+	if !ok {
+		return
+	}
+
+	candles, err := h.marketData.OHLC(r.Context(), interval, historyRange)
+	if err != nil {
+		writeExchangeError(w, "Failed to get OHLC", err)
+		return
+	}
+
+	writeMarketData(w, services.HistoryTTL, candles)
+}
+
+func (h *MarketHandler) GetCarryFees(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	historyRange, ok := parseHistoryRange(w, r)
+	if !ok {
+		return
+	}
+
+	fees, err := h.marketData.CarryFees(r.Context(), historyRange)
+	if err != nil {
+		writeExchangeError(w, "Failed to get carry fees", err)
+		return
+	}
+
+	writeMarketData(w, services.CarryFeesTTL, fees)
+}
+
+func (h *MarketHandler) GetLeaderboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	leaderboard, err := h.marketData.Leaderboard(r.Context())
+	if err != nil {
+		writeExchangeError(w, "Failed to get leaderboard", err)
+		return
+	}
+
+	writeMarketData(w, services.LeaderboardTTL, leaderboard)
+}
+
+// GetCandles returns OHLC bars of the aggregated price recorded by the bot.
+func (h *MarketHandler) GetCandles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	interval := r.URL.Query().Get("interval")
+	if interval == "" {
+		interval = "1m"
+	}
+	if _, ok := services.CandleIntervals[interval]; !ok {
+		http.Error(w, "Invalid interval parameter. Allowed values: 1m, 5m, 1h, 1d", http.StatusBadRequest)
+		return
+	}
+
+	historyRange, ok := parseHistoryRange(w, r)
+	if !ok {
+		return
+	}
+
+	candles, err := h.candles.Candles(interval, historyRange)
+	if err != nil {
+		http.Error(w, "Failed to fetch candles", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(candles)
+}
+
+// GetSourceStats returns the health of every price aggregator source.
+func (h *MarketHandler) GetSourceStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.aggregator.SourceStats())
+}
+
+// GetDivergence returns the current basis between the LN Markets index and
+// last price and the aggregated price, with its history.
+func (h *MarketHandler) GetDivergence(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	historyRange, ok := parseHistoryRange(w, r)
+	if !ok {
+		return
+	}
+
+	network, ok := parseNetwork(w, r)
+	if !ok {
+		return
+	}
+
+	history, err := h.divergence.History(network, historyRange)
+	if err != nil {
+		http.Error(w, "Failed to fetch divergence history", http.StatusInternalServerError)
+		return
+	}
+
+	status := h.divergence.Status(network)
+	status["history"] = history
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func (h *MarketHandler) GetDivergenceEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	network, ok := parseNetwork(w, r)
+	if !ok {
+		return
+	}
+
+	limit, ok := parseLimit(w, r, 100)
+	if !ok {
+		return
+	}
+
+	events, err := h.divergence.Events(network, limit)
+	if err != nil {
+		http.Error(w, "Failed to fetch divergence events", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+// parseNetwork reads the optional network query parameter, defaulting to the
+// network market data is read from.
+func parseNetwork(w http.ResponseWriter, r *http.Request) (string, bool) {
+	network := r.URL.Query().Get("network")
+	switch network {
+	case "":
+		return services.DefaultNetwork(), true
+	case services.NetworkMainnet, services.NetworkTestnet:
+		return network, true
+	}
+	http.Error(w, "Invalid network parameter. Expected mainnet or testnet", http.StatusBadRequest)
+	return "", false
+}
+
+// parseLimit reads the optional limit query parameter, between 1 and 1000,
+// defaulting to def.
+func parseLimit(w http.ResponseWriter, r *http.Request, def int) (int, bool) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return def, true
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 || limit > 1000 {
+		http.Error(w, "Invalid limit parameter. Expected 1-1000", http.StatusBadRequest)
+		return 0, false
+	}
+	return limit, true
+}
+
+// parseHistoryRange reads the optional from, to (Unix milliseconds) and limit
+// query parameters.
+func parseHistoryRange(w http.ResponseWriter, r *http.Request) (lnmarkets.HistoryRange, bool) {
+	var historyRange lnmarkets.HistoryRange
+	query := r.URL.Query()
+
+	for name, dest := range map[string]*int64{"from": &historyRange.From, "to": &historyRange.To} {
+		if value := query.Get(name); value != "" {
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || parsed < 0 {
+				http.Error(w, "Invalid "+name+" parameter. Expected Unix time in milliseconds", http.StatusBadRequest)
+				return historyRange, false
+			}
+			*dest = parsed
+		}
+	}
+
+	limit, ok := parseLimit(w, r, 0)
+	if !ok {
+		return historyRange, false
+	}
+	historyRange.Limit = limit
+
+	if historyRange.From > 0 && historyRange.To > 0 && historyRange.From > historyRange.To {
+		http.Error(w, "from must be before to", http.StatusBadRequest)
+		return historyRange, false
+	}
+
+	return historyRange, true
+}
+
+func writeMarketData(w http.ResponseWriter, ttl time.Duration, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(int(ttl.Seconds())))
+	json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"btc-trading-bot/internal/models"
+	"btc-trading-bot/pkg/lnmarkets"
+
+	"github.com/gorilla/mux"
+)
+
+// optionsExchangeForRequest resolves the request's exchange and checks that
+// it trades options.
+func (h *TradingHandler) optionsExchangeForRequest(w http.ResponseWriter, r *http.Request) (lnmarkets.OptionsExchange, bool) {
+	client, mode, ok := h.exchangeForRequest(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	options, ok := client.(lnmarkets.OptionsExchange)
+	if !ok {
+		http.Error(w, "Options trading is not available in "+mode+" mode", http.StatusNotImplemented)
+		return nil, false
+	}
+
+	return options, true
+}
+
+func (h *TradingHandler) GetOptionInstruments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	client, ok := h.optionsExchangeForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	names, err := client.GetOptionInstrumentsContext(r.Context())
+	if err != nil {
+		writeExchangeError(w, "Failed to get option instruments", err)
+		return
+	}
+
+	instruments := make([]lnmarkets.OptionInstrument, 0, len(names))
+	for _, name := range names {
+		if instrument, err := lnmarkets.ParseInstrument(name); err == nil {
+			instruments = append(instruments, *instrument)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(instruments)
+}
+
+func (h *TradingHandler) GetOptionInstrument(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	client, ok := h.optionsExchangeForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	instrument, err := client.GetOptionInstrumentContext(r.Context(), name)
+	if err != nil {
+		writeExchangeError(w, "Failed to get option instrument", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(instrument)
+}
+
+func (h *TradingHandler) GetOptionTrades(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status != "" && status != "running" && status != "closed" {
+		http.Error(w, "Invalid status parameter. Allowed values: running, closed", http.StatusBadRequest)
+		return
+	}
+
+	client, ok := h.optionsExchangeForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	options, err := client.GetOptionTradesContext(r.Context(), status)
+	if err != nil {
+		writeExchangeError(w, "Failed to get options", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(options)
+}
+
+// GetOptionSettlements lists the options that were settled at expiry.
+func (h *TradingHandler) GetOptionSettlements(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	client, ok := h.optionsExchangeForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	options, err := client.GetOptionTradesContext(r.Context(), "closed")
+	if err != nil {
+		writeExchangeError(w, "Failed to get option settlements", err)
+		return
+	}
+
+	settled := make([]lnmarkets.OptionTrade, 0, len(options))
+	for _, option := range options {
+		if option.Expired {
+			settled = append(settled, option)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settled)
+}
+
+func (h *TradingHandler) CreateOptionTrade(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request models.OptionTradeRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := lnmarkets.ParseInstrument(request.InstrumentName); err != nil {
+		http.Error(w, "Invalid instrument_name: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if request.Quantity <= 0 {
+		http.Error(w, "Quantity must be a positive USD amount", http.StatusBadRequest)
+		return
+	}
+
+	switch request.Settlement {
+	case "":
+		request.Settlement = lnmarkets.SettlementCash
+	case lnmarkets.SettlementCash, lnmarkets.SettlementPhysical:
+	default:
+		http.Error(w, "Invalid settlement. Allowed values: cash, physical", http.StatusBadRequest)
+		return
+	}
+
+	client, ok := h.optionsExchangeForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	option, err := client.CreateOptionTradeContext(r.Context(), &lnmarkets.OptionTradeRequest{
+		Side:           lnmarkets.SideBuy,
+		Quantity:       request.Quantity,
+		Settlement:     request.Settlement,
+		InstrumentName: request.InstrumentName,
+	})
+	if err != nil {
+		writeExchangeError(w, "Failed to open option", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(option)
+}
+
+func (h *TradingHandler) CloseOptionTrade(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	optionID := vars["id"]
+
+	client, ok := h.optionsExchangeForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	option, err := client.CloseOptionTradeContext(r.Context(), optionID)
+	if err != nil {
+		writeExchangeError(w, "Failed to close option", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(option)
+}
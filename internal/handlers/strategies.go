@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"btc-trading-bot/internal/models"
+	"btc-trading-bot/internal/services"
+
+	"github.com/gorilla/mux"
+)
+
+// GetStrategies lists the registered strategies and the user's strategy
+// instances in execution order.
+func (h *TradingHandler) GetStrategies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("user_id").(int)
+
+	instances := []models.StrategyInstance{}
+	err := h.db.Select(&instances, "SELECT * FROM strategy_instances WHERE user_id = $1 ORDER BY position, id", userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch strategies", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"available": services.StrategyNames(),
+		"instances": instances,
+	})
+}
+
+func (h *TradingHandler) CreateStrategy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("user_id").(int)
+
+	instance, ok := h.decodeStrategyInstance(w, r, userID, 0)
+	if !ok {
+		return
+	}
+	instance.UserID = userID
+	instance.CreatedAt = time.Now()
+	instance.UpdatedAt = instance.CreatedAt
+
+	err := h.db.Get(&instance.ID, `
+		INSERT INTO strategy_instances (user_id, strategy, params, is_enabled, position, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`, instance.UserID, instance.Strategy, string(instance.Params), instance.IsEnabled, instance.Position, instance.CreatedAt, instance.UpdatedAt)
+	if err != nil {
+		http.Error(w, "Failed to save strategy", http.StatusInternalServerError)
+		return
+	}
+
+	h.tradingService.ReloadStrategies(userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(instance)
+}
+
+func (h *TradingHandler) UpdateStrategy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("user_id").(int)
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid strategy ID", http.StatusBadRequest)
+		return
+	}
+
+	instance, ok := h.decodeStrategyInstance(w, r, userID, id)
+	if !ok {
+		return
+	}
+
+	err = h.db.Get(instance, `
+		UPDATE strategy_instances
+		SET strategy = $1, params = $2, is_enabled = $3, position = $4, updated_at = $5
+		WHERE id = $6 AND user_id = $7
+		RETURNING *
+	`, instance.Strategy, string(instance.Params), instance.IsEnabled, instance.Position, time.Now(), id, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Strategy not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to save strategy", http.StatusInternalServerError)
+		return
+	}
+
+	h.tradingService.ReloadStrategies(userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(instance)
+}
+
+func (h *TradingHandler) DeleteStrategy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("user_id").(int)
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid strategy ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.db.Exec("DELETE FROM strategy_instances WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		http.Error(w, "Failed to delete strategy", http.StatusInternalServerError)
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		http.Error(w, "Strategy not found", http.StatusNotFound)
+		return
+	}
+
+	h.tradingService.ReloadStrategies(userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Strategy deleted"})
+}
+
+// decodeStrategyInstance reads a strategy instance from the request body,
+// checking that the strategy exists and accepts the parameters, and that the
+// user has no other instance of a single-instance strategy. id is the
+// instance being updated, or 0 for a new one.
+func (h *TradingHandler) decodeStrategyInstance(w http.ResponseWriter, r *http.Request, userID, id int) (*models.StrategyInstance, bool) {
+	var request models.StrategyInstanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil, false
+	}
+
+	params := request.Params
+	if len(params) == 0 || string(params) == "null" {
+		params = json.RawMessage("{}")
+	}
+	if _, err := services.NewStrategy(request.Strategy, params); err != nil {
+		http.Error(w, "Invalid strategy: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	if services.IsSingleStrategy(request.Strategy) {
+		var others int
+		err := h.db.Get(&others, "SELECT COUNT(*) FROM strategy_instances WHERE user_id = $1 AND strategy = $2 AND id <> $3",
+			userID, request.Strategy, id)
+		if err != nil {
+			http.Error(w, "Failed to fetch strategies", http.StatusInternalServerError)
+			return nil, false
+		}
+		if others > 0 {
+			http.Error(w, "Only one "+request.Strategy+" strategy is allowed", http.StatusConflict)
+			return nil, false
+		}
+	}
+
+	return &models.StrategyInstance{
+		Strategy:  request.Strategy,
+		Params:    params,
+		IsEnabled: request.GetIsEnabled(),
+		Position:  request.Position,
+	}, true
+}
+
+// GetTrailingStops returns the trails the trailing stop strategy keeps on the
+// user's positions.
+func (h *TradingHandler) GetTrailingStops(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("user_id").(int)
+
+	stops := []models.TrailingStop{}
+	err := h.db.Select(&stops, "SELECT * FROM trailing_stops WHERE user_id = $1 ORDER BY created_at", userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch trailing stops", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stops)
+}
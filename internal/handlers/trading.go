@@ -1,8 +1,14 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"btc-trading-bot/internal/database"
@@ -25,6 +31,45 @@ func NewTradingHandler(db *database.Database, tradingService *services.TradingSe
 	}
 }
 
+// accountBalanceResponse is the exchange balance tagged with the trading mode
+// it belongs to. Paper accounts also report their starting balance and PnL.
+type accountBalanceResponse struct {
+	*lnmarkets.UserData
+	Mode           string   `json:"mode"`
+	InitialBalance *float64 `json:"initial_balance,omitempty"`
+	RealizedPL     *float64 `json:"realized_pl,omitempty"`
+}
+
+// positionResponse is an exchange position tagged with its trading mode.
+type positionResponse struct {
+	lnmarkets.TradeResponse
+	Mode string `json:"mode"`
+}
+
+// exchangeForRequest resolves the exchange selected by the optional "mode"
+// query parameter, writing an error response when none is available.
+func (h *TradingHandler) exchangeForRequest(w http.ResponseWriter, r *http.Request) (lnmarkets.Exchange, string, bool) {
+	mode := r.URL.Query().Get("mode")
+	if mode != "" && mode != services.TradingModeLive && mode != services.TradingModePaper {
+		http.Error(w, "Invalid mode parameter. Allowed values: live, paper", http.StatusBadRequest)
+		return nil, "", false
+	}
+
+	userID := r.Context().Value("user_id").(int)
+
+	client, mode, err := h.tradingService.ExchangeForUser(userID, mode)
+	if err != nil {
+		if mode == services.TradingModePaper {
+			http.Error(w, "Paper account not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "LN Markets configuration not found", http.StatusNotFound)
+		}
+		return nil, "", false
+	}
+
+	return client, mode, true
+}
+
 func (h *TradingHandler) SetLNMarketsConfig(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -107,11 +152,30 @@ func (h *TradingHandler) SetMarginProtection(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	// Protection must push the liquidation price out past where it kicks in.
+	if request.NewLiquidationDistance <= request.ActivationDistance {
+		http.Error(w, "new_liquidation_distance must be greater than activation_distance", http.StatusBadRequest)
+		return
+	}
+	if request.MaxDailyMargin < 0 || request.CooldownSeconds < 0 {
+		http.Error(w, "max_daily_margin and cooldown_seconds must not be negative", http.StatusBadRequest)
+		return
+	}
+	if request.MaxDailyMargin == 0 {
+		request.MaxDailyMargin = 100000
+	}
+	if request.CooldownSeconds == 0 {
+		request.CooldownSeconds = 300
+	}
+
 	config := models.MarginProtection{
 		UserID:                 userID,
 		IsEnabled:              request.GetIsEnabled(),
 		ActivationDistance:     request.ActivationDistance,
 		NewLiquidationDistance: request.NewLiquidationDistance,
+		MaxDailyMargin:         request.MaxDailyMargin,
+		CooldownSeconds:        request.CooldownSeconds,
+		SkipHedged:             request.SkipHedged,
 		CreatedAt:              time.Now(),
 		UpdatedAt:              time.Now(),
 	}
@@ -121,14 +185,14 @@ func (h *TradingHandler) SetMarginProtection(w http.ResponseWriter, r *http.Requ
 	if err == nil {
 		_, err = h.db.Exec(`
 			UPDATE margin_protection 
-			SET is_enabled = $1, activation_distance = $2, new_liquidation_distance = $3, updated_at = $4
-			WHERE user_id = $5
-		`, config.IsEnabled, config.ActivationDistance, config.NewLiquidationDistance, config.UpdatedAt, userID)
+			SET is_enabled = $1, activation_distance = $2, new_liquidation_distance = $3, max_daily_margin = $4, cooldown_seconds = $5, skip_hedged = $6, updated_at = $7
+			WHERE user_id = $8
+		`, config.IsEnabled, config.ActivationDistance, config.NewLiquidationDistance, config.MaxDailyMargin, config.CooldownSeconds, config.SkipHedged, config.UpdatedAt, userID)
 	} else {
 		_, err = h.db.Exec(`
-			INSERT INTO margin_protection (user_id, is_enabled, activation_distance, new_liquidation_distance, created_at, updated_at)
-			VALUES ($1, $2, $3, $4, $5, $6)
-		`, config.UserID, config.IsEnabled, config.ActivationDistance, config.NewLiquidationDistance, config.CreatedAt, config.UpdatedAt)
+			INSERT INTO margin_protection (user_id, is_enabled, activation_distance, new_liquidation_distance, max_daily_margin, cooldown_seconds, skip_hedged, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		`, config.UserID, config.IsEnabled, config.ActivationDistance, config.NewLiquidationDistance, config.MaxDailyMargin, config.CooldownSeconds, config.SkipHedged, config.CreatedAt, config.UpdatedAt)
 	}
 
 	if err != nil {
@@ -160,6 +224,32 @@ func (h *TradingHandler) GetMarginProtection(w http.ResponseWriter, r *http.Requ
 	json.NewEncoder(w).Encode(config)
 }
 
+// GetMarginInterventions returns the user's margin protection history, newest
+// first.
+func (h *TradingHandler) GetMarginInterventions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("user_id").(int)
+
+	limit, ok := parseLimit(w, r, 100)
+	if !ok {
+		return
+	}
+
+	interventions := []models.MarginIntervention{}
+	err := h.db.Select(&interventions, "SELECT * FROM margin_interventions WHERE user_id = $1 ORDER BY created_at DESC LIMIT $2", userID, limit)
+	if err != nil {
+		http.Error(w, "Failed to fetch margin interventions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(interventions)
+}
+
 func (h *TradingHandler) SetTakeProfit(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -180,17 +270,18 @@ func (h *TradingHandler) SetTakeProfit(w http.ResponseWriter, r *http.Request) {
 		DailyPercentage: request.DailyPercentage,
 		CreatedAt:       time.Now(),
 		UpdatedAt:       time.Now(),
-		LastUpdate:      time.Now(),
 	}
 
+	// A zero last update makes a running bot apply the new percentage to
+	// every position right away instead of at the next daily update.
 	var existingConfig models.TakeProfit
 	err := h.db.Get(&existingConfig, "SELECT id FROM take_profit WHERE user_id = $1", userID)
 	if err == nil {
 		_, err = h.db.Exec(`
 			UPDATE take_profit 
-			SET is_enabled = $1, daily_percentage = $2, updated_at = $3
-			WHERE user_id = $4
-		`, config.IsEnabled, config.DailyPercentage, config.UpdatedAt, userID)
+			SET is_enabled = $1, daily_percentage = $2, last_update = $3, updated_at = $4
+			WHERE user_id = $5
+		`, config.IsEnabled, config.DailyPercentage, config.LastUpdate, config.UpdatedAt, userID)
 	} else {
 		_, err = h.db.Exec(`
 			INSERT INTO take_profit (user_id, is_enabled, daily_percentage, last_update, created_at, updated_at)
@@ -208,6 +299,32 @@ func (h *TradingHandler) SetTakeProfit(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"message": "Take profit configuration saved"})
 }
 
+// GetTakeProfitUpdates returns the take profit updates made on the user's
+// positions, newest first.
+func (h *TradingHandler) GetTakeProfitUpdates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("user_id").(int)
+
+	limit, ok := parseLimit(w, r, 100)
+	if !ok {
+		return
+	}
+
+	updates := []models.TakeProfitUpdate{}
+	err := h.db.Select(&updates, "SELECT * FROM take_profit_updates WHERE user_id = $1 ORDER BY created_at DESC LIMIT $2", userID, limit)
+	if err != nil {
+		http.Error(w, "Failed to fetch take profit updates", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updates)
+}
+
 func (h *TradingHandler) GetTakeProfit(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -318,11 +435,18 @@ func (h *TradingHandler) SetPriceAlert(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	currency, err := services.NormalizeCurrency(request.Currency)
+	if err != nil {
+		http.Error(w, "Invalid currency. Allowed values: "+strings.Join(services.SupportedCurrencies, ", "), http.StatusBadRequest)
+		return
+	}
+
 	config := models.PriceAlert{
 		UserID:        userID,
 		IsEnabled:     request.GetIsEnabled(),
 		MinPrice:      request.MinPrice,
 		MaxPrice:      request.MaxPrice,
+		Currency:      currency,
 		CheckInterval: request.CheckInterval,
 		CreatedAt:     time.Now(),
 		UpdatedAt:     time.Now(),
@@ -330,18 +454,18 @@ func (h *TradingHandler) SetPriceAlert(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var existingConfig models.PriceAlert
-	err := h.db.Get(&existingConfig, "SELECT id FROM price_alert WHERE user_id = $1", userID)
+	err = h.db.Get(&existingConfig, "SELECT id FROM price_alert WHERE user_id = $1", userID)
 	if err == nil {
 		_, err = h.db.Exec(`
 			UPDATE price_alert 
-			SET is_enabled = $1, min_price = $2, max_price = $3, check_interval = $4, updated_at = $5
-			WHERE user_id = $6
-		`, config.IsEnabled, config.MinPrice, config.MaxPrice, config.CheckInterval, config.UpdatedAt, userID)
+			SET is_enabled = $1, min_price = $2, max_price = $3, currency = $4, check_interval = $5, updated_at = $6
+			WHERE user_id = $7
+		`, config.IsEnabled, config.MinPrice, config.MaxPrice, config.Currency, config.CheckInterval, config.UpdatedAt, userID)
 	} else {
 		_, err = h.db.Exec(`
-			INSERT INTO price_alert (user_id, is_enabled, min_price, max_price, check_interval, last_alert, created_at, updated_at)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-		`, config.UserID, config.IsEnabled, config.MinPrice, config.MaxPrice, config.CheckInterval, config.LastAlert, config.CreatedAt, config.UpdatedAt)
+			INSERT INTO price_alert (user_id, is_enabled, min_price, max_price, currency, check_interval, last_alert, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		`, config.UserID, config.IsEnabled, config.MinPrice, config.MaxPrice, config.Currency, config.CheckInterval, config.LastAlert, config.CreatedAt, config.UpdatedAt)
 	}
 
 	if err != nil {
@@ -382,7 +506,12 @@ func (h *TradingHandler) GetOrders(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("user_id").(int)
 
 	var orders []models.TradingOrder
-	err := h.db.Select(&orders, "SELECT * FROM trading_orders WHERE user_id = $1 ORDER BY created_at DESC", userID)
+	var err error
+	if mode := r.URL.Query().Get("mode"); mode != "" {
+		err = h.db.Select(&orders, "SELECT * FROM trading_orders WHERE user_id = $1 AND mode = $2 ORDER BY created_at DESC", userID, mode)
+	} else {
+		err = h.db.Select(&orders, "SELECT * FROM trading_orders WHERE user_id = $1 ORDER BY created_at DESC", userID)
+	}
 	if err != nil {
 		http.Error(w, "Failed to fetch orders", http.StatusInternalServerError)
 		return
@@ -400,7 +529,18 @@ func (h *TradingHandler) StartBot(w http.ResponseWriter, r *http.Request) {
 
 	userID := r.Context().Value("user_id").(int)
 
-	err := h.tradingService.InitializeClient(userID)
+	var request models.BotStartRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if request.Mode != "" && request.Mode != services.TradingModeLive && request.Mode != services.TradingModePaper {
+		http.Error(w, "Invalid mode. Allowed values: live, paper", http.StatusBadRequest)
+		return
+	}
+
+	err := h.tradingService.InitializeClient(userID, request.Mode)
 	if err != nil {
 		http.Error(w, "Failed to start bot: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -448,7 +588,7 @@ func (h *TradingHandler) GetBotStatus(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(status)
 }
 
-func (h *TradingHandler) GetAccountBalance(w http.ResponseWriter, r *http.Request) {
+func (h *TradingHandler) GetFeedEvents(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -456,23 +596,50 @@ func (h *TradingHandler) GetAccountBalance(w http.ResponseWriter, r *http.Reques
 
 	userID := r.Context().Value("user_id").(int)
 
-	// Get LN Markets config for this user
-	var config models.LNMarketsConfig
-	err := h.db.Get(&config, "SELECT * FROM ln_markets_config WHERE user_id = $1", userID)
+	limit, ok := parseLimit(w, r, 100)
+	if !ok {
+		return
+	}
+
+	events := []models.FeedEvent{}
+	err := h.db.Select(&events, "SELECT * FROM feed_events WHERE user_id = $1 ORDER BY created_at DESC LIMIT $2", userID, limit)
 	if err != nil {
-		http.Error(w, "LN Markets configuration not found", http.StatusNotFound)
+		http.Error(w, "Failed to fetch feed events", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+func (h *TradingHandler) GetAccountBalance(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	client := lnmarkets.NewClient(config.APIKey, config.SecretKey, config.Passphrase, config.IsTestnet)
-	balance, err := client.GetAccountBalance()
+	client, mode, ok := h.exchangeForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	balance, err := client.GetAccountBalanceContext(r.Context())
 	if err != nil {
-		http.Error(w, "Failed to get account balance: "+err.Error(), http.StatusInternalServerError)
+		writeExchangeError(w, "Failed to get account balance", err)
 		return
 	}
 
+	response := accountBalanceResponse{UserData: balance, Mode: mode}
+	if mode == services.TradingModePaper {
+		userID := r.Context().Value("user_id").(int)
+		if account, realizedPL, err := h.tradingService.GetPaperAccount(userID); err == nil {
+			response.InitialBalance = &account.InitialBalance
+			response.RealizedPL = &realizedPL
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(balance)
+	json.NewEncoder(w).Encode(response)
 }
 
 func (h *TradingHandler) GetPositions(w http.ResponseWriter, r *http.Request) {
@@ -496,24 +663,19 @@ func (h *TradingHandler) GetPositions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID := r.Context().Value("user_id").(int)
-
-	var config models.LNMarketsConfig
-	err := h.db.Get(&config, "SELECT * FROM ln_markets_config WHERE user_id = $1", userID)
-	if err != nil {
-		http.Error(w, "LN Markets configuration not found", http.StatusNotFound)
+	client, mode, ok := h.exchangeForRequest(w, r)
+	if !ok {
 		return
 	}
 
-	client := lnmarkets.NewClient(config.APIKey, config.SecretKey, config.Passphrase, config.IsTestnet)
-	positions, err := client.GetPositions(positionType)
+	positions, err := client.GetPositionsContext(r.Context(), positionType)
 	if err != nil {
-		http.Error(w, "Failed to get positions: "+err.Error(), http.StatusInternalServerError)
+		writeExchangeError(w, "Failed to get positions", err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(positions)
+	json.NewEncoder(w).Encode(positionsResponse(positions, mode))
 }
 
 func (h *TradingHandler) GetPosition(w http.ResponseWriter, r *http.Request) {
@@ -525,24 +687,19 @@ func (h *TradingHandler) GetPosition(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	positionID := vars["id"]
 
-	userID := r.Context().Value("user_id").(int)
-
-	var config models.LNMarketsConfig
-	err := h.db.Get(&config, "SELECT * FROM ln_markets_config WHERE user_id = $1", userID)
-	if err != nil {
-		http.Error(w, "LN Markets configuration not found", http.StatusNotFound)
+	client, mode, ok := h.exchangeForRequest(w, r)
+	if !ok {
 		return
 	}
 
-	client := lnmarkets.NewClient(config.APIKey, config.SecretKey, config.Passphrase, config.IsTestnet)
-	position, err := client.GetPosition(positionID)
+	position, err := client.GetPositionContext(r.Context(), positionID)
 	if err != nil {
-		http.Error(w, "Failed to get position: "+err.Error(), http.StatusInternalServerError)
+		writeExchangeError(w, "Failed to get position", err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(position)
+	json.NewEncoder(w).Encode(positionResponse{TradeResponse: *position, Mode: mode})
 }
 
 func (h *TradingHandler) ClosePosition(w http.ResponseWriter, r *http.Request) {
@@ -554,19 +711,14 @@ func (h *TradingHandler) ClosePosition(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	positionID := vars["id"]
 
-	userID := r.Context().Value("user_id").(int)
-
-	var config models.LNMarketsConfig
-	err := h.db.Get(&config, "SELECT * FROM ln_markets_config WHERE user_id = $1", userID)
-	if err != nil {
-		http.Error(w, "LN Markets configuration not found", http.StatusNotFound)
+	client, _, ok := h.exchangeForRequest(w, r)
+	if !ok {
 		return
 	}
 
-	client := lnmarkets.NewClient(config.APIKey, config.SecretKey, config.Passphrase, config.IsTestnet)
-	err = client.ClosePosition(positionID)
+	err := client.ClosePositionContext(r.Context(), positionID)
 	if err != nil {
-		http.Error(w, "Failed to close position: "+err.Error(), http.StatusInternalServerError)
+		writeExchangeError(w, "Failed to close position", err)
 		return
 	}
 
@@ -593,19 +745,14 @@ func (h *TradingHandler) UpdateTakeProfit(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	userID := r.Context().Value("user_id").(int)
-
-	var config models.LNMarketsConfig
-	err := h.db.Get(&config, "SELECT * FROM ln_markets_config WHERE user_id = $1", userID)
-	if err != nil {
-		http.Error(w, "LN Markets configuration not found", http.StatusNotFound)
+	client, _, ok := h.exchangeForRequest(w, r)
+	if !ok {
 		return
 	}
 
-	client := lnmarkets.NewClient(config.APIKey, config.SecretKey, config.Passphrase, config.IsTestnet)
-	err = client.UpdateTakeProfit(positionID, request.Price)
+	err := client.UpdateTakeProfitContext(r.Context(), positionID, request.Price)
 	if err != nil {
-		http.Error(w, "Failed to update take profit: "+err.Error(), http.StatusInternalServerError)
+		writeExchangeError(w, "Failed to update take profit", err)
 		return
 	}
 
@@ -632,19 +779,14 @@ func (h *TradingHandler) UpdateStopLoss(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	userID := r.Context().Value("user_id").(int)
-
-	var config models.LNMarketsConfig
-	err := h.db.Get(&config, "SELECT * FROM ln_markets_config WHERE user_id = $1", userID)
-	if err != nil {
-		http.Error(w, "LN Markets configuration not found", http.StatusNotFound)
+	client, _, ok := h.exchangeForRequest(w, r)
+	if !ok {
 		return
 	}
 
-	client := lnmarkets.NewClient(config.APIKey, config.SecretKey, config.Passphrase, config.IsTestnet)
-	err = client.UpdateStopLoss(positionID, request.Price)
+	err := client.UpdateStopLossContext(r.Context(), positionID, request.Price)
 	if err != nil {
-		http.Error(w, "Failed to update stop loss: "+err.Error(), http.StatusInternalServerError)
+		writeExchangeError(w, "Failed to update stop loss", err)
 		return
 	}
 
@@ -652,3 +794,222 @@ func (h *TradingHandler) UpdateStopLoss(w http.ResponseWriter, r *http.Request)
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Stop loss updated successfully"})
 }
+
+func (h *TradingHandler) CreateTrade(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request models.CreateTradeRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	trade := &lnmarkets.TradeRequest{
+		Quantity:   request.Quantity,
+		Margin:     request.Margin,
+		Leverage:   request.Leverage,
+		Price:      request.Price,
+		TakeProfit: request.TakeProfit,
+		StopLoss:   request.StopLoss,
+	}
+
+	switch request.Type {
+	case "", "market", lnmarkets.OrderTypeMarket:
+		trade.Type = lnmarkets.OrderTypeMarket
+		trade.Price = 0
+	case "limit", lnmarkets.OrderTypeLimit:
+		trade.Type = lnmarkets.OrderTypeLimit
+		if trade.Price <= 0 {
+			http.Error(w, "Limit orders require a price", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Invalid type. Allowed values: market, limit", http.StatusBadRequest)
+		return
+	}
+
+	switch request.Side {
+	case "buy", lnmarkets.SideBuy:
+		trade.Side = lnmarkets.SideBuy
+	case "sell", lnmarkets.SideSell:
+		trade.Side = lnmarkets.SideSell
+	default:
+		http.Error(w, "Invalid side. Allowed values: buy, sell", http.StatusBadRequest)
+		return
+	}
+
+	if (trade.Quantity > 0) == (trade.Margin > 0) {
+		http.Error(w, "Exactly one of quantity or margin must be set", http.StatusBadRequest)
+		return
+	}
+
+	client, mode, ok := h.exchangeForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	position, err := client.CreateTradeContext(r.Context(), trade)
+	if err != nil {
+		writeExchangeError(w, "Failed to create trade", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(positionResponse{TradeResponse: *position, Mode: mode})
+}
+
+func (h *TradingHandler) CancelTrade(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	positionID := vars["id"]
+
+	client, mode, ok := h.exchangeForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	position, err := client.CancelTradeContext(r.Context(), positionID)
+	if err != nil {
+		writeExchangeError(w, "Failed to cancel order", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(positionResponse{TradeResponse: *position, Mode: mode})
+}
+
+func (h *TradingHandler) CancelAllTrades(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	client, mode, ok := h.exchangeForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	positions, err := client.CancelAllTradesContext(r.Context())
+	if err != nil {
+		writeExchangeError(w, "Failed to cancel orders", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(positionsResponse(positions, mode))
+}
+
+func (h *TradingHandler) CloseAllPositions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	client, mode, ok := h.exchangeForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	positions, err := client.CloseAllPositionsContext(r.Context())
+	if err != nil {
+		writeExchangeError(w, "Failed to close positions", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(positionsResponse(positions, mode))
+}
+
+func (h *TradingHandler) AddMargin(w http.ResponseWriter, r *http.Request) {
+	h.changeMargin(w, r, "add margin", func(ctx context.Context, client lnmarkets.Exchange, positionID string, amount int64) (*lnmarkets.TradeResponse, error) {
+		return client.AddMarginContext(ctx, positionID, amount)
+	})
+}
+
+func (h *TradingHandler) CashIn(w http.ResponseWriter, r *http.Request) {
+	h.changeMargin(w, r, "cash in", func(ctx context.Context, client lnmarkets.Exchange, positionID string, amount int64) (*lnmarkets.TradeResponse, error) {
+		return client.CashInContext(ctx, positionID, amount)
+	})
+}
+
+func (h *TradingHandler) changeMargin(w http.ResponseWriter, r *http.Request, action string,
+	apply func(ctx context.Context, client lnmarkets.Exchange, positionID string, amount int64) (*lnmarkets.TradeResponse, error)) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	vars := mux.Vars(r)
+	positionID := vars["id"]
+
+	var request models.MarginAmountRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if request.Amount <= 0 {
+		http.Error(w, "Amount must be a positive number of sats", http.StatusBadRequest)
+		return
+	}
+
+	client, mode, ok := h.exchangeForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	position, err := apply(r.Context(), client, positionID, request.Amount)
+	if err != nil {
+		writeExchangeError(w, "Failed to "+action, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(positionResponse{TradeResponse: *position, Mode: mode})
+}
+
+func positionsResponse(positions []lnmarkets.TradeResponse, mode string) []positionResponse {
+	response := make([]positionResponse, 0, len(positions))
+	for _, position := range positions {
+		response = append(response, positionResponse{TradeResponse: position, Mode: mode})
+	}
+	return response
+}
+
+// writeExchangeError maps an exchange failure to the matching HTTP status so
+// clients can tell a rejected order from an outage.
+func writeExchangeError(w http.ResponseWriter, message string, err error) {
+	var netErr net.Error
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, lnmarkets.ErrBadRequest):
+		status = http.StatusBadRequest
+	case errors.Is(err, lnmarkets.ErrInsufficientFunds):
+		status = http.StatusUnprocessableEntity
+	case errors.Is(err, lnmarkets.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, lnmarkets.ErrUnauthorized), errors.Is(err, lnmarkets.ErrForbidden):
+		// The user's API credentials were rejected, not the caller's token.
+		status = http.StatusFailedDependency
+	case errors.Is(err, lnmarkets.ErrRateLimited):
+		status = http.StatusTooManyRequests
+		var apiErr *lnmarkets.APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(apiErr.RetryAfter.Seconds()+0.5)))
+		}
+	case errors.Is(err, lnmarkets.ErrServer):
+		status = http.StatusBadGateway
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		status = http.StatusGatewayTimeout
+	}
+
+	http.Error(w, message+": "+err.Error(), status)
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"net/http"
+	"strings"
 	"time"
 
 	"btc-trading-bot/internal/services"
@@ -23,6 +24,16 @@ var wsUpgrader = websocket.Upgrader{
 	},
 }
 
+// sourceStatsInterval is how often snapshots streamed with stats=true carry
+// the source statistics.
+const sourceStatsInterval = 5 * time.Second
+
+// pricePayload is a snapshot with the source statistics attached.
+type pricePayload struct {
+	services.PriceSnapshot
+	SourceStats []services.SourceStats `json:"source_stats,omitempty"`
+}
+
 type WebSocketHandler struct {
 	Aggregator  *services.PriceAggregator
 	AuthService *services.AuthService
@@ -48,6 +59,12 @@ func (h *WebSocketHandler) StreamBTCPrice(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	currency, err := services.NormalizeCurrency(r.URL.Query().Get("currency"))
+	if err != nil {
+		http.Error(w, "Invalid currency parameter. Allowed values: "+strings.Join(services.SupportedCurrencies, ", "), http.StatusBadRequest)
+		return
+	}
+
 	conn, err := wsUpgrader.Upgrade(w, r, nil)
 	if err != nil {
 		http.Error(w, "Failed to upgrade connection", http.StatusBadRequest)
@@ -63,6 +80,9 @@ func (h *WebSocketHandler) StreamBTCPrice(w http.ResponseWriter, r *http.Request
 		return nil
 	})
 
+	withStats := r.URL.Query().Get("stats") == "true"
+	var statsSentAt time.Time
+
 	// Subscribe to aggregator
 	ch, unsubscribe := h.Aggregator.Subscribe()
 	defer unsubscribe()
@@ -77,7 +97,17 @@ func (h *WebSocketHandler) StreamBTCPrice(w http.ResponseWriter, r *http.Request
 			if !ok {
 				return
 			}
-			if err := conn.WriteJSON(snap); err != nil {
+			snap, err := h.Aggregator.InCurrency(snap, currency)
+			if err != nil {
+				// No rate yet; the next snapshot may have one.
+				continue
+			}
+			payload := pricePayload{PriceSnapshot: snap}
+			if withStats && time.Since(statsSentAt) >= sourceStatsInterval {
+				payload.SourceStats = h.Aggregator.SourceStats()
+				statsSentAt = time.Now()
+			}
+			if err := conn.WriteJSON(payload); err != nil {
 				return
 			}
 		case <-pingTicker.C:
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// PriceCandle is an OHLC bar of the aggregated BTC/USD price. Sources holds
+// the same bar per price source, keyed by source name.
+type PriceCandle struct {
+	ID         int             `db:"id" json:"-"`
+	Resolution string          `db:"resolution" json:"interval"`
+	OpenTime   time.Time       `db:"open_time" json:"open_time"`
+	Open       float64         `db:"open" json:"open"`
+	High       float64         `db:"high" json:"high"`
+	Low        float64         `db:"low" json:"low"`
+	Close      float64         `db:"close" json:"close"`
+	Samples    int             `db:"samples" json:"samples"`
+	Sources    json.RawMessage `db:"sources" json:"sources"`
+	UpdatedAt  time.Time       `db:"updated_at" json:"updated_at"`
+}
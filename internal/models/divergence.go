@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+)
+
+// DivergenceSample compares the LN Markets index and last price of a network
+// with the aggregated spot price. Basis values are percentages of the aggregated
+// price.
+type DivergenceSample struct {
+	ID             int       `db:"id" json:"-"`
+	Network        string    `db:"network" json:"network"`
+	IndexPrice     float64   `db:"index_price" json:"index_price"`
+	LastPrice      float64   `db:"last_price" json:"last_price"`
+	AggregatePrice float64   `db:"aggregate_price" json:"aggregate_price"`
+	IndexBasis     float64   `db:"index_basis" json:"index_basis"`
+	LastBasis      float64   `db:"last_basis" json:"last_basis"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+}
+
+// DivergenceEvent records the divergence crossing the threshold or
+// returning below it.
+type DivergenceEvent struct {
+	ID             int       `db:"id" json:"id"`
+	Network        string    `db:"network" json:"network"`
+	Event          string    `db:"event" json:"event"`
+	IndexPrice     float64   `db:"index_price" json:"index_price"`
+	LastPrice      float64   `db:"last_price" json:"last_price"`
+	AggregatePrice float64   `db:"aggregate_price" json:"aggregate_price"`
+	IndexBasis     float64   `db:"index_basis" json:"index_basis"`
+	LastBasis      float64   `db:"last_basis" json:"last_basis"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+// FeedEvent records a bot's price feed going stale, recovering, or switching
+// to and from the aggregator fallback.
+type FeedEvent struct {
+	ID        int       `db:"id" json:"id"`
+	UserID    int       `db:"user_id" json:"user_id"`
+	Mode      string    `db:"mode" json:"mode"`
+	Event     string    `db:"event" json:"event"`
+	Source    string    `db:"source" json:"source"`
+	Detail    string    `db:"detail" json:"detail,omitempty"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
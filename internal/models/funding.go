@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+)
+
+// Withdrawal statuses. A withdrawal is created pending, moves to processing
+// once confirmed and ends completed, failed or expired.
+const (
+	WithdrawalPending    = "pending"
+	WithdrawalProcessing = "processing"
+	WithdrawalCompleted  = "completed"
+	WithdrawalFailed     = "failed"
+	WithdrawalExpired    = "expired"
+)
+
+type Withdrawal struct {
+	ID                int        `db:"id" json:"id"`
+	UserID            int        `db:"user_id" json:"user_id"`
+	Amount            int64      `db:"amount" json:"amount"`
+	Destination       string     `db:"destination" json:"destination"`
+	Status            string     `db:"status" json:"status"`
+	ConfirmationToken string     `db:"confirmation_token" json:"-"`
+	ExternalID        string     `db:"external_id" json:"external_id,omitempty"`
+	PaymentHash       string     `db:"payment_hash" json:"payment_hash,omitempty"`
+	Fee               int64      `db:"fee" json:"fee"`
+	Error             string     `db:"error" json:"error,omitempty"`
+	ExpiresAt         time.Time  `db:"expires_at" json:"expires_at"`
+	ConfirmedAt       *time.Time `db:"confirmed_at" json:"confirmed_at"`
+	CreatedAt         time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt         time.Time  `db:"updated_at" json:"updated_at"`
+}
+
+// WithdrawalLimit is a user's rolling 24h withdrawal cap. Raising it only
+// takes effect at PendingEffectiveAt; lowering it applies at once.
+type WithdrawalLimit struct {
+	ID                 int        `db:"id" json:"id"`
+	UserID             int        `db:"user_id" json:"user_id"`
+	DailyLimit         int64      `db:"daily_limit" json:"daily_limit"`
+	PendingDailyLimit  *int64     `db:"pending_daily_limit" json:"pending_daily_limit,omitempty"`
+	PendingEffectiveAt *time.Time `db:"pending_effective_at" json:"pending_effective_at,omitempty"`
+	CreatedAt          time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt          time.Time  `db:"updated_at" json:"updated_at"`
+}
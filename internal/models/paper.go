@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+)
+
+type PaperAccount struct {
+	ID             int       `db:"id" json:"id"`
+	UserID         int       `db:"user_id" json:"user_id"`
+	Balance        float64   `db:"balance" json:"balance"`
+	InitialBalance float64   `db:"initial_balance" json:"initial_balance"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt      time.Time `db:"updated_at" json:"updated_at"`
+}
+
+type PaperPosition struct {
+	ID          int        `db:"id" json:"id"`
+	UserID      int        `db:"user_id" json:"user_id"`
+	PositionID  string     `db:"position_id" json:"position_id"`
+	OrderType   string     `db:"order_type" json:"order_type"`
+	Side        string     `db:"side" json:"side"`
+	Quantity    float64    `db:"quantity" json:"quantity"`
+	EntryPrice  float64    `db:"entry_price" json:"entry_price"`
+	Leverage    float64    `db:"leverage" json:"leverage"`
+	Margin      float64    `db:"margin" json:"margin"`
+	Liquidation float64    `db:"liquidation" json:"liquidation"`
+	TakeProfit  float64    `db:"take_profit" json:"take_profit"`
+	StopLoss    float64    `db:"stop_loss" json:"stop_loss"`
+	OpeningFee  float64    `db:"opening_fee" json:"opening_fee"`
+	ClosingFee  float64    `db:"closing_fee" json:"closing_fee"`
+	ExitPrice   float64    `db:"exit_price" json:"exit_price"`
+	PL          float64    `db:"pl" json:"pl"`
+	Status      string     `db:"status" json:"status"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
+	FilledAt    *time.Time `db:"filled_at" json:"filled_at"`
+	ClosedAt    *time.Time `db:"closed_at" json:"closed_at"`
+}
@@ -1,5 +1,7 @@
 package models
 
+import "encoding/json"
+
 // LNMarketsConfigRequest representa a request para configurar LNMarkets
 // sem os campos que são gerados automaticamente pelo servidor
 type LNMarketsConfigRequest struct {
@@ -35,6 +37,9 @@ type MarginProtectionRequest struct {
 	IsEnabled              interface{} `json:"is_enabled"` // Aceita bool ou string
 	ActivationDistance     float64     `json:"activation_distance"`
 	NewLiquidationDistance float64     `json:"new_liquidation_distance"`
+	MaxDailyMargin         int64       `json:"max_daily_margin"` // Máximo de sats adicionados em 24h (padrão 100000)
+	CooldownSeconds        int         `json:"cooldown_seconds"` // Intervalo mínimo por posição (padrão 300)
+	SkipHedged             bool        `json:"skip_hedged"`      // Ignora longs cobertos por puts (padrão false)
 }
 
 // GetIsEnabled converte o IsEnabled para boolean
@@ -124,6 +129,7 @@ type PriceAlertRequest struct {
 	IsEnabled     interface{} `json:"is_enabled"` // Aceita bool ou string
 	MinPrice      float64     `json:"min_price"`
 	MaxPrice      float64     `json:"max_price"`
+	Currency      string      `json:"currency"` // Moeda de min_price e max_price (padrão USD)
 	CheckInterval int         `json:"check_interval"`
 }
 
@@ -146,3 +152,87 @@ func (r *PriceAlertRequest) GetIsEnabled() bool {
 		return false
 	}
 }
+
+// BotStartRequest representa a request opcional para iniciar o bot
+type BotStartRequest struct {
+	Mode string `json:"mode"` // "live" (padrão) ou "paper"
+}
+
+// CreateTradeRequest representa a request para abrir uma operação de futuros
+// na LN Markets (ordem a mercado ou limitada)
+type CreateTradeRequest struct {
+	Type       string  `json:"type"` // "market" ou "limit"
+	Side       string  `json:"side"` // "buy" ou "sell"
+	Quantity   float64 `json:"quantity"`
+	Margin     int64   `json:"margin"`
+	Leverage   float64 `json:"leverage"`
+	Price      float64 `json:"price"`
+	TakeProfit float64 `json:"takeprofit"`
+	StopLoss   float64 `json:"stoploss"`
+}
+
+// MarginAmountRequest representa a request para adicionar margem ou fazer
+// cash-in de uma posição, com o valor em sats
+type MarginAmountRequest struct {
+	Amount int64 `json:"amount"`
+}
+
+// DepositRequest representa a request para gerar uma invoice de depósito,
+// com o valor em sats
+type DepositRequest struct {
+	Amount int64 `json:"amount"`
+}
+
+// WithdrawalRequest representa a request para sacar para uma invoice BOLT11
+// ou um Lightning address. O valor é opcional quando a invoice já o define
+type WithdrawalRequest struct {
+	Amount      int64  `json:"amount"`
+	Destination string `json:"destination"`
+}
+
+// WithdrawalConfirmRequest representa a confirmação de um saque pendente
+type WithdrawalConfirmRequest struct {
+	ConfirmationToken string `json:"confirmation_token"`
+}
+
+// WithdrawalLimitRequest representa a request para alterar o limite diário
+// de saque, em sats
+type WithdrawalLimitRequest struct {
+	DailyLimit int64 `json:"daily_limit"`
+}
+
+// OptionTradeRequest representa a request para comprar uma opção na
+// LN Markets. A quantidade é em USD
+type OptionTradeRequest struct {
+	InstrumentName string  `json:"instrument_name"` // ex: "BTC.2024-03-29.60000.P"
+	Quantity       float64 `json:"quantity"`
+	Settlement     string  `json:"settlement"` // "cash" (padrão) ou "physical"
+}
+
+// StrategyInstanceRequest representa a request para habilitar uma estratégia
+// registrada no bot do usuário, com os seus próprios parâmetros
+type StrategyInstanceRequest struct {
+	Strategy  string          `json:"strategy"`
+	Params    json.RawMessage `json:"params"`     // Parâmetros da estratégia (padrão {})
+	IsEnabled interface{}     `json:"is_enabled"` // Aceita bool ou string (padrão true)
+	Position  int             `json:"position"`   // Ordem de execução (menor primeiro)
+}
+
+// GetIsEnabled converte o IsEnabled para boolean, habilitando a instância
+// quando o campo é omitido
+func (r *StrategyInstanceRequest) GetIsEnabled() bool {
+	if r.IsEnabled == nil {
+		return true
+	}
+
+	switch v := r.IsEnabled.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true" || v == "on" || v == "1" || v == "yes"
+	case float64:
+		return v != 0
+	default:
+		return false
+	}
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// StrategyInstance enables a registered strategy for a user's bot with its
+// own parameters. Instances run in ascending position.
+type StrategyInstance struct {
+	ID        int             `db:"id" json:"id"`
+	UserID    int             `db:"user_id" json:"user_id"`
+	Strategy  string          `db:"strategy" json:"strategy"`
+	Params    json.RawMessage `db:"params" json:"params"`
+	IsEnabled bool            `db:"is_enabled" json:"is_enabled"`
+	Position  int             `db:"position" json:"position"`
+	CreatedAt time.Time       `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time       `db:"updated_at" json:"updated_at"`
+}
+
+// TrailingStop is the trail of a trailing stop strategy on one position.
+// WaterMark is the best price since entry: the highest for longs, the lowest
+// for shorts.
+type TrailingStop struct {
+	ID         int       `db:"id" json:"id"`
+	UserID     int       `db:"user_id" json:"user_id"`
+	Mode       string    `db:"mode" json:"mode"`
+	PositionID string    `db:"position_id" json:"position_id"`
+	Side       string    `db:"side" json:"side"`
+	EntryPrice float64   `db:"entry_price" json:"entry_price"`
+	WaterMark  float64   `db:"water_mark" json:"water_mark"`
+	StopLoss   float64   `db:"stop_loss" json:"stop_loss"`
+	IsActive   bool      `db:"is_active" json:"is_active"`
+	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt  time.Time `db:"updated_at" json:"updated_at"`
+}
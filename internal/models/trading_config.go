@@ -21,6 +21,9 @@ type MarginProtection struct {
 	IsEnabled              bool      `db:"is_enabled" json:"is_enabled"`
 	ActivationDistance     float64   `db:"activation_distance" json:"activation_distance"`
 	NewLiquidationDistance float64   `db:"new_liquidation_distance" json:"new_liquidation_distance"`
+	MaxDailyMargin         int64     `db:"max_daily_margin" json:"max_daily_margin"`
+	CooldownSeconds        int       `db:"cooldown_seconds" json:"cooldown_seconds"`
+	SkipHedged             bool      `db:"skip_hedged" json:"skip_hedged"`
 	CreatedAt              time.Time `db:"created_at" json:"created_at"`
 	UpdatedAt              time.Time `db:"updated_at" json:"updated_at"`
 }
@@ -58,6 +61,7 @@ type PriceAlert struct {
 	IsEnabled     bool      `db:"is_enabled" json:"is_enabled"`
 	MinPrice      float64   `db:"min_price" json:"min_price"`
 	MaxPrice      float64   `db:"max_price" json:"max_price"`
+	Currency      string    `db:"currency" json:"currency"`
 	CheckInterval int       `db:"check_interval" json:"check_interval"`
 	LastAlert     time.Time `db:"last_alert" json:"last_alert"`
 	CreatedAt     time.Time `db:"created_at" json:"created_at"`
@@ -73,8 +77,43 @@ type TradingOrder struct {
 	Price           float64   `db:"price" json:"price"`
 	Leverage        float64   `db:"leverage" json:"leverage"`
 	Status          string    `db:"status" json:"status"`
+	Mode            string    `db:"mode" json:"mode"`
 	TakeProfitPrice float64   `db:"take_profit_price" json:"take_profit_price"`
 	StopLossPrice   float64   `db:"stop_loss_price" json:"stop_loss_price"`
 	CreatedAt       time.Time `db:"created_at" json:"created_at"`
 	UpdatedAt       time.Time `db:"updated_at" json:"updated_at"`
 }
+
+// MarginIntervention records margin protection acting on a position: margin
+// added in full or limited by the daily cap, or skipped or failed.
+type MarginIntervention struct {
+	ID                int       `db:"id" json:"id"`
+	UserID            int       `db:"user_id" json:"user_id"`
+	Mode              string    `db:"mode" json:"mode"`
+	PositionID        string    `db:"position_id" json:"position_id"`
+	Price             float64   `db:"price" json:"price"`
+	LiquidationBefore float64   `db:"liquidation_before" json:"liquidation_before"`
+	LiquidationAfter  float64   `db:"liquidation_after" json:"liquidation_after"`
+	TargetLiquidation float64   `db:"target_liquidation" json:"target_liquidation"`
+	MarginNeeded      int64     `db:"margin_needed" json:"margin_needed"`
+	MarginAdded       int64     `db:"margin_added" json:"margin_added"`
+	Status            string    `db:"status" json:"status"`
+	Detail            string    `db:"detail" json:"detail,omitempty"`
+	CreatedAt         time.Time `db:"created_at" json:"created_at"`
+}
+
+// TakeProfitUpdate records the take profit strategy setting a position's take
+// profit on the exchange, or failing to.
+type TakeProfitUpdate struct {
+	ID                 int       `db:"id" json:"id"`
+	UserID             int       `db:"user_id" json:"user_id"`
+	Mode               string    `db:"mode" json:"mode"`
+	PositionID         string    `db:"position_id" json:"position_id"`
+	Side               string    `db:"side" json:"side"`
+	Price              float64   `db:"price" json:"price"`
+	PreviousTakeProfit float64   `db:"previous_take_profit" json:"previous_take_profit"`
+	TakeProfit         float64   `db:"take_profit" json:"take_profit"`
+	Status             string    `db:"status" json:"status"`
+	Detail             string    `db:"detail" json:"detail,omitempty"`
+	CreatedAt          time.Time `db:"created_at" json:"created_at"`
+}
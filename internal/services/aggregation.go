@@ -0,0 +1,150 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Aggregation methods selected by AGG_METHOD.
+const (
+	AggregateMedian      = "median"
+	AggregateMean        = "mean"
+	AggregateTrimmedMean = "trimmed"
+	AggregateVWAP        = "vwap"
+)
+
+// aggregationConfig controls how source quotes are combined.
+type aggregationConfig struct {
+	method string
+	// trim is the fraction of quotes dropped from each end by the trimmed
+	// mean.
+	trim float64
+	// maxDeviation rejects quotes further than this fraction from the
+	// median; zero disables rejection.
+	maxDeviation float64
+	// minSources is the quorum of accepted quotes below which no snapshot is
+	// published.
+	minSources int
+}
+
+// loadAggregationConfig reads AGG_METHOD (default median), AGG_TRIM_PERCENT
+// (default 20), AGG_MAX_DEVIATION_PERCENT (default 1) and AGG_MIN_SOURCES
+// (default a majority of the configured sources).
+func loadAggregationConfig(sourceCount int) (aggregationConfig, error) {
+	config := aggregationConfig{
+		method:       AggregateMedian,
+		trim:         envFloat("AGG_TRIM_PERCENT", 20) / 100,
+		maxDeviation: envFloat("AGG_MAX_DEVIATION_PERCENT", 1) / 100,
+		minSources:   sourceCount/2 + 1,
+	}
+
+	if method := strings.ToLower(os.Getenv("AGG_METHOD")); method != "" {
+		switch method {
+		case AggregateMedian, AggregateMean, AggregateTrimmedMean, AggregateVWAP:
+			config.method = method
+		default:
+			return config, fmt.Errorf("unknown aggregation method %q", method)
+		}
+	}
+	if config.trim < 0 || config.trim >= 0.5 {
+		return config, fmt.Errorf("AGG_TRIM_PERCENT must be between 0 and 50")
+	}
+	if config.maxDeviation < 0 {
+		return config, fmt.Errorf("AGG_MAX_DEVIATION_PERCENT must not be negative")
+	}
+	if value := os.Getenv("AGG_MIN_SOURCES"); value != "" {
+		minSources, err := strconv.Atoi(value)
+		if err != nil || minSources < 1 || minSources > sourceCount {
+			return config, fmt.Errorf("AGG_MIN_SOURCES must be between 1 and %d", sourceCount)
+		}
+		config.minSources = minSources
+	}
+	return config, nil
+}
+
+// sourceQuote is a quote with the weight its source was configured with.
+type sourceQuote struct {
+	name   string
+	weight float64
+	Quote
+}
+
+// rejectOutliers drops quotes deviating more than maxDeviation from the
+// median of all quotes, returning the accepted ones and a reason for each
+// rejection.
+func rejectOutliers(quotes []sourceQuote, maxDeviation float64, rejected map[string]string) []sourceQuote {
+	if maxDeviation <= 0 || len(quotes) == 0 {
+		return quotes
+	}
+
+	median := medianPrice(quotes)
+	accepted := make([]sourceQuote, 0, len(quotes))
+	for _, q := range quotes {
+		deviation := math.Abs(q.Price-median) / median
+		if deviation > maxDeviation {
+			rejected[q.name] = fmt.Sprintf("deviates %.2f%% from median %.2f", deviation*100, median)
+			continue
+		}
+		accepted = append(accepted, q)
+	}
+	return accepted
+}
+
+// aggregate combines the accepted quotes with method. quotes must not be
+// empty.
+func aggregate(method string, quotes []sourceQuote, trim float64) float64 {
+	switch method {
+	case AggregateMean:
+		return weightedMean(quotes, func(q sourceQuote) float64 { return q.weight })
+	case AggregateTrimmedMean:
+		sorted := sortedByPrice(quotes)
+		cut := int(float64(len(sorted)) * trim)
+		return weightedMean(sorted[cut:len(sorted)-cut], func(q sourceQuote) float64 { return q.weight })
+	case AggregateVWAP:
+		// Sources that report no volume are left out; without any volume
+		// this is the weighted mean.
+		var withVolume []sourceQuote
+		for _, q := range quotes {
+			if q.Volume > 0 {
+				withVolume = append(withVolume, q)
+			}
+		}
+		if len(withVolume) == 0 {
+			return weightedMean(quotes, func(q sourceQuote) float64 { return q.weight })
+		}
+		return weightedMean(withVolume, func(q sourceQuote) float64 { return q.weight * q.Volume })
+	default:
+		return medianPrice(quotes)
+	}
+}
+
+func weightedMean(quotes []sourceQuote, weight func(sourceQuote) float64) float64 {
+	sum, total := 0.0, 0.0
+	for _, q := range quotes {
+		w := weight(q)
+		sum += q.Price * w
+		total += w
+	}
+	return sum / total
+}
+
+func medianPrice(quotes []sourceQuote) float64 {
+	sorted := sortedByPrice(quotes)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1].Price + sorted[mid].Price) / 2
+	}
+	return sorted[mid].Price
+}
+
+func sortedByPrice(quotes []sourceQuote) []sourceQuote {
+	sorted := append([]sourceQuote{}, quotes...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Price < sorted[j].Price
+	})
+	return sorted
+}
@@ -0,0 +1,234 @@
+package services
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadAggregationConfigQuorum(t *testing.T) {
+	for sources, want := range map[int]int{1: 1, 2: 2, 3: 2, 4: 3, 5: 3} {
+		config, err := loadAggregationConfig(sources)
+		if err != nil || config.minSources != want {
+			t.Errorf("quorum of %d sources = %d, %v, want %d", sources, config.minSources, err, want)
+		}
+	}
+
+	tests := []struct {
+		value string
+		want  int
+		err   bool
+	}{
+		{"1", 1, false},
+		{"3", 3, false},
+		{"0", 0, true},
+		{"4", 0, true},
+		{"two", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run("AGG_MIN_SOURCES="+tt.value, func(t *testing.T) {
+			t.Setenv("AGG_MIN_SOURCES", tt.value)
+			config, err := loadAggregationConfig(3)
+			if tt.err {
+				if err == nil {
+					t.Errorf("loadAggregationConfig accepted quorum %s of 3 sources", tt.value)
+				}
+				return
+			}
+			if err != nil || config.minSources != tt.want {
+				t.Errorf("quorum = %d, %v, want %d", config.minSources, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadAggregationConfigSettings(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want aggregationConfig
+		err  string
+	}{
+		{name: "defaults", want: aggregationConfig{method: AggregateMedian, trim: 0.2, maxDeviation: 0.01, minSources: 2}},
+		{name: "trimmed", env: map[string]string{"AGG_METHOD": "Trimmed", "AGG_TRIM_PERCENT": "10", "AGG_MAX_DEVIATION_PERCENT": "0"},
+			want: aggregationConfig{method: AggregateTrimmedMean, trim: 0.1, maxDeviation: 0, minSources: 2}},
+		{name: "unknown method", env: map[string]string{"AGG_METHOD": "mode"}, err: "unknown aggregation method"},
+		{name: "trim half", env: map[string]string{"AGG_TRIM_PERCENT": "50"}, err: "AGG_TRIM_PERCENT"},
+		{name: "negative trim", env: map[string]string{"AGG_TRIM_PERCENT": "-1"}, err: "AGG_TRIM_PERCENT"},
+		{name: "negative deviation", env: map[string]string{"AGG_MAX_DEVIATION_PERCENT": "-1"}, err: "AGG_MAX_DEVIATION_PERCENT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			config, err := loadAggregationConfig(3)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("loadAggregationConfig error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil || config != tt.want {
+				t.Errorf("loadAggregationConfig = %+v, %v, want %+v", config, err, tt.want)
+			}
+		})
+	}
+}
+
+func testQuotes(prices ...float64) []sourceQuote {
+	quotes := make([]sourceQuote, len(prices))
+	for i, price := range prices {
+		quotes[i] = sourceQuote{name: string(rune('a' + i)), weight: 1, Quote: Quote{Price: price}}
+	}
+	return quotes
+}
+
+func quoteNames(quotes []sourceQuote) string {
+	var names []string
+	for _, q := range quotes {
+		names = append(names, q.name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func TestRejectOutliers(t *testing.T) {
+	tests := []struct {
+		name         string
+		quotes       []sourceQuote
+		maxDeviation float64
+		accepted     string
+		rejected     int
+	}{
+		{"two close", testQuotes(100, 101), 0.01, "a,b", 0},
+		// Two quotes more than twice the limit apart are both off their
+		// midpoint, so both are rejected.
+		{"two apart", testQuotes(100, 103), 0.01, "", 2},
+		{"one outlier of three", testQuotes(100, 100.5, 110), 0.01, "a,b", 1},
+		{"one outlier of four", testQuotes(100, 100.2, 100.4, 120), 0.01, "a,b,c", 1},
+		{"at the limit", testQuotes(99, 100, 101), 0.01, "a,b,c", 0},
+		{"disabled", testQuotes(100, 200), 0, "a,b", 0},
+		{"none", nil, 0.01, "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rejected := make(map[string]string)
+			accepted := rejectOutliers(tt.quotes, tt.maxDeviation, rejected)
+			if quoteNames(accepted) != tt.accepted || len(rejected) != tt.rejected {
+				t.Errorf("accepted %q rejected %v, want %q and %d rejected", quoteNames(accepted), rejected, tt.accepted, tt.rejected)
+			}
+			for name, reason := range rejected {
+				if !strings.Contains(reason, "deviates") {
+					t.Errorf("%s rejected for %q", name, reason)
+				}
+			}
+		})
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	weighted := testQuotes(100, 200)
+	weighted[1].weight = 3
+	withVolume := testQuotes(100, 200)
+	withVolume[0].Volume, withVolume[1].Volume = 1, 3
+	partialVolume := testQuotes(100, 200)
+	partialVolume[1].Volume = 2
+
+	tests := []struct {
+		name   string
+		method string
+		quotes []sourceQuote
+		trim   float64
+		want   float64
+	}{
+		{"median odd", AggregateMedian, testQuotes(3, 1, 2), 0.2, 2},
+		{"median even", AggregateMedian, testQuotes(1, 2, 3, 10), 0.2, 2.5},
+		{"median single", AggregateMedian, testQuotes(42), 0.2, 42},
+		{"mean weighted", AggregateMean, weighted, 0.2, 175},
+		{"trimmed five", AggregateTrimmedMean, testQuotes(100, 1, 3, 2, 4), 0.2, 3},
+		// 20% of four quotes rounds down to none trimmed.
+		{"trimmed four", AggregateTrimmedMean, testQuotes(1, 2, 3, 100), 0.2, 26.5},
+		{"trimmed four by a quarter", AggregateTrimmedMean, testQuotes(1, 2, 3, 100), 0.25, 2.5},
+		{"trimmed two", AggregateTrimmedMean, testQuotes(100, 102), 0.4, 101},
+		{"vwap", AggregateVWAP, withVolume, 0.2, 175},
+		{"vwap skips no volume", AggregateVWAP, partialVolume, 0.2, 200},
+		{"vwap without volume", AggregateVWAP, weighted, 0.2, 175},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := aggregate(tt.method, tt.quotes, tt.trim); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("aggregate = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// namedSource is a price source that is never fetched.
+type namedSource string
+
+func (s namedSource) Name() string                                  { return string(s) }
+func (s namedSource) FetchQuote(ctx context.Context) (Quote, error) { return Quote{}, nil }
+
+func TestAggregateLatestQuorum(t *testing.T) {
+	fresh := time.Now()
+	stale := fresh.Add(-time.Minute)
+	tests := []struct {
+		name     string
+		quotes   map[string]Quote
+		at       map[string]time.Time
+		ok       bool
+		price    float64
+		rejected string
+	}{
+		{"all sources", map[string]Quote{"a": {Price: 100}, "b": {Price: 100.4}, "c": {Price: 100.2}}, nil, true, 100.2, ""},
+		{"quorum of two", map[string]Quote{"a": {Price: 100}, "b": {Price: 100.4}}, nil, true, 100.2, "c"},
+		{"below quorum", map[string]Quote{"a": {Price: 100}}, nil, false, 0, ""},
+		{"outlier breaks quorum", map[string]Quote{"a": {Price: 100}, "b": {Price: 103}}, nil, false, 0, ""},
+		{"outlier dropped", map[string]Quote{"a": {Price: 100}, "b": {Price: 100.4}, "c": {Price: 120}}, nil, true, 100.2, "c"},
+		{"stale quote", map[string]Quote{"a": {Price: 100}, "b": {Price: 100.4}, "c": {Price: 90}},
+			map[string]time.Time{"c": stale}, true, 100.2, "c"},
+		{"invalid price", map[string]Quote{"a": {Price: 100}, "b": {Price: 100.4}, "c": {Price: math.NaN()}}, nil, true, 100.2, "c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &PriceAggregator{
+				quoteMaxAge: 3 * time.Second,
+				aggregation: aggregationConfig{method: AggregateMedian, maxDeviation: 0.01, minSources: 2},
+				states:      make(map[string]*sourceState),
+			}
+			for _, name := range []string{"a", "b", "c"} {
+				p.sources = append(p.sources, sourceConfig{source: namedSource(name), weight: 1})
+			}
+			for name, quote := range tt.quotes {
+				at, ok := tt.at[name]
+				if !ok {
+					at = fresh
+				}
+				p.states[name] = &sourceState{quote: quote, updatedAt: at}
+			}
+
+			snapshot, ok := p.aggregateLatest()
+			if ok != tt.ok {
+				t.Fatalf("aggregateLatest ok = %v, want %v (snapshot %+v)", ok, tt.ok, snapshot)
+			}
+			if !ok {
+				return
+			}
+			if math.Abs(snapshot.Price-tt.price) > 1e-9 {
+				t.Errorf("price = %v, want %v", snapshot.Price, tt.price)
+			}
+			if tt.rejected != "" {
+				if _, ok := snapshot.Rejected[tt.rejected]; !ok {
+					t.Errorf("rejected = %v, want %s rejected", snapshot.Rejected, tt.rejected)
+				}
+				if _, ok := snapshot.Sources[tt.rejected]; ok {
+					t.Errorf("sources = %v include rejected %s", snapshot.Sources, tt.rejected)
+				}
+			}
+		})
+	}
+}
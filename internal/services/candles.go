@@ -0,0 +1,252 @@
+package services
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"btc-trading-bot/internal/database"
+	"btc-trading-bot/internal/models"
+	"btc-trading-bot/pkg/lnmarkets"
+)
+
+// CandleIntervals are the bar sizes built from aggregated snapshots.
+var CandleIntervals = map[string]time.Duration{
+	"1m": time.Minute,
+	"5m": 5 * time.Minute,
+	"1h": time.Hour,
+	"1d": 24 * time.Hour,
+}
+
+// candleFlushInterval is how often open bars are written, so queries and a
+// restart see at most this much missing.
+const candleFlushInterval = 10 * time.Second
+
+// CandleService turns aggregated price snapshots into OHLC bars and
+// persists them to price_candles.
+type CandleService struct {
+	db         *database.Database
+	aggregator *PriceAggregator
+	mu         sync.Mutex
+	open       map[string]*candle
+	stopChan   chan struct{}
+	done       chan struct{}
+}
+
+type candle struct {
+	resolution string
+	openTime   time.Time
+	bar        ohlc
+	samples    int
+	sources    map[string]*ohlc
+	dirty      bool
+}
+
+type ohlc struct {
+	Open  float64 `json:"open"`
+	High  float64 `json:"high"`
+	Low   float64 `json:"low"`
+	Close float64 `json:"close"`
+}
+
+func (b *ohlc) add(price float64, first bool) {
+	if first {
+		*b = ohlc{Open: price, High: price, Low: price, Close: price}
+		return
+	}
+	b.High = max(b.High, price)
+	b.Low = min(b.Low, price)
+	b.Close = price
+}
+
+func NewCandleService(db *database.Database, aggregator *PriceAggregator) *CandleService {
+	return &CandleService{
+		db:         db,
+		aggregator: aggregator,
+		open:       make(map[string]*candle),
+		stopChan:   make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+func (s *CandleService) Start() {
+	snapshots, unsubscribe := s.aggregator.Subscribe()
+	go func() {
+		defer close(s.done)
+		defer unsubscribe()
+
+		ticker := time.NewTicker(candleFlushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stopChan:
+				s.flush()
+				return
+			case snap, ok := <-snapshots:
+				if !ok {
+					return
+				}
+				s.add(snap)
+			case <-ticker.C:
+				s.flush()
+			}
+		}
+	}()
+}
+
+// Stop writes the open bars and stops building candles.
+func (s *CandleService) Stop() {
+	close(s.stopChan)
+	<-s.done
+}
+
+// add folds a snapshot into every open bar. Bars the snapshot falls after
+// are closed and written.
+func (s *CandleService) add(snap PriceSnapshot) {
+	for _, c := range s.fold(snap) {
+		if err := s.save(c); err != nil {
+			log.Printf("Error saving %s candle at %s: %v", c.resolution, c.openTime, err)
+		}
+	}
+}
+
+// fold folds a snapshot into every open bar and returns the bars it closed
+// that have changes not yet written. Snapshots older than a resolution's
+// open bar are ignored for it.
+func (s *CandleService) fold(snap PriceSnapshot) []candle {
+	at := time.UnixMilli(snap.Timestamp).UTC()
+
+	var closed []candle
+	s.mu.Lock()
+	for resolution, d := range CandleIntervals {
+		openTime := at.Truncate(d)
+		c := s.open[resolution]
+		if c != nil && openTime.Before(c.openTime) {
+			continue
+		}
+		if c != nil && openTime.After(c.openTime) {
+			if c.dirty {
+				closed = append(closed, c.copy())
+			}
+			c = nil
+		}
+		if c == nil {
+			c = &candle{resolution: resolution, openTime: openTime, sources: make(map[string]*ohlc)}
+			s.open[resolution] = c
+		}
+
+		c.bar.add(snap.Price, c.samples == 0)
+		c.samples++
+		for name, price := range snap.Sources {
+			bar, ok := c.sources[name]
+			if !ok {
+				bar = &ohlc{}
+				c.sources[name] = bar
+			}
+			bar.add(price, !ok)
+		}
+		c.dirty = true
+	}
+	s.mu.Unlock()
+	return closed
+}
+
+// flush writes the open bars that changed since they were last written.
+func (s *CandleService) flush() {
+	for _, c := range s.dirtyBars() {
+		if err := s.save(c); err != nil {
+			log.Printf("Error saving %s candle at %s: %v", c.resolution, c.openTime, err)
+		}
+	}
+}
+
+// dirtyBars returns copies of the open bars that changed since they were
+// last returned.
+func (s *CandleService) dirtyBars() []candle {
+	var pending []candle
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.open {
+		if c.dirty {
+			pending = append(pending, c.copy())
+			c.dirty = false
+		}
+	}
+	return pending
+}
+
+func (c *candle) copy() candle {
+	cp := *c
+	cp.sources = make(map[string]*ohlc, len(c.sources))
+	for name, bar := range c.sources {
+		b := *bar
+		cp.sources[name] = &b
+	}
+	return cp
+}
+
+// save upserts a bar. A bar already stored for the same period, e.g. by a
+// process that was restarted mid-bar, keeps its open and widens its range,
+// and so does each source's bar within it.
+func (s *CandleService) save(c candle) error {
+	sources, err := json.Marshal(c.sources)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO price_candles (resolution, open_time, open, high, low, close, samples, sources, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (resolution, open_time) DO UPDATE SET
+			high = GREATEST(price_candles.high, EXCLUDED.high),
+			low = LEAST(price_candles.low, EXCLUDED.low),
+			close = EXCLUDED.close,
+			samples = GREATEST(price_candles.samples, EXCLUDED.samples),
+			sources = (
+				SELECT COALESCE(jsonb_object_agg(key, CASE
+					WHEN stored.value IS NULL THEN incoming.value
+					WHEN incoming.value IS NULL THEN stored.value
+					ELSE jsonb_build_object(
+						'open', stored.value->'open',
+						'high', GREATEST((stored.value->>'high')::numeric, (incoming.value->>'high')::numeric),
+						'low', LEAST((stored.value->>'low')::numeric, (incoming.value->>'low')::numeric),
+						'close', incoming.value->'close')
+				END), '{}'::jsonb)
+				FROM jsonb_each(price_candles.sources) stored
+				FULL JOIN jsonb_each(EXCLUDED.sources) incoming USING (key)
+			),
+			updated_at = EXCLUDED.updated_at
+	`, c.resolution, c.openTime, c.bar.Open, c.bar.High, c.bar.Low, c.bar.Close, c.samples, string(sources), time.Now().UTC())
+	return err
+}
+
+// Candles returns bars of the given interval in ascending time order. From
+// and To are Unix milliseconds; without a limit at most 500 bars, the most
+// recent ones, are returned.
+func (s *CandleService) Candles(resolution string, r lnmarkets.HistoryRange) ([]models.PriceCandle, error) {
+	limit := r.Limit
+	if limit <= 0 {
+		limit = 500
+	}
+	from := time.Unix(0, 0).UTC()
+	if r.From > 0 {
+		from = time.UnixMilli(r.From).UTC()
+	}
+	to := time.Now().UTC()
+	if r.To > 0 {
+		to = time.UnixMilli(r.To).UTC()
+	}
+
+	candles := []models.PriceCandle{}
+	err := s.db.Select(&candles, `
+		SELECT * FROM (
+			SELECT * FROM price_candles
+			WHERE resolution = $1 AND open_time >= $2 AND open_time <= $3
+			ORDER BY open_time DESC
+			LIMIT $4
+		) recent ORDER BY open_time ASC
+	`, resolution, from, to, limit)
+	return candles, err
+}
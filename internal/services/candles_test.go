@@ -0,0 +1,117 @@
+package services
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+var candleBase = time.Date(2026, 10, 17, 10, 0, 0, 0, time.UTC)
+
+func candleSnapshot(offset time.Duration, price float64, sources map[string]float64) PriceSnapshot {
+	return PriceSnapshot{Price: price, Timestamp: candleBase.Add(offset).UnixMilli(), Sources: sources}
+}
+
+func resolutions(candles []candle) []string {
+	var names []string
+	for _, c := range candles {
+		names = append(names, c.resolution)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestCandleFoldBuildsBars(t *testing.T) {
+	s := NewCandleService(nil, nil)
+	s.fold(candleSnapshot(5*time.Second, 100, nil))
+	s.fold(candleSnapshot(20*time.Second, 104, nil))
+	s.fold(candleSnapshot(40*time.Second, 98, nil))
+	s.fold(candleSnapshot(55*time.Second, 101, nil))
+
+	want := ohlc{Open: 100, High: 104, Low: 98, Close: 101}
+	for resolution, d := range CandleIntervals {
+		c := s.open[resolution]
+		if c == nil || c.bar != want || c.samples != 4 || !c.openTime.Equal(candleBase.Truncate(d)) {
+			t.Errorf("%s bar = %+v, want %+v over 4 samples from %s", resolution, c, want, candleBase.Truncate(d))
+		}
+	}
+}
+
+func TestCandleFoldRollsOver(t *testing.T) {
+	s := NewCandleService(nil, nil)
+	s.fold(candleSnapshot(10*time.Second, 100, nil))
+	s.fold(candleSnapshot(50*time.Second, 102, nil))
+
+	closed := s.fold(candleSnapshot(time.Minute+time.Second, 103, nil))
+	if got := resolutions(closed); len(got) != 1 || got[0] != "1m" {
+		t.Fatalf("closed = %v, want only the 1m bar", got)
+	}
+	if bar := closed[0].bar; bar != (ohlc{Open: 100, High: 102, Low: 100, Close: 102}) || closed[0].samples != 2 {
+		t.Errorf("closed 1m bar = %+v over %d samples", bar, closed[0].samples)
+	}
+
+	minute := s.open["1m"]
+	if !minute.openTime.Equal(candleBase.Add(time.Minute)) || minute.bar != (ohlc{Open: 103, High: 103, Low: 103, Close: 103}) {
+		t.Errorf("new 1m bar = %s %+v, want a fresh bar at 10:01", minute.openTime, minute.bar)
+	}
+	if five := s.open["5m"]; five.samples != 3 || five.bar.Close != 103 {
+		t.Errorf("5m bar = %+v over %d samples, want it to continue", five.bar, five.samples)
+	}
+
+	// Crossing the hour closes every bar but the daily one.
+	closed = s.fold(candleSnapshot(time.Hour, 99, nil))
+	if got := resolutions(closed); len(got) != 3 || got[0] != "1h" || got[1] != "1m" || got[2] != "5m" {
+		t.Errorf("closed at 11:00 = %v, want 1h, 1m and 5m", got)
+	}
+}
+
+func TestCandleFoldSkipsWrittenAndLateBars(t *testing.T) {
+	s := NewCandleService(nil, nil)
+	s.fold(candleSnapshot(10*time.Second, 100, nil))
+	if got := len(s.dirtyBars()); got != len(CandleIntervals) {
+		t.Fatalf("dirty bars = %d, want %d", got, len(CandleIntervals))
+	}
+	if got := len(s.dirtyBars()); got != 0 {
+		t.Fatalf("dirty bars after flush = %d, want none", got)
+	}
+
+	// A bar already written in full is not written again when it closes.
+	if closed := s.fold(candleSnapshot(2*time.Minute, 101, nil)); len(closed) != 0 {
+		t.Errorf("closed = %v, want nothing to write", resolutions(closed))
+	}
+
+	// A snapshot from before the open 1m bar is dropped for it, but still
+	// counts for the longer bars it falls in.
+	s.fold(candleSnapshot(30*time.Second, 90, nil))
+	if minute := s.open["1m"]; minute.bar.Low != 101 || minute.samples != 1 {
+		t.Errorf("1m bar = %+v over %d samples, want the late price ignored", minute.bar, minute.samples)
+	}
+	if five := s.open["5m"]; five.bar.Low != 90 || five.samples != 3 {
+		t.Errorf("5m bar = %+v over %d samples, want the late price included", five.bar, five.samples)
+	}
+}
+
+func TestCandleFoldTracksSources(t *testing.T) {
+	s := NewCandleService(nil, nil)
+	s.fold(candleSnapshot(0, 100, map[string]float64{"binance": 100, "kraken": 101}))
+	s.fold(candleSnapshot(20*time.Second, 102, map[string]float64{"binance": 103}))
+	s.fold(candleSnapshot(40*time.Second, 101, map[string]float64{"binance": 99, "kraken": 102, "coinbase": 101}))
+
+	minute := s.open["1m"]
+	want := map[string]ohlc{
+		"binance":  {Open: 100, High: 103, Low: 99, Close: 99},
+		"kraken":   {Open: 101, High: 102, Low: 101, Close: 102},
+		"coinbase": {Open: 101, High: 101, Low: 101, Close: 101},
+	}
+	for name, bar := range want {
+		if got := minute.sources[name]; got == nil || *got != bar {
+			t.Errorf("%s bar = %+v, want %+v", name, got, bar)
+		}
+	}
+
+	// Closed bars are copies the open bars no longer change.
+	closed := s.fold(candleSnapshot(time.Minute, 150, map[string]float64{"binance": 150}))
+	if got := *closed[0].sources["binance"]; got.High != 103 {
+		t.Errorf("closed binance bar = %+v, want it unchanged by the next minute", got)
+	}
+}
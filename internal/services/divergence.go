@@ -0,0 +1,280 @@
+package services
+
+import (
+	"context"
+	"log"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"btc-trading-bot/internal/database"
+	"btc-trading-bot/internal/models"
+	"btc-trading-bot/pkg/lnmarkets"
+)
+
+// Divergence events recorded in divergence_events.
+const (
+	DivergenceEventAbnormal = "abnormal"
+	DivergenceEventCleared  = "cleared"
+)
+
+// divergenceClearRatio is the fraction of the threshold the basis must fall
+// below before an abnormal divergence clears, so a basis hovering at the
+// threshold does not flap.
+const divergenceClearRatio = 0.8
+
+// LN Markets networks. Each has its own index and last price, so divergence
+// is tracked per network.
+const (
+	NetworkMainnet = "mainnet"
+	NetworkTestnet = "testnet"
+)
+
+func networkName(testnet bool) string {
+	if testnet {
+		return NetworkTestnet
+	}
+	return NetworkMainnet
+}
+
+// DefaultNetwork is the network public market data is read from, testnet
+// when LN_MARKETS_IS_TESTNET=true.
+func DefaultNetwork() string {
+	return networkName(os.Getenv("LN_MARKETS_IS_TESTNET") == "true")
+}
+
+// DivergenceMonitor samples, for mainnet and testnet, the basis between the
+// LN Markets index and last price and the aggregated spot price every
+// DIVERGENCE_INTERVAL seconds (default 15), storing each sample in
+// price_divergence. When either basis exceeds DIVERGENCE_THRESHOLD_PERCENT
+// (default 0.5) the network's divergence is abnormal; with
+// DIVERGENCE_BLOCK_ENTRIES=true bots on that network place no new entries
+// until it clears.
+type DivergenceMonitor struct {
+	db         *database.Database
+	aggregator *PriceAggregator
+	interval   time.Duration
+	threshold  float64
+	blockEntry bool
+	stopChan   chan struct{}
+	stopOnce   sync.Once
+	networks   map[string]*networkDivergence
+}
+
+// networkDivergence is the divergence state of one network.
+type networkDivergence struct {
+	name     string
+	client   *lnmarkets.Client
+	mu       sync.RWMutex
+	abnormal bool
+	latest   *models.DivergenceSample
+}
+
+func NewDivergenceMonitor(db *database.Database, aggregator *PriceAggregator) *DivergenceMonitor {
+	interval := envFloat("DIVERGENCE_INTERVAL", 15)
+	if interval <= 0 {
+		interval = 15
+	}
+	m := &DivergenceMonitor{
+		db:         db,
+		aggregator: aggregator,
+		interval:   time.Duration(interval * float64(time.Second)),
+		threshold:  envFloat("DIVERGENCE_THRESHOLD_PERCENT", 0.5),
+		blockEntry: os.Getenv("DIVERGENCE_BLOCK_ENTRIES") == "true",
+		stopChan:   make(chan struct{}),
+		networks:   make(map[string]*networkDivergence),
+	}
+	for _, testnet := range []bool{false, true} {
+		name := networkName(testnet)
+		m.networks[name] = &networkDivergence{name: name, client: lnmarkets.NewClient("", "", "", testnet)}
+	}
+	return m
+}
+
+func (m *DivergenceMonitor) Start() {
+	go func() {
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-m.stopChan:
+				return
+			case <-ticker.C:
+				m.sample()
+			}
+		}
+	}()
+}
+
+func (m *DivergenceMonitor) Stop() {
+	m.stopOnce.Do(func() { close(m.stopChan) })
+}
+
+// sample compares each network's LN Markets ticker with the latest
+// aggregated price. Nothing is recorded without a recent aggregated price.
+func (m *DivergenceMonitor) sample() {
+	snapshot := m.aggregator.Latest()
+	if snapshot.Timestamp == 0 || time.Since(time.UnixMilli(snapshot.Timestamp)) > m.interval {
+		return
+	}
+	baseline, ok := divergenceBaseline(snapshot)
+	if !ok {
+		return
+	}
+
+	for _, network := range m.networks {
+		ctx, cancel := context.WithTimeout(context.Background(), m.interval)
+		ticker, err := network.client.GetTickerContext(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("Error fetching %s ticker for divergence monitor: %v", network.name, err)
+			continue
+		}
+		if ticker.Index <= 0 || ticker.LastPrice <= 0 {
+			continue
+		}
+
+		m.record(network, ticker, baseline)
+	}
+}
+
+// divergenceBaseline is the spot price LN Markets is compared with: the
+// aggregated price, or, when the LN Markets index is one of its sources, the
+// median of the other sources so the index is not compared with itself. It
+// reports false when no other source was accepted.
+func divergenceBaseline(snapshot PriceSnapshot) (float64, bool) {
+	if _, ok := snapshot.Sources["lnmarkets"]; !ok {
+		return snapshot.Price, snapshot.Price > 0
+	}
+
+	var quotes []sourceQuote
+	for name, price := range snapshot.Sources {
+		if name != "lnmarkets" {
+			quotes = append(quotes, sourceQuote{name: name, Quote: Quote{Price: price}})
+		}
+	}
+	if len(quotes) == 0 {
+		return 0, false
+	}
+	return medianPrice(quotes), true
+}
+
+func (m *DivergenceMonitor) record(network *networkDivergence, ticker *lnmarkets.Ticker, aggregate float64) {
+	sample := models.DivergenceSample{
+		Network:        network.name,
+		IndexPrice:     ticker.Index,
+		LastPrice:      ticker.LastPrice,
+		AggregatePrice: aggregate,
+		IndexBasis:     (ticker.Index - aggregate) / aggregate * 100,
+		LastBasis:      (ticker.LastPrice - aggregate) / aggregate * 100,
+		CreatedAt:      time.Now(),
+	}
+	basis := max(math.Abs(sample.IndexBasis), math.Abs(sample.LastBasis))
+
+	network.mu.Lock()
+	network.latest = &sample
+	event := ""
+	switch {
+	case !network.abnormal && m.threshold > 0 && basis > m.threshold:
+		network.abnormal = true
+		event = DivergenceEventAbnormal
+	case network.abnormal && basis < m.threshold*divergenceClearRatio:
+		network.abnormal = false
+		event = DivergenceEventCleared
+	}
+	network.mu.Unlock()
+
+	_, err := m.db.Exec(`
+		INSERT INTO price_divergence (network, index_price, last_price, aggregate_price, index_basis, last_basis, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, sample.Network, sample.IndexPrice, sample.LastPrice, sample.AggregatePrice, sample.IndexBasis, sample.LastBasis, sample.CreatedAt)
+	if err != nil {
+		log.Printf("Error recording %s divergence sample: %v", network.name, err)
+	}
+
+	if event == "" {
+		return
+	}
+	log.Printf("Index divergence on %s %s: index %.2f (%+.3f%%), last price %.2f (%+.3f%%), aggregate %.2f",
+		network.name, event, sample.IndexPrice, sample.IndexBasis, sample.LastPrice, sample.LastBasis, sample.AggregatePrice)
+	_, err = m.db.Exec(`
+		INSERT INTO divergence_events (network, event, index_price, last_price, aggregate_price, index_basis, last_basis, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, sample.Network, event, sample.IndexPrice, sample.LastPrice, sample.AggregatePrice, sample.IndexBasis, sample.LastBasis, sample.CreatedAt)
+	if err != nil {
+		log.Printf("Error recording %s divergence event: %v", network.name, err)
+	}
+}
+
+// BlocksEntries reports whether new entries on network must wait for an
+// abnormal divergence to clear.
+func (m *DivergenceMonitor) BlocksEntries(network string) bool {
+	state, ok := m.networks[network]
+	if !m.blockEntry || !ok {
+		return false
+	}
+	state.mu.RLock()
+	defer state.mu.RUnlock()
+	return state.abnormal
+}
+
+// Status returns the network's latest sample, nil before the first one, and
+// whether its divergence is abnormal.
+func (m *DivergenceMonitor) Status(network string) map[string]interface{} {
+	status := map[string]interface{}{
+		"network":           network,
+		"latest":            nil,
+		"abnormal":          false,
+		"threshold_percent": m.threshold,
+		"blocks_entries":    false,
+	}
+	state, ok := m.networks[network]
+	if !ok {
+		return status
+	}
+
+	state.mu.RLock()
+	defer state.mu.RUnlock()
+	status["latest"] = state.latest
+	status["abnormal"] = state.abnormal
+	status["blocks_entries"] = m.blockEntry && state.abnormal
+	return status
+}
+
+// History returns the network's samples in ascending time order. From and To
+// are Unix milliseconds; without a limit at most 500 samples, the most
+// recent ones, are returned.
+func (m *DivergenceMonitor) History(network string, r lnmarkets.HistoryRange) ([]models.DivergenceSample, error) {
+	limit := r.Limit
+	if limit <= 0 {
+		limit = 500
+	}
+	from := time.Unix(0, 0)
+	if r.From > 0 {
+		from = time.UnixMilli(r.From)
+	}
+	to := time.Now()
+	if r.To > 0 {
+		to = time.UnixMilli(r.To)
+	}
+
+	samples := []models.DivergenceSample{}
+	err := m.db.Select(&samples, `
+		SELECT * FROM (
+			SELECT * FROM price_divergence
+			WHERE network = $1 AND created_at >= $2 AND created_at <= $3
+			ORDER BY created_at DESC
+			LIMIT $4
+		) recent ORDER BY created_at ASC
+	`, network, from, to, limit)
+	return samples, err
+}
+
+// Events returns the network's most recent divergence events, newest first.
+func (m *DivergenceMonitor) Events(network string, limit int) ([]models.DivergenceEvent, error) {
+	events := []models.DivergenceEvent{}
+	err := m.db.Select(&events, "SELECT * FROM divergence_events WHERE network = $1 ORDER BY created_at DESC LIMIT $2", network, limit)
+	return events, err
+}
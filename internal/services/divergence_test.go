@@ -0,0 +1,42 @@
+package services
+
+import "testing"
+
+func TestDivergenceBaseline(t *testing.T) {
+	tests := []struct {
+		name     string
+		snapshot PriceSnapshot
+		baseline float64
+		ok       bool
+	}{
+		{"aggregate without the index", PriceSnapshot{Price: 100, Sources: map[string]float64{"binance": 99, "kraken": 101}}, 100, true},
+		{"index left out", PriceSnapshot{Price: 103, Sources: map[string]float64{"binance": 100, "kraken": 102, "lnmarkets": 110}}, 101, true},
+		{"median of the others", PriceSnapshot{Price: 100, Sources: map[string]float64{"binance": 100, "coinbase": 105, "kraken": 101, "lnmarkets": 90}}, 101, true},
+		{"only the index", PriceSnapshot{Price: 110, Sources: map[string]float64{"lnmarkets": 110}}, 0, false},
+		{"no price", PriceSnapshot{}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			baseline, ok := divergenceBaseline(tt.snapshot)
+			if baseline != tt.baseline || ok != tt.ok {
+				t.Errorf("divergenceBaseline = %v, %v, want %v, %v", baseline, ok, tt.baseline, tt.ok)
+			}
+		})
+	}
+}
+
+func TestDivergenceBlocksEntriesPerNetwork(t *testing.T) {
+	m := NewDivergenceMonitor(nil, nil)
+	m.blockEntry = true
+	m.networks[NetworkTestnet].abnormal = true
+
+	if m.BlocksEntries(NetworkMainnet) {
+		t.Error("testnet divergence blocks mainnet entries")
+	}
+	if !m.BlocksEntries(NetworkTestnet) {
+		t.Error("testnet divergence does not block testnet entries")
+	}
+	if status := m.Status(NetworkMainnet); status["abnormal"] != false || status["network"] != NetworkMainnet {
+		t.Errorf("mainnet status = %v", status)
+	}
+}
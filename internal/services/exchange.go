@@ -0,0 +1,97 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"btc-trading-bot/internal/database"
+	"btc-trading-bot/internal/models"
+	"btc-trading-bot/pkg/lnmarkets"
+	"btc-trading-bot/pkg/simulator"
+)
+
+// priceFeeder is implemented by exchanges that need the bot's price feed to
+// fill and settle trades, such as the simulated exchange.
+type priceFeeder interface {
+	UpdatePrice(price float64)
+}
+
+const (
+	// TradingModeLive sends orders to LN Markets.
+	TradingModeLive = "live"
+	// TradingModePaper books orders to the user's persisted virtual account.
+	TradingModePaper = "paper"
+	// TradingModeSimulated uses a throwaway in-memory exchange, selected
+	// process-wide with EXCHANGE_MODE=simulated. The exchange lives only as
+	// long as the bot that created it, so it is unavailable while no bot is
+	// running; paper accounts persist and keep settling without one.
+	TradingModeSimulated = "simulated"
+)
+
+func defaultTradingMode() string {
+	if os.Getenv("EXCHANGE_MODE") == "simulated" {
+		return TradingModeSimulated
+	}
+	return TradingModeLive
+}
+
+// ExchangeForUser returns the exchange for the requested mode along with the
+// mode that was resolved. An empty mode follows the user's running bot and
+// falls back to the default mode. The running bot's exchange is reused when
+// its mode matches.
+func (s *TradingService) ExchangeForUser(userID int, mode string) (lnmarkets.Exchange, string, error) {
+	s.botMutex.RLock()
+	bot, exists := s.runningBots[userID]
+	s.botMutex.RUnlock()
+	if exists && bot.IsRunning && bot.Exchange != nil && (mode == "" || mode == bot.Mode) {
+		return bot.Exchange, bot.Mode, nil
+	}
+
+	if mode == "" {
+		mode = defaultTradingMode()
+	}
+
+	switch mode {
+	case TradingModePaper:
+		exchange, err := s.loadPaperExchange(userID, false)
+		if err != nil {
+			return nil, mode, err
+		}
+		return exchange, mode, nil
+	case TradingModeLive:
+		client, err := lnMarketsClientForUser(s.db, userID)
+		if err != nil {
+			return nil, mode, err
+		}
+		return client, mode, nil
+	default:
+		return nil, mode, fmt.Errorf("no %s exchange available for user %d", mode, userID)
+	}
+}
+
+// lnMarketsClientForUser builds an LN Markets client from the user's stored
+// credentials.
+func lnMarketsClientForUser(db *database.Database, userID int) (*lnmarkets.Client, error) {
+	var config models.LNMarketsConfig
+	err := db.Get(&config, "SELECT * FROM ln_markets_config WHERE user_id = $1", userID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrLNMarketsNotConfigured, err)
+	}
+	return lnmarkets.NewClient(config.APIKey, config.SecretKey, config.Passphrase, config.IsTestnet), nil
+}
+
+func newSimulatedExchange() *simulator.Exchange {
+	return simulator.NewExchange(simulator.Config{
+		InitialBalance: envFloat("SIM_INITIAL_BALANCE", 1_000_000),
+		FeeRate:        envFloat("SIM_FEE_RATE", 0.001),
+		MaxLeverage:    envFloat("SIM_MAX_LEVERAGE", 100),
+	})
+}
+
+func envFloat(key string, defaultValue float64) float64 {
+	if v, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return v
+	}
+	return defaultValue
+}
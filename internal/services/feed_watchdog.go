@@ -0,0 +1,178 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// Feed events recorded in feed_events.
+const (
+	FeedEventStale     = "stale"
+	FeedEventRecovered = "recovered"
+	// FeedEventFallback and FeedEventFallbackEnded mark the aggregator
+	// standing in for a stale LN Markets feed.
+	FeedEventFallback      = "fallback"
+	FeedEventFallbackEnded = "fallback_ended"
+)
+
+// Price sources reported in bot status and feed events.
+const (
+	FeedSourceLNMarkets  = "lnmarkets"
+	FeedSourceAggregator = "aggregator"
+)
+
+// feedStaleAfter is how long a bot may go without a price before its feed is
+// considered stale, from FEED_STALE_AFTER (seconds, default 15).
+func feedStaleAfter() time.Duration {
+	seconds := envFloat("FEED_STALE_AFTER", 15)
+	if seconds <= 0 {
+		seconds = 15
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// feedFallbackEnabled reports whether FEED_FALLBACK_AGGREGATOR=true, letting
+// the price aggregator feed live bots while LN Markets is stale.
+func feedFallbackEnabled() bool {
+	return os.Getenv("FEED_FALLBACK_AGGREGATOR") == "true"
+}
+
+// recordPrice stores a price from any feed. It reports whether the feed was
+// stale and for how long.
+func (b *BotInstance) recordPrice(price float64) (bool, time.Duration) {
+	b.feedMu.Lock()
+	defer b.feedMu.Unlock()
+
+	now := time.Now()
+	b.LastPrice = price
+	b.LastUpdate = now
+
+	if !b.stale {
+		return false, 0
+	}
+	b.stale = false
+	return true, now.Sub(b.staleSince)
+}
+
+// markPrimary records a price from the bot's own feed, as opposed to the
+// aggregator fallback.
+func (b *BotInstance) markPrimary() {
+	b.feedMu.Lock()
+	defer b.feedMu.Unlock()
+	b.primaryUpdate = time.Now()
+}
+
+// feedFresh reports whether the last price is recent enough to place orders
+// on.
+func (b *BotInstance) feedFresh() bool {
+	b.feedMu.Lock()
+	defer b.feedMu.Unlock()
+	return !b.stale && time.Since(b.LastUpdate) <= b.staleAfter
+}
+
+// onFallback reports whether the aggregator is standing in for the bot's
+// stale LN Markets feed.
+func (b *BotInstance) onFallback() bool {
+	b.feedMu.Lock()
+	defer b.feedMu.Unlock()
+	return b.stopFallback != nil
+}
+
+// priceSource returns where the bot's prices currently come from.
+func (b *BotInstance) priceSource() string {
+	b.feedMu.Lock()
+	defer b.feedMu.Unlock()
+	if b.WSClient == nil || b.stopFallback != nil {
+		return FeedSourceAggregator
+	}
+	return FeedSourceLNMarkets
+}
+
+// watchFeed flags the bot's feed as stale once no price arrived for
+// staleAfter, and swaps the aggregator in and out when fallback is enabled.
+func (s *TradingService) watchFeed(bot *BotInstance) {
+	interval := bot.staleAfter / 5
+	if interval < 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-bot.ctx.Done():
+			bot.feedMu.Lock()
+			stopFallback := bot.stopFallback
+			bot.stopFallback = nil
+			bot.feedMu.Unlock()
+			if stopFallback != nil {
+				stopFallback()
+			}
+			return
+		case <-ticker.C:
+			s.checkFeed(bot)
+		}
+	}
+}
+
+func (s *TradingService) checkFeed(bot *BotInstance) {
+	now := time.Now()
+
+	bot.feedMu.Lock()
+	silence := now.Sub(bot.LastUpdate)
+	becameStale := !bot.stale && silence > bot.staleAfter
+	if becameStale {
+		bot.stale = true
+		bot.staleSince = now
+	}
+	// The fallback is live-feed only: aggregator bots have nothing to fall
+	// back to.
+	startFallback := bot.stale && bot.fallback && bot.WSClient != nil && bot.stopFallback == nil
+	var stopFallback func()
+	if bot.stopFallback != nil && now.Sub(bot.primaryUpdate) <= bot.staleAfter {
+		stopFallback = bot.stopFallback
+		bot.stopFallback = nil
+	}
+	bot.feedMu.Unlock()
+
+	if becameStale {
+		log.Printf("Price feed for user %d is stale: no price for %s, pausing order placement",
+			bot.UserID, silence.Round(time.Second))
+		s.recordFeedEvent(bot, FeedEventStale, fmt.Sprintf("no price for %s", silence.Round(time.Second)))
+	}
+
+	if startFallback {
+		ch, unsubscribe := s.priceAggregator.Subscribe()
+		bot.feedMu.Lock()
+		bot.stopFallback = unsubscribe
+		bot.feedMu.Unlock()
+
+		go func() {
+			for snap := range ch {
+				select {
+				case bot.PriceUpdates <- snap.Price:
+				default:
+				}
+			}
+		}()
+
+		log.Printf("Price feed for user %d fell back to the aggregator", bot.UserID)
+		s.recordFeedEvent(bot, FeedEventFallback, "")
+	}
+
+	if stopFallback != nil {
+		stopFallback()
+		log.Printf("Price feed for user %d is back on LN Markets", bot.UserID)
+		s.recordFeedEvent(bot, FeedEventFallbackEnded, "")
+	}
+}
+
+func (s *TradingService) recordFeedEvent(bot *BotInstance, event, detail string) {
+	_, err := s.db.Exec("INSERT INTO feed_events (user_id, mode, event, source, detail, created_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		bot.UserID, bot.Mode, event, bot.priceSource(), detail, time.Now())
+	if err != nil {
+		log.Printf("Error recording %s feed event for user %d: %v", event, bot.UserID, err)
+	}
+}
@@ -0,0 +1,420 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"btc-trading-bot/internal/database"
+	"btc-trading-bot/internal/models"
+	"btc-trading-bot/pkg/lightning"
+	"btc-trading-bot/pkg/lnmarkets"
+)
+
+var (
+	ErrLNMarketsNotConfigured  = errors.New("LN Markets config not found")
+	ErrInvalidWithdrawal       = errors.New("invalid withdrawal")
+	ErrWithdrawalNotFound      = errors.New("withdrawal not found")
+	ErrWithdrawalLimitExceeded = errors.New("daily withdrawal limit exceeded")
+	ErrWithdrawalNotPending    = errors.New("withdrawal is not pending")
+	ErrWithdrawalNotConfirmed  = errors.New("withdrawal is not confirmed")
+	ErrWithdrawalExpired       = errors.New("withdrawal confirmation expired")
+	ErrInvalidConfirmation     = errors.New("invalid confirmation token")
+)
+
+// FundingService moves sats in and out of the user's LN Markets account.
+// Withdrawals are two-step: RequestWithdrawal records a pending withdrawal
+// and returns a confirmation token, and ConfirmWithdrawal pays it out if the
+// user's rolling 24h withdrawal cap still allows it.
+type FundingService struct {
+	db *database.Database
+}
+
+func NewFundingService(db *database.Database) *FundingService {
+	return &FundingService{db: db}
+}
+
+// confirmationTTL is how long a pending withdrawal can be confirmed.
+func confirmationTTL() time.Duration {
+	return time.Duration(envFloat("WITHDRAWAL_CONFIRMATION_TTL", 300)) * time.Second
+}
+
+func (s *FundingService) CreateDeposit(ctx context.Context, userID int, amount int64) (*lnmarkets.DepositInvoice, error) {
+	if amount <= 0 {
+		return nil, fmt.Errorf("%w: amount must be a positive number of sats", lnmarkets.ErrBadRequest)
+	}
+
+	client, err := lnMarketsClientForUser(s.db, userID)
+	if err != nil {
+		return nil, err
+	}
+	return client.DepositContext(ctx, amount)
+}
+
+func (s *FundingService) GetDeposits(ctx context.Context, userID int) ([]lnmarkets.Transfer, error) {
+	client, err := lnMarketsClientForUser(s.db, userID)
+	if err != nil {
+		return nil, err
+	}
+	return client.GetDepositsContext(ctx)
+}
+
+func (s *FundingService) GetWithdrawals(ctx context.Context, userID int) ([]lnmarkets.Transfer, error) {
+	client, err := lnMarketsClientForUser(s.db, userID)
+	if err != nil {
+		return nil, err
+	}
+	return client.GetWithdrawalsContext(ctx)
+}
+
+// GetWithdrawalRequests lists the withdrawals requested through the bot,
+// newest first.
+func (s *FundingService) GetWithdrawalRequests(userID int) ([]models.Withdrawal, error) {
+	var withdrawals []models.Withdrawal
+	err := s.db.Select(&withdrawals, "SELECT * FROM withdrawals WHERE user_id = $1 ORDER BY created_at DESC LIMIT 100", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch withdrawals: %v", err)
+	}
+	return withdrawals, nil
+}
+
+// RequestWithdrawal validates a withdrawal and stores it as pending. The
+// destination is a BOLT11 invoice or a Lightning address; for invoices that
+// carry an amount, amount may be left at zero. Nothing is paid until the
+// withdrawal is confirmed with its token.
+func (s *FundingService) RequestWithdrawal(userID int, amount int64, destination string) (*models.Withdrawal, error) {
+	destination = strings.TrimSpace(destination)
+	switch {
+	case lightning.IsInvoice(destination):
+		invoiceAmount, ok, err := lightning.InvoiceAmount(destination)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidWithdrawal, err)
+		}
+		if ok && amount == 0 {
+			amount = invoiceAmount
+		}
+		if ok && amount != invoiceAmount {
+			return nil, fmt.Errorf("%w: amount %d does not match the invoice amount %d", ErrInvalidWithdrawal, amount, invoiceAmount)
+		}
+	case lightning.IsAddress(destination):
+	default:
+		return nil, fmt.Errorf("%w: destination must be a BOLT11 invoice or a lightning address", ErrInvalidWithdrawal)
+	}
+	if amount <= 0 {
+		return nil, fmt.Errorf("%w: amount must be a positive number of sats", ErrInvalidWithdrawal)
+	}
+
+	if _, err := lnMarketsClientForUser(s.db, userID); err != nil {
+		return nil, err
+	}
+
+	limit, used, err := s.GetWithdrawalLimit(userID)
+	if err != nil {
+		return nil, err
+	}
+	if used+amount > limit.DailyLimit {
+		return nil, fmt.Errorf("%w: %d of %d sats already withdrawn in the last 24h", ErrWithdrawalLimitExceeded, used, limit.DailyLimit)
+	}
+
+	token, err := confirmationToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	withdrawal := &models.Withdrawal{}
+	err = s.db.QueryRowx(`
+		INSERT INTO withdrawals (user_id, amount, destination, status, confirmation_token, expires_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+		RETURNING *
+	`, userID, amount, destination, models.WithdrawalPending, token, now.Add(confirmationTTL()), now).StructScan(withdrawal)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save withdrawal: %v", err)
+	}
+
+	return withdrawal, nil
+}
+
+// ConfirmWithdrawal pays out a pending withdrawal. The cap is checked again
+// under a lock on the user's limit row so concurrent confirmations cannot
+// exceed it.
+func (s *FundingService) ConfirmWithdrawal(ctx context.Context, userID, withdrawalID int, token string) (*models.Withdrawal, error) {
+	client, err := lnMarketsClientForUser(s.db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	withdrawal, err := s.reserveWithdrawal(userID, withdrawalID, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.payWithdrawal(ctx, client, withdrawal)
+}
+
+// withdrawer pays a Lightning invoice from the user's exchange account.
+type withdrawer interface {
+	WithdrawContext(ctx context.Context, amount int64, invoice string) (*lnmarkets.WithdrawalResult, error)
+}
+
+// payWithdrawal pays out a withdrawal reserveWithdrawal confirmed. Once sent
+// to LN Markets the payout is not cancelled with ctx, so an abandoned
+// request cannot leave the withdrawal in an unknown state.
+func (s *FundingService) payWithdrawal(ctx context.Context, client withdrawer, withdrawal *models.Withdrawal) (*models.Withdrawal, error) {
+	if withdrawal.Status != models.WithdrawalProcessing || withdrawal.ConfirmedAt == nil {
+		return withdrawal, fmt.Errorf("%w: withdrawal is %s", ErrWithdrawalNotConfirmed, withdrawal.Status)
+	}
+
+	ctx = context.WithoutCancel(ctx)
+
+	var err error
+	invoice := withdrawal.Destination
+	if lightning.IsAddress(invoice) {
+		resolveCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		invoice, err = lightning.ResolveAddress(resolveCtx, withdrawal.Destination, withdrawal.Amount)
+		cancel()
+		if err != nil {
+			s.finishWithdrawal(withdrawal, models.WithdrawalFailed, nil, err)
+			return withdrawal, fmt.Errorf("%w: %v", ErrInvalidWithdrawal, err)
+		}
+	}
+
+	result, err := client.WithdrawContext(ctx, withdrawal.Amount, invoice)
+	if err != nil {
+		// A rejected request was never paid. Anything else (timeouts, 5xx)
+		// may have been, so the withdrawal stays processing and keeps
+		// counting against the cap until it is reconciled.
+		var apiErr *lnmarkets.APIError
+		if errors.As(err, &apiErr) && !errors.Is(err, lnmarkets.ErrServer) {
+			s.finishWithdrawal(withdrawal, models.WithdrawalFailed, nil, err)
+		} else {
+			s.finishWithdrawal(withdrawal, models.WithdrawalProcessing, nil, err)
+		}
+		return withdrawal, err
+	}
+
+	s.finishWithdrawal(withdrawal, models.WithdrawalCompleted, result, nil)
+	return withdrawal, nil
+}
+
+// reserveWithdrawal checks the token, expiry and daily cap and moves the
+// withdrawal to processing. The cap is checked under a lock on the user's
+// limit row.
+func (s *FundingService) reserveWithdrawal(userID, withdrawalID int, token string) (*models.Withdrawal, error) {
+	limit, err := s.ensureWithdrawalLimit(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.Get(&limit.DailyLimit, "SELECT daily_limit FROM withdrawal_limits WHERE user_id = $1 FOR UPDATE", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock withdrawal limit: %v", err)
+	}
+
+	var withdrawal models.Withdrawal
+	err = tx.Get(&withdrawal, "SELECT * FROM withdrawals WHERE id = $1 AND user_id = $2 FOR UPDATE", withdrawalID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrWithdrawalNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch withdrawal: %v", err)
+	}
+
+	now := time.Now()
+	if err := checkConfirmation(&withdrawal, token, now); err != nil {
+		if errors.Is(err, ErrWithdrawalExpired) {
+			if _, err := tx.Exec("UPDATE withdrawals SET status = $1, updated_at = $2 WHERE id = $3",
+				models.WithdrawalExpired, now, withdrawal.ID); err == nil {
+				tx.Commit()
+			}
+		}
+		return nil, err
+	}
+
+	used, err := withdrawnSince(tx, userID, now.Add(-24*time.Hour))
+	if err != nil {
+		return nil, err
+	}
+	if used+withdrawal.Amount > limit.DailyLimit {
+		return nil, fmt.Errorf("%w: %d of %d sats already withdrawn in the last 24h", ErrWithdrawalLimitExceeded, used, limit.DailyLimit)
+	}
+
+	withdrawal.Status = models.WithdrawalProcessing
+	withdrawal.ConfirmedAt = &now
+	withdrawal.UpdatedAt = now
+	_, err = tx.Exec("UPDATE withdrawals SET status = $1, confirmed_at = $2, updated_at = $2 WHERE id = $3",
+		withdrawal.Status, now, withdrawal.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update withdrawal: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit withdrawal: %v", err)
+	}
+
+	return &withdrawal, nil
+}
+
+// checkConfirmation reports why withdrawal cannot be confirmed with token at
+// now, if it cannot.
+func checkConfirmation(withdrawal *models.Withdrawal, token string, now time.Time) error {
+	if withdrawal.Status != models.WithdrawalPending {
+		return fmt.Errorf("%w: withdrawal is %s", ErrWithdrawalNotPending, withdrawal.Status)
+	}
+	if token == "" || subtle.ConstantTimeCompare([]byte(withdrawal.ConfirmationToken), []byte(token)) != 1 {
+		return ErrInvalidConfirmation
+	}
+	if now.After(withdrawal.ExpiresAt) {
+		return ErrWithdrawalExpired
+	}
+	return nil
+}
+
+func (s *FundingService) finishWithdrawal(withdrawal *models.Withdrawal, status string, result *lnmarkets.WithdrawalResult, withdrawErr error) {
+	withdrawal.Status = status
+	withdrawal.UpdatedAt = time.Now()
+	if result != nil {
+		withdrawal.ExternalID = result.ID
+		withdrawal.PaymentHash = result.PaymentHash
+		withdrawal.Fee = result.Fee
+	}
+	if withdrawErr != nil {
+		withdrawal.Error = withdrawErr.Error()
+	}
+
+	_, err := s.db.Exec(`
+		UPDATE withdrawals SET status = $1, external_id = $2, payment_hash = $3, fee = $4, error = $5, updated_at = $6
+		WHERE id = $7
+	`, withdrawal.Status, withdrawal.ExternalID, withdrawal.PaymentHash, withdrawal.Fee, withdrawal.Error,
+		withdrawal.UpdatedAt, withdrawal.ID)
+	if err != nil {
+		log.Printf("Error updating withdrawal %d to %s: %v", withdrawal.ID, status, err)
+	}
+}
+
+// GetWithdrawalLimit returns the user's daily cap and how many sats were
+// withdrawn, or are being withdrawn, in the last 24 hours.
+func (s *FundingService) GetWithdrawalLimit(userID int) (*models.WithdrawalLimit, int64, error) {
+	limit, err := s.ensureWithdrawalLimit(userID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	used, err := withdrawnSince(s.db, userID, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return limit, used, nil
+}
+
+// limitIncreaseDelay is how long a raised withdrawal limit waits before it
+// takes effect, so a stolen session cannot raise the cap and drain the
+// account at once.
+func limitIncreaseDelay() time.Duration {
+	return time.Duration(envFloat("WITHDRAWAL_LIMIT_INCREASE_DELAY", 24) * float64(time.Hour))
+}
+
+// SetWithdrawalLimit changes the user's daily cap, bounded by
+// WITHDRAWAL_MAX_DAILY_LIMIT. Lowering the cap applies at once and cancels
+// any pending increase; raising it is scheduled after limitIncreaseDelay.
+func (s *FundingService) SetWithdrawalLimit(userID int, dailyLimit int64) (*models.WithdrawalLimit, error) {
+	maxLimit := int64(envFloat("WITHDRAWAL_MAX_DAILY_LIMIT", 10_000_000))
+	if dailyLimit < 0 || dailyLimit > maxLimit {
+		return nil, fmt.Errorf("%w: daily limit must be between 0 and %d sats", ErrInvalidWithdrawal, maxLimit)
+	}
+
+	current, err := s.ensureWithdrawalLimit(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	limit := &models.WithdrawalLimit{}
+	if dailyLimit <= current.DailyLimit {
+		err = s.db.QueryRowx(`
+			UPDATE withdrawal_limits SET daily_limit = $1, pending_daily_limit = NULL, pending_effective_at = NULL, updated_at = $2
+			WHERE user_id = $3
+			RETURNING *
+		`, dailyLimit, now, userID).StructScan(limit)
+	} else {
+		err = s.db.QueryRowx(`
+			UPDATE withdrawal_limits SET pending_daily_limit = $1, pending_effective_at = $2, updated_at = $3
+			WHERE user_id = $4
+			RETURNING *
+		`, dailyLimit, now.Add(limitIncreaseDelay()), now, userID).StructScan(limit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to save withdrawal limit: %v", err)
+	}
+
+	return limit, nil
+}
+
+// ensureWithdrawalLimit returns the user's limit row, creating it with
+// WITHDRAWAL_DAILY_LIMIT sats if missing and applying a pending increase
+// that has come due.
+func (s *FundingService) ensureWithdrawalLimit(userID int) (*models.WithdrawalLimit, error) {
+	now := time.Now()
+	_, err := s.db.Exec(`
+		INSERT INTO withdrawal_limits (user_id, daily_limit, created_at, updated_at)
+		VALUES ($1, $2, $3, $3)
+		ON CONFLICT (user_id) DO NOTHING
+	`, userID, int64(envFloat("WITHDRAWAL_DAILY_LIMIT", 100_000)), now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create withdrawal limit: %v", err)
+	}
+
+	_, err = s.db.Exec(`
+		UPDATE withdrawal_limits SET daily_limit = pending_daily_limit, pending_daily_limit = NULL, pending_effective_at = NULL, updated_at = $1
+		WHERE user_id = $2 AND pending_effective_at <= $1
+	`, now, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply withdrawal limit increase: %v", err)
+	}
+
+	var limit models.WithdrawalLimit
+	if err := s.db.Get(&limit, "SELECT * FROM withdrawal_limits WHERE user_id = $1", userID); err != nil {
+		return nil, fmt.Errorf("failed to fetch withdrawal limit: %v", err)
+	}
+	return &limit, nil
+}
+
+type getter interface {
+	Get(dest interface{}, query string, args ...interface{}) error
+}
+
+// withdrawnSince sums the withdrawals confirmed after since that were paid
+// or may still be paid.
+func withdrawnSince(q getter, userID int, since time.Time) (int64, error) {
+	var used int64
+	err := q.Get(&used, `
+		SELECT COALESCE(SUM(amount), 0) FROM withdrawals
+		WHERE user_id = $1 AND status IN ($2, $3) AND confirmed_at > $4
+	`, userID, models.WithdrawalProcessing, models.WithdrawalCompleted, since)
+	if err != nil {
+		return 0, fmt.Errorf("failed to compute withdrawn amount: %v", err)
+	}
+	return used, nil
+}
+
+func confirmationToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate confirmation token: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
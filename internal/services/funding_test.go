@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"btc-trading-bot/internal/models"
+	"btc-trading-bot/pkg/lnmarkets"
+)
+
+// countingWithdrawer records payouts instead of making them.
+type countingWithdrawer struct {
+	paid int
+}
+
+func (c *countingWithdrawer) WithdrawContext(ctx context.Context, amount int64, invoice string) (*lnmarkets.WithdrawalResult, error) {
+	c.paid++
+	return &lnmarkets.WithdrawalResult{}, nil
+}
+
+func TestCheckConfirmation(t *testing.T) {
+	now := time.Now()
+	pending := func() *models.Withdrawal {
+		return &models.Withdrawal{Status: models.WithdrawalPending, ConfirmationToken: "secret", ExpiresAt: now.Add(time.Minute)}
+	}
+
+	tests := []struct {
+		name       string
+		withdrawal func() *models.Withdrawal
+		token      string
+		want       error
+	}{
+		{"valid", pending, "secret", nil},
+		{"no token", pending, "", ErrInvalidConfirmation},
+		{"wrong token", pending, "guess", ErrInvalidConfirmation},
+		{"expired", func() *models.Withdrawal {
+			w := pending()
+			w.ExpiresAt = now.Add(-time.Second)
+			return w
+		}, "secret", ErrWithdrawalExpired},
+		{"already confirmed", func() *models.Withdrawal {
+			w := pending()
+			w.Status = models.WithdrawalProcessing
+			return w
+		}, "secret", ErrWithdrawalNotPending},
+		{"marked expired", func() *models.Withdrawal {
+			w := pending()
+			w.Status = models.WithdrawalExpired
+			return w
+		}, "secret", ErrWithdrawalNotPending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkConfirmation(tt.withdrawal(), tt.token, now)
+			if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Errorf("checkConfirmation = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// Withdrawals that were not confirmed, or expired before they were, never
+// reach the exchange.
+func TestUnconfirmedWithdrawalIsNeverPaid(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name       string
+		withdrawal models.Withdrawal
+	}{
+		{"pending", models.Withdrawal{Status: models.WithdrawalPending, ExpiresAt: now.Add(time.Minute)}},
+		{"expired", models.Withdrawal{Status: models.WithdrawalExpired, ExpiresAt: now.Add(-time.Minute)}},
+		{"processing without confirmation", models.Withdrawal{Status: models.WithdrawalProcessing}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &countingWithdrawer{}
+			s := &FundingService{}
+			withdrawal := tt.withdrawal
+			withdrawal.Amount = 1000
+			withdrawal.Destination = "lnbc10u1invoice"
+
+			_, err := s.payWithdrawal(context.Background(), client, &withdrawal)
+			if !errors.Is(err, ErrWithdrawalNotConfirmed) {
+				t.Errorf("payWithdrawal = %v, want ErrWithdrawalNotConfirmed", err)
+			}
+			if client.paid != 0 {
+				t.Errorf("paid %d times, want never", client.paid)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SupportedCurrencies are the fiat currencies prices can be quoted in.
+// Aggregation is done in USD; the others are derived with FX cross-rates.
+var SupportedCurrencies = []string{"USD", "EUR", "BRL", "GBP"}
+
+var (
+	ErrUnsupportedCurrency = errors.New("unsupported currency")
+	ErrFXRateUnavailable   = errors.New("fx rate unavailable")
+)
+
+const defaultFXRatesURL = "https://api.frankfurter.app/latest?from=USD"
+
+// NormalizeCurrency upper-cases currency, defaulting to USD, and checks it is
+// supported.
+func NormalizeCurrency(currency string) (string, error) {
+	if currency == "" {
+		return "USD", nil
+	}
+	currency = strings.ToUpper(currency)
+	if !containsString(SupportedCurrencies, currency) {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedCurrency, currency)
+	}
+	return currency, nil
+}
+
+// FXRates keeps USD exchange rates, refreshed from FX_RATES_URL (ECB
+// reference rates by default) every FX_REFRESH_MINUTES (default 60). Rates
+// older than FX_MAX_AGE_HOURS (default 72, covering weekends) are not used.
+type FXRates struct {
+	client          *http.Client
+	url             string
+	refreshInterval time.Duration
+	maxAge          time.Duration
+
+	mu        sync.RWMutex
+	rates     map[string]float64
+	updatedAt time.Time
+}
+
+func NewFXRates(client *http.Client) *FXRates {
+	url := os.Getenv("FX_RATES_URL")
+	if url == "" {
+		url = defaultFXRatesURL
+	}
+	return &FXRates{
+		client:          client,
+		url:             url,
+		refreshInterval: time.Duration(envFloat("FX_REFRESH_MINUTES", 60) * float64(time.Minute)),
+		maxAge:          time.Duration(envFloat("FX_MAX_AGE_HOURS", 72) * float64(time.Hour)),
+	}
+}
+
+// run refreshes the rates until stop is closed, retrying a failed refresh
+// after a minute.
+func (f *FXRates) run(stop <-chan struct{}) {
+	for {
+		delay := f.refreshInterval
+		if err := f.refresh(); err != nil {
+			log.Printf("Error refreshing FX rates: %v", err)
+			delay = min(delay, time.Minute)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (f *FXRates) refresh() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "btc-trading-bot/1.0")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fx rates non-200: %d", resp.StatusCode)
+	}
+
+	var payload struct {
+		Base  string             `json:"base"`
+		Rates map[string]float64 `json:"rates"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return err
+	}
+	if payload.Base != "" && payload.Base != "USD" {
+		return fmt.Errorf("fx rates quoted in %s, expected USD", payload.Base)
+	}
+
+	rates := make(map[string]float64, len(SupportedCurrencies))
+	for _, currency := range SupportedCurrencies[1:] {
+		if rate := payload.Rates[currency]; rate > 0 {
+			rates[currency] = rate
+		}
+	}
+
+	f.mu.Lock()
+	f.rates = rates
+	f.updatedAt = time.Now()
+	f.mu.Unlock()
+	return nil
+}
+
+// Rate returns how many units of currency one USD buys.
+func (f *FXRates) Rate(currency string) (float64, error) {
+	if currency == "USD" {
+		return 1, nil
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	rate, ok := f.rates[currency]
+	if !ok || time.Since(f.updatedAt) > f.maxAge {
+		return 0, fmt.Errorf("%w: USD/%s", ErrFXRateUnavailable, currency)
+	}
+	return rate, nil
+}
+
+// Convert converts a USD amount to currency.
+func (f *FXRates) Convert(usd float64, currency string) (float64, error) {
+	rate, err := f.Rate(currency)
+	if err != nil {
+		return 0, err
+	}
+	return usd * rate, nil
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNormalizeCurrency(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		err  error
+	}{
+		{"", "USD", nil},
+		{"usd", "USD", nil},
+		{"brl", "BRL", nil},
+		{"EUR", "EUR", nil},
+		{"jpy", "", ErrUnsupportedCurrency},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeCurrency(tt.in)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("NormalizeCurrency(%q) = %q, %v; want %q, %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+// testFXRates returns rates refreshed from a server answering with body and
+// status.
+func testFXRates(t *testing.T, status int, body string) (*FXRates, error) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	t.Setenv("FX_RATES_URL", server.URL)
+	fx := NewFXRates(server.Client())
+	return fx, fx.refresh()
+}
+
+func TestFXRatesRefresh(t *testing.T) {
+	fx, err := testFXRates(t, http.StatusOK, `{"base":"USD","rates":{"EUR":0.9,"BRL":5.5,"JPY":150}}`)
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+
+	tests := []struct {
+		currency string
+		want     float64
+		err      error
+	}{
+		{"USD", 1, nil},
+		{"EUR", 0.9, nil},
+		{"BRL", 5.5, nil},
+		// GBP is supported but missing from the payload; JPY is not kept.
+		{"GBP", 0, ErrFXRateUnavailable},
+		{"JPY", 0, ErrFXRateUnavailable},
+	}
+	for _, tt := range tests {
+		rate, err := fx.Rate(tt.currency)
+		if !errors.Is(err, tt.err) || rate != tt.want {
+			t.Errorf("Rate(%s) = %v, %v; want %v, %v", tt.currency, rate, err, tt.want, tt.err)
+		}
+	}
+
+	converted, err := fx.Convert(100, "BRL")
+	if err != nil || converted != 550 {
+		t.Errorf("Convert(100, BRL) = %v, %v; want 550", converted, err)
+	}
+
+	fx.updatedAt = time.Now().Add(-fx.maxAge - time.Minute)
+	if _, err := fx.Rate("EUR"); !errors.Is(err, ErrFXRateUnavailable) {
+		t.Errorf("Rate on stale rates = %v, want ErrFXRateUnavailable", err)
+	}
+	if rate, err := fx.Rate("USD"); err != nil || rate != 1 {
+		t.Errorf("Rate(USD) on stale rates = %v, %v; want 1", rate, err)
+	}
+}
+
+func TestFXRatesRefreshErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{"non-200", http.StatusServiceUnavailable, ``},
+		{"other base", http.StatusOK, `{"base":"EUR","rates":{"USD":1.1}}`},
+		{"malformed", http.StatusOK, `{"rates":`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fx, err := testFXRates(t, tt.status, tt.body)
+			if err == nil {
+				t.Fatal("refresh succeeded, want an error")
+			}
+			if _, err := fx.Rate("EUR"); !errors.Is(err, ErrFXRateUnavailable) {
+				t.Errorf("Rate after failed refresh = %v, want ErrFXRateUnavailable", err)
+			}
+		})
+	}
+}
+
+func TestInCurrency(t *testing.T) {
+	fx, err := testFXRates(t, http.StatusOK, `{"base":"USD","rates":{"EUR":0.5}}`)
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	p := &PriceAggregator{fx: fx}
+	snap := PriceSnapshot{Price: 100, Currency: "USD", Sources: map[string]float64{"a": 99, "b": 101}}
+
+	converted, err := p.InCurrency(snap, "EUR")
+	if err != nil {
+		t.Fatalf("InCurrency(EUR): %v", err)
+	}
+	if converted.Price != 50 || converted.Currency != "EUR" || converted.FXRate != 0.5 {
+		t.Errorf("converted = %+v, want price 50 in EUR at 0.5", converted)
+	}
+	if math.Abs(converted.Sources["a"]-49.5) > 1e-9 || math.Abs(converted.Sources["b"]-50.5) > 1e-9 {
+		t.Errorf("converted sources = %v, want a 49.5 and b 50.5", converted.Sources)
+	}
+	if snap.Sources["a"] != 99 {
+		t.Errorf("InCurrency modified the USD snapshot's sources: %v", snap.Sources)
+	}
+
+	if same, err := p.InCurrency(snap, "USD"); err != nil || same.Price != 100 || same.FXRate != 0 {
+		t.Errorf("InCurrency(USD) = %+v, %v; want the snapshot unchanged", same, err)
+	}
+	if _, err := p.InCurrency(snap, "GBP"); !errors.Is(err, ErrFXRateUnavailable) {
+		t.Errorf("InCurrency(GBP) = %v, want ErrFXRateUnavailable", err)
+	}
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"btc-trading-bot/pkg/lnmarkets"
+)
+
+// protectivePuts returns the running, unexpired puts held on the bot's
+// exchange. Exchanges without an options book have no hedges.
+func (s *TradingService) protectivePuts(bot *BotInstance) []lnmarkets.OptionTrade {
+	options, ok := bot.Exchange.(lnmarkets.OptionsExchange)
+	if !ok {
+		return nil
+	}
+
+	trades, err := options.GetOptionTradesContext(bot.ctx, "running")
+	if err != nil {
+		log.Printf("Error getting option hedges for user %d: %v", bot.UserID, err)
+		return nil
+	}
+
+	now := time.Now().UnixMilli()
+	var puts []lnmarkets.OptionTrade
+	for _, trade := range trades {
+		if trade.Type != lnmarkets.OptionTypePut || !trade.Running || (trade.ExpiryTS > 0 && trade.ExpiryTS <= now) {
+			continue
+		}
+		puts = append(puts, trade)
+	}
+	return puts
+}
+
+// hedgeCoverage hands out put notional to long positions. A put protects a
+// long once its strike is at or above the long's liquidation price: it pays
+// out before the position can be liquidated.
+type hedgeCoverage struct {
+	puts []putHedge
+}
+
+type putHedge struct {
+	strike    float64
+	remaining float64
+}
+
+func newHedgeCoverage(puts []lnmarkets.OptionTrade) *hedgeCoverage {
+	h := &hedgeCoverage{}
+	for _, put := range puts {
+		h.puts = append(h.puts, putHedge{strike: put.Strike, remaining: put.Quantity})
+	}
+	// Use the lowest qualifying strikes first so higher strikes remain for
+	// positions with higher liquidation prices.
+	sort.Slice(h.puts, func(i, j int) bool { return h.puts[i].strike < h.puts[j].strike })
+	return h
+}
+
+// cover reports whether quantity USD of a long liquidating at liquidation is
+// fully hedged, and if so reserves that notional so it is not counted twice.
+func (h *hedgeCoverage) cover(quantity, liquidation float64) bool {
+	var available float64
+	for _, put := range h.puts {
+		if put.strike >= liquidation {
+			available += put.remaining
+		}
+	}
+	if available < quantity {
+		return false
+	}
+
+	for i := range h.puts {
+		if quantity <= 0 {
+			break
+		}
+		if h.puts[i].strike < liquidation {
+			continue
+		}
+		used := h.puts[i].remaining
+		if used > quantity {
+			used = quantity
+		}
+		h.puts[i].remaining -= used
+		quantity -= used
+	}
+	return true
+}
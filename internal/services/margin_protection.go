@@ -0,0 +1,195 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"btc-trading-bot/internal/models"
+	"btc-trading-bot/pkg/lnmarkets"
+	"btc-trading-bot/pkg/pricing"
+)
+
+// Outcomes of a margin protection intervention, recorded in
+// margin_interventions.
+const (
+	MarginInterventionAdded   = "added"
+	MarginInterventionCapped  = "capped"
+	MarginInterventionSkipped = "skipped"
+	MarginInterventionFailed  = "failed"
+)
+
+// marginPositionsRefresh is how long running positions fetched from the
+// exchange are reused between prices. Fills refresh them sooner.
+const marginPositionsRefresh = 10 * time.Second
+
+// marginProtectionStrategy adds margin to running positions whose price
+// comes within activation_distance percent of liquidation, pushing the
+// liquidation price out to new_liquidation_distance percent from the current
+// price. Spending is capped per rolling 24 hours by max_daily_margin, and a
+// position is left alone for cooldown_seconds after each intervention. A put
+// pays out but does not stop a long being liquidated, so hedged longs are
+// only skipped when the user opts in with skip_hedged.
+type marginProtectionStrategy struct {
+	BaseStrategy
+	positions []lnmarkets.TradeResponse
+	fetchedAt time.Time
+}
+
+// RunsOnFallback lets margin protection act on aggregator prices: adding
+// margin only moves liquidation further away.
+func (m *marginProtectionStrategy) RunsOnFallback() bool { return true }
+
+func (m *marginProtectionStrategy) OnFill(ctx context.Context, env *StrategyEnv, fill Fill) error {
+	m.fetchedAt = time.Time{}
+	return nil
+}
+
+func (m *marginProtectionStrategy) OnPrice(ctx context.Context, env *StrategyEnv, currentPrice float64) error {
+	var config models.MarginProtection
+	if ok, err := loadConfig(env, &config, "margin_protection"); !ok || !config.IsEnabled {
+		return err
+	}
+	if env.Exchange == nil {
+		return nil
+	}
+
+	if time.Since(m.fetchedAt) > marginPositionsRefresh {
+		positions, err := env.Exchange.GetPositionsContext(ctx, "running")
+		if err != nil {
+			return fmt.Errorf("getting running positions: %v", err)
+		}
+		m.positions = positions
+		m.fetchedAt = time.Now()
+	}
+
+	// Protective puts are only fetched once a position needs protection.
+	var hedges *hedgeCoverage
+
+	for i := range m.positions {
+		position := &m.positions[i]
+		if position.Liquidation <= 0 {
+			continue
+		}
+		distance := math.Abs(currentPrice-position.Liquidation) / currentPrice * 100
+		if distance > config.ActivationDistance {
+			continue
+		}
+
+		if config.SkipHedged && pricing.IsLong(position.Side) {
+			if hedges == nil {
+				hedges = newHedgeCoverage(env.service.protectivePuts(env.bot))
+			}
+			if hedges.cover(position.Quantity, position.Liquidation) {
+				continue
+			}
+		}
+
+		last, err := lastMarginIntervention(env, position.ID)
+		if err != nil {
+			return err
+		}
+		if time.Since(last) < time.Duration(config.CooldownSeconds)*time.Second {
+			continue
+		}
+
+		target, needed := marginToAdd(position, currentPrice, config.NewLiquidationDistance)
+		if needed <= 0 {
+			continue
+		}
+
+		intervention := &models.MarginIntervention{
+			UserID:            env.UserID,
+			Mode:              env.Mode,
+			PositionID:        position.ID,
+			Price:             currentPrice,
+			LiquidationBefore: position.Liquidation,
+			TargetLiquidation: target,
+			MarginNeeded:      needed,
+		}
+
+		amount := needed
+		if config.MaxDailyMargin > 0 {
+			spent, err := marginSpentToday(env)
+			if err != nil {
+				return err
+			}
+			amount = min(needed, config.MaxDailyMargin-spent)
+		}
+		if amount <= 0 {
+			intervention.Status = MarginInterventionSkipped
+			intervention.Detail = fmt.Sprintf("daily cap of %d sats reached", config.MaxDailyMargin)
+			log.Printf("Margin protection for position %s skipped: %s", position.ID, intervention.Detail)
+			recordMarginIntervention(env, intervention)
+			continue
+		}
+
+		updated, err := env.Exchange.AddMarginContext(ctx, position.ID, amount)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			intervention.Status = MarginInterventionFailed
+			intervention.Detail = err.Error()
+			log.Printf("Margin protection for position %s failed: %v", position.ID, err)
+			recordMarginIntervention(env, intervention)
+			continue
+		}
+
+		intervention.MarginAdded = amount
+		intervention.LiquidationAfter = updated.Liquidation
+		intervention.Status = MarginInterventionAdded
+		if amount < needed {
+			intervention.Status = MarginInterventionCapped
+			intervention.Detail = fmt.Sprintf("limited to %d of %d sats by the daily cap", amount, needed)
+		}
+		log.Printf("Margin protection added %d sats to position %s: liquidation %.2f -> %.2f",
+			amount, position.ID, position.Liquidation, updated.Liquidation)
+		recordMarginIntervention(env, intervention)
+
+		*position = *updated
+	}
+	return nil
+}
+
+// marginToAdd returns the liquidation price distance percent away from price
+// on the losing side of position, and the sats that move its liquidation
+// there.
+func marginToAdd(position *lnmarkets.TradeResponse, price, distance float64) (float64, int64) {
+	target := pricing.TargetPrice(position.Side, price, -distance)
+	return target, pricing.MarginForLiquidation(position.Side, position.Quantity, position.Liquidation, target)
+}
+
+func lastMarginIntervention(env *StrategyEnv, positionID string) (time.Time, error) {
+	var last time.Time
+	err := env.DB.Get(&last, "SELECT created_at FROM margin_interventions WHERE user_id = $1 AND mode = $2 AND position_id = $3 ORDER BY created_at DESC LIMIT 1",
+		env.UserID, env.Mode, positionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return last, err
+}
+
+// marginSpentToday returns the sats margin protection added in the last 24
+// hours.
+func marginSpentToday(env *StrategyEnv) (int64, error) {
+	var spent int64
+	err := env.DB.Get(&spent, "SELECT COALESCE(SUM(margin_added), 0) FROM margin_interventions WHERE user_id = $1 AND mode = $2 AND created_at > $3",
+		env.UserID, env.Mode, time.Now().Add(-24*time.Hour))
+	return spent, err
+}
+
+func recordMarginIntervention(env *StrategyEnv, intervention *models.MarginIntervention) {
+	intervention.CreatedAt = time.Now()
+	_, err := env.DB.NamedExec(`
+		INSERT INTO margin_interventions (user_id, mode, position_id, price, liquidation_before, liquidation_after, target_liquidation, margin_needed, margin_added, status, detail, created_at)
+		VALUES (:user_id, :mode, :position_id, :price, :liquidation_before, :liquidation_after, :target_liquidation, :margin_needed, :margin_added, :status, :detail, :created_at)
+	`, intervention)
+	if err != nil {
+		log.Printf("Error recording margin intervention for position %s: %v", intervention.PositionID, err)
+	}
+}
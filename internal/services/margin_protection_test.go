@@ -0,0 +1,60 @@
+package services
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"btc-trading-bot/pkg/lnmarkets"
+)
+
+// TestMarginToAddReachesTarget opens 1000 USD at 10x from $50,000, whose
+// liquidation is $45,454.55 for a long and $55,555.56 for a short, moves
+// the price near it and adds the computed margin on the simulator.
+func TestMarginToAddReachesTarget(t *testing.T) {
+	tests := []struct {
+		name   string
+		side   string
+		price  float64
+		target float64
+	}{
+		{"long", lnmarkets.SideBuy, 46_000, 36_800},
+		{"short", lnmarkets.SideSell, 55_000, 66_000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			exchange, trade := openTestPosition(t, tt.side)
+			exchange.UpdatePrice(tt.price)
+			position, err := exchange.GetPositionContext(ctx, trade.ID)
+			if err != nil {
+				t.Fatalf("GetPosition: %v", err)
+			}
+
+			target, needed := marginToAdd(position, tt.price, 20)
+			if math.Abs(target-tt.target) > 0.01 {
+				t.Errorf("target = %.2f, want %.2f", target, tt.target)
+			}
+			if needed <= 0 {
+				t.Fatalf("needed = %d, want margin to add", needed)
+			}
+
+			updated, err := exchange.AddMarginContext(ctx, position.ID, needed)
+			if err != nil {
+				t.Fatalf("AddMargin(%d): %v", needed, err)
+			}
+			// Margin is rounded up to whole sats, so liquidation lands at
+			// most one sat's worth, about $0.05 here, past the target.
+			past := target - updated.Liquidation
+			if tt.side == lnmarkets.SideSell {
+				past = -past
+			}
+			if past < -1e-6 || past > 0.05 {
+				t.Errorf("liquidation after adding %d sats = %.2f, want just past %.2f", needed, updated.Liquidation, target)
+			}
+			if _, again := marginToAdd(updated, tt.price, 20); again != 0 {
+				t.Errorf("margin still needed after adding = %d, want 0", again)
+			}
+		})
+	}
+}
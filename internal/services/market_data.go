@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"btc-trading-bot/pkg/lnmarkets"
+)
+
+// Cache lifetimes for LN Markets market data.
+const (
+	TickerTTL      = 5 * time.Second
+	HistoryTTL     = time.Minute
+	CarryFeesTTL   = 5 * time.Minute
+	LeaderboardTTL = 5 * time.Minute
+)
+
+// maxMarketDataEntries bounds the cache; expired entries are dropped once it
+// is reached.
+const maxMarketDataEntries = 512
+
+// MarketDataService serves public LN Markets market data through a small TTL
+// cache so the dashboard and strategies don't hit the exchange on every
+// request. When a refresh fails, the last known value is served instead.
+type MarketDataService struct {
+	client *lnmarkets.Client
+	mu     sync.Mutex
+	cache  map[string]cachedMarketData
+}
+
+type cachedMarketData struct {
+	value   interface{}
+	expires time.Time
+}
+
+// NewMarketDataService reads public data from mainnet, or from testnet when
+// LN_MARKETS_IS_TESTNET=true.
+func NewMarketDataService() *MarketDataService {
+	return &MarketDataService{
+		client: lnmarkets.NewClient("", "", "", os.Getenv("LN_MARKETS_IS_TESTNET") == "true"),
+		cache:  make(map[string]cachedMarketData),
+	}
+}
+
+func (s *MarketDataService) Ticker(ctx context.Context) (*lnmarkets.Ticker, error) {
+	value, err := s.cached(ctx, "ticker", TickerTTL, func(ctx context.Context) (interface{}, error) {
+		return s.client.GetTickerContext(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*lnmarkets.Ticker), nil
+}
+
+func (s *MarketDataService) IndexHistory(ctx context.Context, r lnmarkets.HistoryRange) ([]lnmarkets.PricePoint, error) {
+	value, err := s.cached(ctx, historyKey("index", "", r), HistoryTTL, func(ctx context.Context) (interface{}, error) {
+		return s.client.GetIndexHistoryContext(ctx, r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.([]lnmarkets.PricePoint), nil
+}
+
+func (s *MarketDataService) PriceHistory(ctx context.Context, r lnmarkets.HistoryRange) ([]lnmarkets.PricePoint, error) {
+	value, err := s.cached(ctx, historyKey("price", "", r), HistoryTTL, func(ctx context.Context) (interface{}, error) {
+		return s.client.GetPriceHistoryContext(ctx, r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.([]lnmarkets.PricePoint), nil
+}
+
+func (s *MarketDataService) OHLC(ctx context.Context, interval string, r lnmarkets.HistoryRange) ([]lnmarkets.OHLC, error) {
+	value, err := s.cached(ctx, historyKey("ohlc", interval, r), HistoryTTL, func(ctx context.Context) (interface{}, error) {
+		return s.client.GetOHLCContext(ctx, interval, r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.([]lnmarkets.OHLC), nil
+}
+
+func (s *MarketDataService) CarryFees(ctx context.Context, r lnmarkets.HistoryRange) ([]lnmarkets.CarryFee, error) {
+	value, err := s.cached(ctx, historyKey("carry-fees", "", r), CarryFeesTTL, func(ctx context.Context) (interface{}, error) {
+		return s.client.GetCarryFeesContext(ctx, r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.([]lnmarkets.CarryFee), nil
+}
+
+func (s *MarketDataService) Leaderboard(ctx context.Context) (*lnmarkets.Leaderboard, error) {
+	value, err := s.cached(ctx, "leaderboard", LeaderboardTTL, func(ctx context.Context) (interface{}, error) {
+		return s.client.GetLeaderboardContext(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*lnmarkets.Leaderboard), nil
+}
+
+func historyKey(name, interval string, r lnmarkets.HistoryRange) string {
+	return fmt.Sprintf("%s:%s:%d:%d:%d", name, interval, r.From, r.To, r.Limit)
+}
+
+// cached returns the cached value for key, calling fetch when it is missing
+// or older than ttl.
+func (s *MarketDataService) cached(ctx context.Context, key string, ttl time.Duration,
+	fetch func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	s.mu.Lock()
+	entry, ok := s.cache[key]
+	s.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.value, nil
+	}
+
+	value, err := fetch(ctx)
+	if err != nil {
+		if ok && ctx.Err() == nil {
+			log.Printf("Serving stale %s market data: %v", key, err)
+			return entry.value, nil
+		}
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.cache) >= maxMarketDataEntries {
+		now := time.Now()
+		for k, e := range s.cache {
+			if now.After(e.expires) {
+				delete(s.cache, k)
+			}
+		}
+	}
+	if len(s.cache) < maxMarketDataEntries {
+		s.cache[key] = cachedMarketData{value: value, expires: time.Now().Add(ttl)}
+	}
+
+	return value, nil
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"btc-trading-bot/internal/database"
+	"btc-trading-bot/internal/models"
+	"btc-trading-bot/pkg/simulator"
+)
+
+// paperStore persists a user's paper-trading account to the paper_accounts
+// and paper_positions tables.
+type paperStore struct {
+	db     *database.Database
+	userID int
+}
+
+func (p *paperStore) SaveBalance(balance float64) error {
+	_, err := p.db.Exec("UPDATE paper_accounts SET balance = $1, updated_at = $2 WHERE user_id = $3",
+		balance, time.Now(), p.userID)
+	return err
+}
+
+func (p *paperStore) SavePosition(pos simulator.Position) error {
+	_, err := p.db.Exec(`
+		INSERT INTO paper_positions (user_id, position_id, order_type, side, quantity, entry_price, leverage, margin, liquidation,
+			take_profit, stop_loss, opening_fee, closing_fee, exit_price, pl, status, created_at, filled_at, closed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)
+		ON CONFLICT (user_id, position_id) DO UPDATE
+		SET entry_price = EXCLUDED.entry_price, leverage = EXCLUDED.leverage, margin = EXCLUDED.margin,
+			liquidation = EXCLUDED.liquidation, take_profit = EXCLUDED.take_profit, stop_loss = EXCLUDED.stop_loss,
+			opening_fee = EXCLUDED.opening_fee, closing_fee = EXCLUDED.closing_fee, exit_price = EXCLUDED.exit_price,
+			pl = EXCLUDED.pl, status = EXCLUDED.status, filled_at = EXCLUDED.filled_at, closed_at = EXCLUDED.closed_at
+	`, p.userID, pos.ID, pos.Type, pos.Side, pos.Quantity, pos.EntryPrice, pos.Leverage, pos.Margin, pos.Liquidation,
+		pos.TakeProfit, pos.StopLoss, pos.OpeningFee, pos.ClosingFee, pos.ExitPrice, pos.PL, pos.Status, pos.CreatedAt,
+		nullableTime(pos.FilledAt), nullableTime(pos.ClosedAt))
+	return err
+}
+
+func nullableTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// loadPaperExchange returns the simulated exchange holding the user's virtual
+// account, restoring it on first use. Bots and requests share it, so every
+// paper trade goes through the same account state. When create is set a new
+// account is opened with PAPER_INITIAL_BALANCE sats if the user has none yet.
+func (s *TradingService) loadPaperExchange(userID int, create bool) (*simulator.Exchange, error) {
+	s.paperMu.Lock()
+	defer s.paperMu.Unlock()
+
+	exchange, ok := s.paperExchanges[userID]
+	if !ok {
+		var err error
+		exchange, err = s.restorePaperExchange(userID, create)
+		if err != nil {
+			return nil, err
+		}
+		s.paperExchanges[userID] = exchange
+	}
+
+	if snap := s.priceAggregator.Latest(); snap.Price > 0 {
+		exchange.UpdatePrice(snap.Price)
+	}
+	return exchange, nil
+}
+
+// feedPaperExchanges keeps every loaded paper account on the aggregated price
+// until the service stops, so resting limit orders, take profits, stop losses
+// and liquidations settle whether or not the user's bot is running.
+func (s *TradingService) feedPaperExchanges() {
+	snapshots, unsubscribe := s.priceAggregator.Subscribe()
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case snap, ok := <-snapshots:
+			if !ok {
+				return
+			}
+			s.updatePaperPrices(snap.Price)
+		}
+	}
+}
+
+func (s *TradingService) updatePaperPrices(price float64) {
+	s.paperMu.Lock()
+	exchanges := make([]*simulator.Exchange, 0, len(s.paperExchanges))
+	for _, exchange := range s.paperExchanges {
+		exchanges = append(exchanges, exchange)
+	}
+	s.paperMu.Unlock()
+
+	for _, exchange := range exchanges {
+		exchange.UpdatePrice(price)
+	}
+}
+
+func (s *TradingService) restorePaperExchange(userID int, create bool) (*simulator.Exchange, error) {
+	if create {
+		initialBalance := envFloat("PAPER_INITIAL_BALANCE", 1_000_000)
+		_, err := s.db.Exec(`
+			INSERT INTO paper_accounts (user_id, balance, initial_balance, created_at, updated_at)
+			VALUES ($1, $2, $2, $3, $3)
+			ON CONFLICT (user_id) DO NOTHING
+		`, userID, initialBalance, time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create paper account: %v", err)
+		}
+	}
+
+	var account models.PaperAccount
+	err := s.db.Get(&account, "SELECT * FROM paper_accounts WHERE user_id = $1", userID)
+	if err != nil {
+		return nil, fmt.Errorf("paper account not found: %v", err)
+	}
+
+	var rows []models.PaperPosition
+	err = s.db.Select(&rows, "SELECT * FROM paper_positions WHERE user_id = $1 ORDER BY created_at", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load paper positions: %v", err)
+	}
+
+	positions := make([]simulator.Position, 0, len(rows))
+	for _, row := range rows {
+		pos := simulator.Position{
+			ID:          row.PositionID,
+			Type:        row.OrderType,
+			Side:        row.Side,
+			Quantity:    row.Quantity,
+			EntryPrice:  row.EntryPrice,
+			Leverage:    row.Leverage,
+			Margin:      row.Margin,
+			Liquidation: row.Liquidation,
+			TakeProfit:  row.TakeProfit,
+			StopLoss:    row.StopLoss,
+			OpeningFee:  row.OpeningFee,
+			ClosingFee:  row.ClosingFee,
+			ExitPrice:   row.ExitPrice,
+			PL:          row.PL,
+			Status:      row.Status,
+			CreatedAt:   row.CreatedAt,
+		}
+		if row.FilledAt != nil {
+			pos.FilledAt = *row.FilledAt
+		}
+		if row.ClosedAt != nil {
+			pos.ClosedAt = *row.ClosedAt
+		}
+		positions = append(positions, pos)
+	}
+
+	exchange := simulator.NewExchange(simulator.Config{
+		InitialBalance: account.InitialBalance,
+		FeeRate:        envFloat("SIM_FEE_RATE", 0.001),
+		MaxLeverage:    envFloat("SIM_MAX_LEVERAGE", 100),
+		IDPrefix:       fmt.Sprintf("paper-%d", userID),
+		Store:          &paperStore{db: s.db, userID: userID},
+	})
+	exchange.Restore(account.Balance, positions)
+	return exchange, nil
+}
+
+// GetPaperAccount returns the user's virtual account together with the
+// realized PnL of every closed paper position.
+func (s *TradingService) GetPaperAccount(userID int) (*models.PaperAccount, float64, error) {
+	var account models.PaperAccount
+	err := s.db.Get(&account, "SELECT * FROM paper_accounts WHERE user_id = $1", userID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("paper account not found: %v", err)
+	}
+
+	var realizedPL float64
+	err = s.db.Get(&realizedPL, "SELECT COALESCE(SUM(pl - opening_fee - closing_fee), 0) FROM paper_positions WHERE user_id = $1 AND status IN ('closed', 'liquidated')", userID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to compute paper PnL: %v", err)
+	}
+
+	return &account, realizedPL, nil
+}
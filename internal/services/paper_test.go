@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"btc-trading-bot/pkg/lnmarkets"
+	"btc-trading-bot/pkg/simulator"
+)
+
+// A resting paper limit order fills from the aggregated price with no bot
+// running.
+func TestPaperExchangesFollowAggregator(t *testing.T) {
+	ctx := context.Background()
+	aggregator := &PriceAggregator{subscribers: make(map[chan PriceSnapshot]struct{})}
+	exchange := simulator.NewExchange(simulator.Config{InitialBalance: 1_000_000, FeeRate: 0.001, MaxLeverage: 100})
+	exchange.UpdatePrice(50_000)
+	trade, err := exchange.CreateTradeContext(ctx, &lnmarkets.TradeRequest{
+		Type: lnmarkets.OrderTypeLimit, Side: lnmarkets.SideBuy, Price: 49_000, Quantity: 1000, Leverage: 10,
+	})
+	if err != nil {
+		t.Fatalf("CreateTrade: %v", err)
+	}
+
+	s := NewTradingService(nil, aggregator, nil)
+	s.paperExchanges[1] = exchange
+	s.Start()
+	t.Cleanup(s.Stop)
+
+	waitForSubscriber(t, aggregator)
+	aggregator.broadcast(PriceSnapshot{Price: 48_900, Timestamp: time.Now().UnixMilli()})
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		position, err := exchange.GetPositionContext(ctx, trade.ID)
+		if err != nil {
+			t.Fatalf("GetPosition: %v", err)
+		}
+		if position.Running {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("paper limit order at 49000 not filled after the aggregator traded at 48900")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func waitForSubscriber(t *testing.T, aggregator *PriceAggregator) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		aggregator.subscribersMu.Lock()
+		n := len(aggregator.subscribers)
+		aggregator.subscribersMu.Unlock()
+		if n > 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("nothing subscribed to the aggregator")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
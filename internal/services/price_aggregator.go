@@ -2,34 +2,78 @@ package services
 
 import (
 	"context"
-	"encoding/json"
-	"errors"
+	"fmt"
+	"log"
+	"math"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
-// PriceSnapshot represents a single aggregated price observation.
+// PriceSnapshot represents a single aggregated price observation. Sources
+// holds the accepted quotes; Rejected explains why every other configured
+// source was left out. Snapshots are published in USD; InCurrency converts
+// them, setting the USD rate used in FXRate.
 type PriceSnapshot struct {
 	Price     float64            `json:"price"`
+	Currency  string             `json:"currency"`
+	FXRate    float64            `json:"fx_rate,omitempty"`
 	Timestamp int64              `json:"timestamp"`
+	Method    string             `json:"method"`
 	Sources   map[string]float64 `json:"sources"`
+	Rejected  map[string]string  `json:"rejected,omitempty"`
 }
 
-// PriceAggregator periodically fetches BTC/USD prices from multiple exchanges
-// and maintains an averaged price for subscribers.
+// PriceAggregator combines BTC/USD quotes from the sources selected by
+// AGG_SOURCES with the AGG_METHOD aggregation and publishes the result to
+// subscribers. Sources with a websocket feed push quotes as they happen;
+// the others, and streaming sources whose feed is down or quiet, are polled
+// every second.
 type PriceAggregator struct {
-	httpClient    *http.Client
-	pollInterval  time.Duration
+	httpClient   *http.Client
+	timeout      time.Duration
+	pollInterval time.Duration
+	// quoteMaxAge is how old a quote may be and still count.
+	quoteMaxAge time.Duration
+	// publishInterval throttles snapshots triggered by stream updates.
+	publishInterval time.Duration
+	streaming       bool
+	fx              *FXRates
+
+	sourcesMu     sync.RWMutex
+	sources       []sourceConfig
+	aggregation   aggregationConfig
+	started       bool
+	stopStreams   context.CancelFunc
+	statesMu      sync.Mutex
+	states        map[string]*sourceState
+	updated       chan struct{}
+	polling       atomic.Bool
+	belowQuorum   atomic.Bool
 	stopChan      chan struct{}
+	stopOnce      sync.Once
 	subscribersMu sync.Mutex
 	subscribers   map[chan PriceSnapshot]struct{}
 	latestAtomic  atomic.Value // stores PriceSnapshot
 }
 
+// sourceState is the latest quote from a source, streamed or polled, and
+// its health statistics.
+type sourceState struct {
+	quote     Quote
+	updatedAt time.Time
+	err       error
+	streaming bool
+	stats     sourceStats
+}
+
+// NewPriceAggregator reads AGG_HTTP_TIMEOUT_MS, AGG_STREAMING (default true),
+// AGG_QUOTE_MAX_AGE_MS (default 3000) and AGG_PUBLISH_INTERVAL_MS (default
+// 250) besides the source and aggregation settings loaded by Reload.
 func NewPriceAggregator() *PriceAggregator {
 	timeoutStr := os.Getenv("AGG_HTTP_TIMEOUT_MS")
 	timeout := 1200 * time.Millisecond
@@ -39,8 +83,8 @@ func NewPriceAggregator() *PriceAggregator {
 		}
 	}
 
+	// Requests are bounded per source; see sourceConfig.
 	client := &http.Client{
-		Timeout: timeout,
 		Transport: &http.Transport{
 			MaxIdleConns:        100,
 			MaxIdleConnsPerHost: 10,
@@ -50,36 +94,287 @@ func NewPriceAggregator() *PriceAggregator {
 	}
 
 	agg := &PriceAggregator{
-		httpClient:   client,
-		pollInterval: time.Second,
-		stopChan:     make(chan struct{}),
-		subscribers:  make(map[chan PriceSnapshot]struct{}),
+		httpClient:      client,
+		timeout:         timeout,
+		pollInterval:    time.Second,
+		quoteMaxAge:     time.Duration(envFloat("AGG_QUOTE_MAX_AGE_MS", 3000)) * time.Millisecond,
+		publishInterval: time.Duration(envFloat("AGG_PUBLISH_INTERVAL_MS", 250)) * time.Millisecond,
+		streaming:       os.Getenv("AGG_STREAMING") != "false",
+		fx:              NewFXRates(client),
+		states:          make(map[string]*sourceState),
+		updated:         make(chan struct{}, 1),
+		stopChan:        make(chan struct{}),
+		subscribers:     make(map[chan PriceSnapshot]struct{}),
+	}
+	if err := agg.Reload(); err != nil {
+		log.Printf("Invalid aggregator configuration, using defaults: %v", err)
+		agg.sources, _ = parsePriceSources(defaultPriceSources, client, timeout)
+		agg.aggregation = aggregationConfig{method: AggregateMedian, trim: 0.2, maxDeviation: 0.01, minSources: 2}
 	}
 	agg.latestAtomic.Store(PriceSnapshot{})
 	return agg
 }
 
+// Reload re-reads AGG_SOURCES and the aggregation settings, so a source can
+// be dropped during an exchange outage without a restart. On error the
+// current configuration is kept.
+func (p *PriceAggregator) Reload() error {
+	spec := os.Getenv("AGG_SOURCES")
+	if spec == "" {
+		spec = defaultPriceSources
+	}
+
+	sources, err := parsePriceSources(spec, p.httpClient, p.timeout)
+	if err != nil {
+		return err
+	}
+	aggregation, err := loadAggregationConfig(len(sources))
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, len(sources))
+	for i, src := range sources {
+		names[i] = src.source.Name()
+	}
+
+	p.sourcesMu.Lock()
+	p.sources = sources
+	p.aggregation = aggregation
+	if p.started {
+		p.startStreamsLocked()
+	}
+	p.sourcesMu.Unlock()
+
+	// Restarted streams report again once connected.
+	p.statesMu.Lock()
+	for name, state := range p.states {
+		if !containsString(names, name) {
+			delete(p.states, name)
+		} else {
+			state.streaming = false
+		}
+	}
+	p.statesMu.Unlock()
+
+	log.Printf("Price aggregator sources: %s (%s, quorum %d)", strings.Join(names, ", "), aggregation.method, aggregation.minSources)
+	return nil
+}
+
 func (p *PriceAggregator) Start() {
+	p.sourcesMu.Lock()
+	p.started = true
+	p.startStreamsLocked()
+	p.sourcesMu.Unlock()
+
+	go p.run()
+	go p.fx.run(p.stopChan)
+}
+
+func (p *PriceAggregator) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stopChan)
+		p.sourcesMu.Lock()
+		if p.stopStreams != nil {
+			p.stopStreams()
+		}
+		p.started = false
+		p.sourcesMu.Unlock()
+	})
+}
+
+// run polls every pollInterval and publishes a snapshot after each poll and,
+// at most every publishInterval, after stream updates.
+func (p *PriceAggregator) run() {
 	ticker := time.NewTicker(p.pollInterval)
-	go func() {
-		defer ticker.Stop()
-		for {
-			select {
-			case <-p.stopChan:
-				return
-			case <-ticker.C:
-				snapshot, ok := p.fetchAndAggregate()
-				if ok {
-					p.latestAtomic.Store(snapshot)
-					p.broadcast(snapshot)
+	defer ticker.Stop()
+
+	var lastPublish time.Time
+	for {
+		select {
+		case <-p.stopChan:
+			return
+		case <-ticker.C:
+			// A slow source must not delay streamed quotes, so polls run
+			// alongside the publisher, one at a time.
+			if p.polling.CompareAndSwap(false, true) {
+				go func() {
+					defer p.polling.Store(false)
+					p.pollSources()
+					p.notifyUpdate()
+				}()
+			}
+		case <-p.updated:
+			if wait := p.publishInterval - time.Since(lastPublish); wait > 0 {
+				select {
+				case <-p.stopChan:
+					return
+				case <-time.After(wait):
 				}
 			}
+			lastPublish = time.Now()
+
+			if snapshot, ok := p.aggregateLatest(); ok {
+				p.latestAtomic.Store(snapshot)
+				p.broadcast(snapshot)
+			}
 		}
-	}()
+	}
 }
 
-func (p *PriceAggregator) Stop() {
-	close(p.stopChan)
+func (p *PriceAggregator) notifyUpdate() {
+	select {
+	case p.updated <- struct{}{}:
+	default:
+	}
+}
+
+// startStreamsLocked restarts the websocket feeds of the configured sources.
+// sourcesMu must be held.
+func (p *PriceAggregator) startStreamsLocked() {
+	if p.stopStreams != nil {
+		p.stopStreams()
+		p.stopStreams = nil
+	}
+	if !p.streaming {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.stopStreams = cancel
+	for _, src := range p.sources {
+		if stream, ok := src.source.(StreamingPriceSource); ok {
+			go p.runStream(ctx, stream)
+		}
+	}
+}
+
+// runStream keeps a source's websocket feed connected, reconnecting with
+// backoff. Polling covers the source while the feed is down.
+func (p *PriceAggregator) runStream(ctx context.Context, source StreamingPriceSource) {
+	name := source.Name()
+	for attempt := 0; ; {
+		connectedAt := time.Now()
+		err := source.Stream(ctx, func(quote Quote) {
+			if ctx.Err() == nil {
+				p.recordQuote(name, quote, nil, true)
+				p.notifyUpdate()
+			}
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		p.recordStreamDown(name, err)
+
+		// A stream that stayed up for a while starts over from the
+		// shortest delay.
+		if time.Since(connectedAt) > time.Minute {
+			attempt = 0
+		}
+		delay := streamBackoff(attempt)
+		attempt++
+		log.Printf("Price stream %s down, reconnecting in %s: %v", name, delay, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// pollSources fetches a REST quote from every source without a live stream.
+func (p *PriceAggregator) pollSources() {
+	p.sourcesMu.RLock()
+	sources := p.sources
+	p.sourcesMu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, src := range sources {
+		if p.streamFresh(src.source.Name()) {
+			continue
+		}
+
+		wg.Add(1)
+		go func(src sourceConfig) {
+			defer wg.Done()
+			// Each source is bounded by its own timeout.
+			ctx, cancel := context.WithTimeout(context.Background(), src.timeout)
+			defer cancel()
+			start := time.Now()
+			quote, err := src.source.FetchQuote(ctx)
+			p.recordFetch(src.source.Name(), time.Since(start), err)
+			p.recordQuote(src.source.Name(), quote, err, false)
+		}(src)
+	}
+	wg.Wait()
+}
+
+// streamFresh reports whether name's stream delivered a quote recently
+// enough that polling it would be redundant.
+func (p *PriceAggregator) streamFresh(name string) bool {
+	p.statesMu.Lock()
+	defer p.statesMu.Unlock()
+
+	state, ok := p.states[name]
+	return ok && state.streaming && time.Since(state.updatedAt) < p.quoteMaxAge
+}
+
+func (p *PriceAggregator) recordQuote(name string, quote Quote, err error, streamed bool) {
+	p.statesMu.Lock()
+	defer p.statesMu.Unlock()
+
+	state := p.stateLocked(name)
+	if streamed {
+		state.streaming = true
+		state.stats.streamQuotes++
+	}
+	if err != nil {
+		state.err = err
+		return
+	}
+	state.quote = quote
+	state.updatedAt = time.Now()
+	state.err = nil
+}
+
+func (p *PriceAggregator) recordStreamDown(name string, err error) {
+	p.statesMu.Lock()
+	defer p.statesMu.Unlock()
+
+	state := p.stateLocked(name)
+	state.streaming = false
+	state.stats.streamDisconnects++
+	if err != nil {
+		state.stats.lastError = "stream: " + err.Error()
+		state.stats.lastErrorAt = time.Now()
+	}
+}
+
+// FX returns the rates snapshots are converted with.
+func (p *PriceAggregator) FX() *FXRates {
+	return p.fx
+}
+
+// InCurrency converts a USD snapshot to currency.
+func (p *PriceAggregator) InCurrency(snap PriceSnapshot, currency string) (PriceSnapshot, error) {
+	if currency == snap.Currency {
+		return snap, nil
+	}
+	rate, err := p.fx.Rate(currency)
+	if err != nil {
+		return PriceSnapshot{}, err
+	}
+
+	converted := snap
+	converted.Price = snap.Price * rate
+	converted.Currency = currency
+	converted.FXRate = rate
+	converted.Sources = make(map[string]float64, len(snap.Sources))
+	for name, price := range snap.Sources {
+		converted.Sources[name] = price * rate
+	}
+	return converted, nil
 }
 
 // Latest returns the most recent snapshot.
@@ -124,147 +419,93 @@ func (p *PriceAggregator) broadcast(snap PriceSnapshot) {
 		select {
 		case ch <- snap:
 		default:
-			// drop if slow consumer
+			// A slow consumer gets the newest snapshot instead of the one
+			// it has not read yet.
+			select {
+			case <-ch:
+			default:
+			}
+			select {
+			case ch <- snap:
+			default:
+			}
 		}
 	}
 }
 
-func (p *PriceAggregator) fetchAndAggregate() (PriceSnapshot, bool) {
-	// Context to bound each poll cycle
-	ctx, cancel := context.WithTimeout(context.Background(), p.httpClient.Timeout)
-	defer cancel()
+// aggregateLatest combines the latest quote of every source that passes
+// outlier rejection. It reports false, publishing nothing, when fewer than
+// the quorum of sources were accepted.
+func (p *PriceAggregator) aggregateLatest() (PriceSnapshot, bool) {
+	p.sourcesMu.RLock()
+	sources := p.sources
+	config := p.aggregation
+	p.sourcesMu.RUnlock()
 
-	var wg sync.WaitGroup
-	wg.Add(3)
-
-	type result struct {
-		name  string
-		price float64
-		err   error
-	}
-
-	results := make(chan result, 3)
-
-	go func() {
-		defer wg.Done()
-		price, err := p.fetchBinance(ctx)
-		results <- result{name: "binance", price: price, err: err}
-	}()
-	go func() {
-		defer wg.Done()
-		price, err := p.fetchCoinbase(ctx)
-		results <- result{name: "coinbase", price: price, err: err}
-	}()
-	go func() {
-		defer wg.Done()
-		price, err := p.fetchKraken(ctx)
-		results <- result{name: "kraken", price: price, err: err}
-	}()
-
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
-
-	sum := 0.0
-	count := 0
-	sources := make(map[string]float64, 3)
-
-	for r := range results {
-		if r.err == nil && r.price > 0 {
-			sum += r.price
-			count++
-			sources[r.name] = r.price
+	now := time.Now()
+	rejected := make(map[string]string)
+	quotes := make([]sourceQuote, 0, len(sources))
+
+	p.statesMu.Lock()
+	for _, src := range sources {
+		name := src.source.Name()
+		state, ok := p.states[name]
+		switch {
+		case !ok:
+			rejected[name] = "no quote yet"
+		case now.Sub(state.updatedAt) > p.quoteMaxAge:
+			if state.err != nil {
+				rejected[name] = "error: " + state.err.Error()
+			} else {
+				rejected[name] = fmt.Sprintf("stale quote (%s old)", now.Sub(state.updatedAt).Round(time.Millisecond))
+			}
+		case state.quote.Price <= 0 || math.IsNaN(state.quote.Price) || math.IsInf(state.quote.Price, 0):
+			rejected[name] = "invalid price"
+		default:
+			quotes = append(quotes, sourceQuote{name: name, weight: src.weight, Quote: state.quote})
 		}
 	}
+	p.statesMu.Unlock()
 
-	if count == 0 {
+	fresh := quotes
+	quotes = rejectOutliers(quotes, config.maxDeviation, rejected)
+	if len(quotes) < config.minSources {
+		if !p.belowQuorum.Swap(true) {
+			log.Printf("Price aggregator below quorum: %d of %d sources accepted, need %d: %v",
+				len(quotes), len(sources), config.minSources, rejected)
+		}
 		return PriceSnapshot{}, false
 	}
-
-	avg := sum / float64(count)
-	return PriceSnapshot{
-		Price:     avg,
-		Timestamp: time.Now().UnixMilli(),
-		Sources:   sources,
-	}, true
-}
-
-func (p *PriceAggregator) fetchBinance(ctx context.Context) (float64, error) {
-	// https://api.binance.com/api/v3/ticker/price?symbol=BTCUSDT
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://api.binance.com/api/v3/ticker/price?symbol=BTCUSDT", nil)
-	if err != nil {
-		return 0, err
-	}
-	resp, err := p.httpClient.Do(req)
-	if err != nil {
-		return 0, err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return 0, errors.New("binance non-200")
-	}
-	var payload struct {
-		Price string `json:"price"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
-		return 0, err
+	if p.belowQuorum.Swap(false) {
+		log.Printf("Price aggregator quorum restored with %d sources", len(quotes))
 	}
-	return strconv.ParseFloat(payload.Price, 64)
-}
 
-func (p *PriceAggregator) fetchCoinbase(ctx context.Context) (float64, error) {
-	// https://api.coinbase.com/v2/prices/spot?currency=USD
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://api.coinbase.com/v2/prices/spot?currency=USD", nil)
-	if err != nil {
-		return 0, err
-	}
-	req.Header.Set("User-Agent", "btc-trading-bot/1.0")
-	resp, err := p.httpClient.Do(req)
-	if err != nil {
-		return 0, err
+	prices := make(map[string]float64, len(quotes))
+	for _, q := range quotes {
+		prices[q.name] = q.Price
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return 0, errors.New("coinbase non-200")
-	}
-	var payload struct {
-		Data struct {
-			Amount string `json:"amount"`
-		} `json:"data"`
+
+	price := aggregate(config.method, quotes, config.trim)
+	p.recordDeviations(fresh, price)
+
+	snapshot := PriceSnapshot{
+		Price:     price,
+		Currency:  "USD",
+		Timestamp: now.UnixMilli(),
+		Method:    config.method,
+		Sources:   prices,
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
-		return 0, err
+	if len(rejected) > 0 {
+		snapshot.Rejected = rejected
 	}
-	return strconv.ParseFloat(payload.Data.Amount, 64)
+	return snapshot, true
 }
 
-func (p *PriceAggregator) fetchKraken(ctx context.Context) (float64, error) {
-	// https://api.kraken.com/0/public/Ticker?pair=XBTUSD
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://api.kraken.com/0/public/Ticker?pair=XBTUSD", nil)
-	if err != nil {
-		return 0, err
-	}
-	resp, err := p.httpClient.Do(req)
-	if err != nil {
-		return 0, err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return 0, errors.New("kraken non-200")
-	}
-	var payload struct {
-		Result map[string]struct {
-			C []string `json:"c"`
-		} `json:"result"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
-		return 0, err
-	}
-	for _, v := range payload.Result {
-		if len(v.C) > 0 {
-			return strconv.ParseFloat(v.C[0], 64)
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
 		}
 	}
-	return 0, errors.New("kraken malformed")
+	return false
 }
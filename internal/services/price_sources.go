@@ -0,0 +1,316 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"btc-trading-bot/pkg/lnmarkets"
+)
+
+// PriceSource fetches the current BTC/USD quote from a single venue.
+type PriceSource interface {
+	Name() string
+	FetchQuote(ctx context.Context) (Quote, error)
+}
+
+// Quote is a source's last price. Volume is the 24h traded volume in BTC, or
+// zero when the venue does not report it.
+type Quote struct {
+	Price  float64
+	Volume float64
+}
+
+// PriceSourceFactory builds a source that makes its requests with client.
+type PriceSourceFactory func(client *http.Client) PriceSource
+
+var (
+	priceSourcesMu sync.RWMutex
+	priceSources   = make(map[string]PriceSourceFactory)
+)
+
+// RegisterPriceSource makes a source available to AGG_SOURCES under name.
+func RegisterPriceSource(name string, factory PriceSourceFactory) {
+	priceSourcesMu.Lock()
+	defer priceSourcesMu.Unlock()
+	priceSources[name] = factory
+}
+
+// PriceSourceNames lists the registered sources.
+func PriceSourceNames() []string {
+	priceSourcesMu.RLock()
+	defer priceSourcesMu.RUnlock()
+
+	names := make([]string, 0, len(priceSources))
+	for name := range priceSources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterPriceSource("binance", withStream(jsonPriceSource("binance", "https://api.binance.com/api/v3/ticker/24hr?symbol=BTCUSDT",
+		func(body []byte) (Quote, error) {
+			var payload struct {
+				LastPrice string `json:"lastPrice"`
+				Volume    string `json:"volume"`
+			}
+			if err := json.Unmarshal(body, &payload); err != nil {
+				return Quote{}, err
+			}
+			return parseQuote(payload.LastPrice, payload.Volume)
+		}), binanceStream))
+
+	RegisterPriceSource("coinbase", withStream(jsonPriceSource("coinbase", "https://api.coinbase.com/v2/prices/spot?currency=USD",
+		func(body []byte) (Quote, error) {
+			var payload struct {
+				Data struct {
+					Amount string `json:"amount"`
+				} `json:"data"`
+			}
+			if err := json.Unmarshal(body, &payload); err != nil {
+				return Quote{}, err
+			}
+			return parseQuote(payload.Data.Amount, "")
+		}), coinbaseStream))
+
+	RegisterPriceSource("kraken", withStream(jsonPriceSource("kraken", "https://api.kraken.com/0/public/Ticker?pair=XBTUSD",
+		func(body []byte) (Quote, error) {
+			// c is the last trade [price, lot volume]; v is [today, last 24h].
+			var payload struct {
+				Result map[string]struct {
+					C []string `json:"c"`
+					V []string `json:"v"`
+				} `json:"result"`
+			}
+			if err := json.Unmarshal(body, &payload); err != nil {
+				return Quote{}, err
+			}
+			for _, v := range payload.Result {
+				if len(v.C) > 0 {
+					volume := ""
+					if len(v.V) > 1 {
+						volume = v.V[1]
+					}
+					return parseQuote(v.C[0], volume)
+				}
+			}
+			return Quote{}, fmt.Errorf("kraken malformed")
+		}), krakenStream))
+
+	RegisterPriceSource("bitstamp", withStream(jsonPriceSource("bitstamp", "https://www.bitstamp.net/api/v2/ticker/btcusd/",
+		func(body []byte) (Quote, error) {
+			var payload struct {
+				Last   string `json:"last"`
+				Volume string `json:"volume"`
+			}
+			if err := json.Unmarshal(body, &payload); err != nil {
+				return Quote{}, err
+			}
+			return parseQuote(payload.Last, payload.Volume)
+		}), bitstampStream))
+
+	// Bitfinex tickers are arrays; the last traded price and the 24h volume
+	// are the seventh and eighth fields.
+	RegisterPriceSource("bitfinex", jsonPriceSource("bitfinex", "https://api-pub.bitfinex.com/v2/ticker/tBTCUSD",
+		func(body []byte) (Quote, error) {
+			var payload []float64
+			if err := json.Unmarshal(body, &payload); err != nil {
+				return Quote{}, err
+			}
+			if len(payload) < 8 {
+				return Quote{}, fmt.Errorf("bitfinex malformed")
+			}
+			return Quote{Price: payload[6], Volume: payload[7]}, nil
+		}))
+
+	RegisterPriceSource("okx", withStream(jsonPriceSource("okx", "https://www.okx.com/api/v5/market/ticker?instId=BTC-USDT",
+		func(body []byte) (Quote, error) {
+			var payload struct {
+				Code string `json:"code"`
+				Msg  string `json:"msg"`
+				Data []struct {
+					Last   string `json:"last"`
+					Vol24h string `json:"vol24h"`
+				} `json:"data"`
+			}
+			if err := json.Unmarshal(body, &payload); err != nil {
+				return Quote{}, err
+			}
+			if payload.Code != "0" || len(payload.Data) == 0 {
+				return Quote{}, fmt.Errorf("okx error %s: %s", payload.Code, payload.Msg)
+			}
+			return parseQuote(payload.Data[0].Last, payload.Data[0].Vol24h)
+		}), okxStream))
+
+	RegisterPriceSource("gemini", jsonPriceSource("gemini", "https://api.gemini.com/v1/pubticker/btcusd",
+		func(body []byte) (Quote, error) {
+			var payload struct {
+				Last   string `json:"last"`
+				Volume struct {
+					BTC string `json:"BTC"`
+				} `json:"volume"`
+			}
+			if err := json.Unmarshal(body, &payload); err != nil {
+				return Quote{}, err
+			}
+			return parseQuote(payload.Last, payload.Volume.BTC)
+		}))
+
+	RegisterPriceSource("lnmarkets", func(client *http.Client) PriceSource {
+		return newLNMarketsIndexSource(client)
+	})
+}
+
+// httpPriceSource reads a public JSON ticker endpoint.
+type httpPriceSource struct {
+	name   string
+	url    string
+	client *http.Client
+	parse  func(body []byte) (Quote, error)
+}
+
+func jsonPriceSource(name, url string, parse func(body []byte) (Quote, error)) PriceSourceFactory {
+	return func(client *http.Client) PriceSource {
+		return &httpPriceSource{name: name, url: url, client: client, parse: parse}
+	}
+}
+
+func (s *httpPriceSource) Name() string {
+	return s.name
+}
+
+func (s *httpPriceSource) FetchQuote(ctx context.Context) (Quote, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return Quote{}, err
+	}
+	req.Header.Set("User-Agent", "btc-trading-bot/1.0")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return Quote{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Quote{}, fmt.Errorf("%s non-200: %d", s.name, resp.StatusCode)
+	}
+
+	var body json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return Quote{}, err
+	}
+	return s.parse(body)
+}
+
+// parseQuote parses a decimal price and an optional decimal volume.
+func parseQuote(price, volume string) (Quote, error) {
+	var quote Quote
+	var err error
+	if quote.Price, err = strconv.ParseFloat(price, 64); err != nil {
+		return Quote{}, err
+	}
+	if volume != "" {
+		if quote.Volume, err = strconv.ParseFloat(volume, 64); err != nil {
+			return Quote{}, err
+		}
+	}
+	return quote, nil
+}
+
+// lnMarketsIndexSource reports the LN Markets index, the price futures are
+// marked and liquidated against.
+type lnMarketsIndexSource struct {
+	client *lnmarkets.Client
+}
+
+func newLNMarketsIndexSource(httpClient *http.Client) *lnMarketsIndexSource {
+	client := lnmarkets.NewClient("", "", "", os.Getenv("LN_MARKETS_IS_TESTNET") == "true")
+	client.HTTPClient = httpClient
+	// A late price is useless; the next poll is the retry.
+	client.MaxRetries = 0
+	return &lnMarketsIndexSource{client: client}
+}
+
+func (s *lnMarketsIndexSource) Name() string {
+	return "lnmarkets"
+}
+
+func (s *lnMarketsIndexSource) FetchQuote(ctx context.Context) (Quote, error) {
+	ticker, err := s.client.GetTickerContext(ctx)
+	if err != nil {
+		return Quote{}, err
+	}
+	return Quote{Price: ticker.Index}, nil
+}
+
+// sourceConfig is a source selected by AGG_SOURCES.
+type sourceConfig struct {
+	source  PriceSource
+	weight  float64
+	timeout time.Duration
+}
+
+// defaultPriceSources is used when AGG_SOURCES is unset.
+const defaultPriceSources = "binance,coinbase,kraken"
+
+// parsePriceSources parses a comma-separated list of name[:weight[:timeout_ms]]
+// entries, e.g. "binance:2,coinbase,kraken:1:800". Weight defaults to 1 and
+// timeout to defaultTimeout.
+func parsePriceSources(spec string, client *http.Client, defaultTimeout time.Duration) ([]sourceConfig, error) {
+	priceSourcesMu.RLock()
+	defer priceSourcesMu.RUnlock()
+
+	var configs []sourceConfig
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.Split(entry, ":")
+		name := strings.ToLower(parts[0])
+		factory, ok := priceSources[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown price source %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("price source %q listed twice", name)
+		}
+		seen[name] = true
+
+		config := sourceConfig{source: factory(client), weight: 1, timeout: defaultTimeout}
+		if len(parts) > 1 && parts[1] != "" {
+			weight, err := strconv.ParseFloat(parts[1], 64)
+			if err != nil || weight <= 0 {
+				return nil, fmt.Errorf("invalid weight for price source %q: %s", name, parts[1])
+			}
+			config.weight = weight
+		}
+		if len(parts) > 2 && parts[2] != "" {
+			ms, err := strconv.Atoi(parts[2])
+			if err != nil || ms <= 0 {
+				return nil, fmt.Errorf("invalid timeout for price source %q: %s", name, parts[2])
+			}
+			config.timeout = time.Duration(ms) * time.Millisecond
+		}
+		if len(parts) > 3 {
+			return nil, fmt.Errorf("invalid price source entry %q", entry)
+		}
+		configs = append(configs, config)
+	}
+
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no price sources configured")
+	}
+	return configs, nil
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePriceSources(t *testing.T) {
+	tests := []struct {
+		spec     string
+		names    []string
+		weights  []float64
+		timeouts []time.Duration
+		err      string
+	}{
+		{spec: "binance,coinbase,kraken", names: []string{"binance", "coinbase", "kraken"},
+			weights: []float64{1, 1, 1}, timeouts: []time.Duration{time.Second, time.Second, time.Second}},
+		{spec: " Binance:2 , coinbase,, kraken:1:800", names: []string{"binance", "coinbase", "kraken"},
+			weights: []float64{2, 1, 1}, timeouts: []time.Duration{time.Second, time.Second, 800 * time.Millisecond}},
+		{spec: "gemini::250", names: []string{"gemini"},
+			weights: []float64{1}, timeouts: []time.Duration{250 * time.Millisecond}},
+		{spec: "", err: "no price sources configured"},
+		{spec: "binance,nyse", err: `unknown price source "nyse"`},
+		{spec: "binance,binance:2", err: "listed twice"},
+		{spec: "binance:0", err: "invalid weight"},
+		{spec: "binance:heavy", err: "invalid weight"},
+		{spec: "binance:1:-5", err: "invalid timeout"},
+		{spec: "binance:1:500:x", err: "invalid price source entry"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			configs, err := parsePriceSources(tt.spec, http.DefaultClient, time.Second)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("parsePriceSources error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parsePriceSources: %v", err)
+			}
+			if len(configs) != len(tt.names) {
+				t.Fatalf("got %d sources, want %v", len(configs), tt.names)
+			}
+			for i, config := range configs {
+				if config.source.Name() != tt.names[i] || config.weight != tt.weights[i] || config.timeout != tt.timeouts[i] {
+					t.Errorf("source %d = %s weight %v timeout %v, want %s %v %v", i,
+						config.source.Name(), config.weight, config.timeout, tt.names[i], tt.weights[i], tt.timeouts[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseQuote(t *testing.T) {
+	tests := []struct {
+		price, volume string
+		want          Quote
+		ok            bool
+	}{
+		{"64123.45", "1234.5", Quote{Price: 64123.45, Volume: 1234.5}, true},
+		{"64123.45", "", Quote{Price: 64123.45}, true},
+		{"", "1", Quote{}, false},
+		{"64123.45", "lots", Quote{}, false},
+	}
+	for _, tt := range tests {
+		got, err := parseQuote(tt.price, tt.volume)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseQuote(%q, %q) = %+v, %v, want %+v", tt.price, tt.volume, got, err, tt.want)
+		}
+	}
+}
+
+func TestHTTPPriceSource(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		fmt.Fprint(w, `{"last": "65000.5", "volume": "12"}`)
+	}))
+	defer server.Close()
+
+	source := jsonPriceSource("test", server.URL, func(body []byte) (Quote, error) {
+		var payload struct {
+			Last   string `json:"last"`
+			Volume string `json:"volume"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return Quote{}, err
+		}
+		return parseQuote(payload.Last, payload.Volume)
+	})(server.Client())
+
+	quote, err := source.FetchQuote(context.Background())
+	if err != nil || quote != (Quote{Price: 65000.5, Volume: 12}) {
+		t.Fatalf("FetchQuote = %+v, %v", quote, err)
+	}
+
+	status = http.StatusTooManyRequests
+	if _, err := source.FetchQuote(context.Background()); err == nil || !strings.Contains(err.Error(), "non-200: 429") {
+		t.Errorf("FetchQuote on 429 = %v, want a non-200 error", err)
+	}
+}
@@ -0,0 +1,284 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// StreamingPriceSource is a PriceSource that can also push quotes as they
+// happen. Stream calls onQuote for every update and blocks until ctx is done
+// or the stream fails; the aggregator reconnects it and polls FetchQuote in
+// the meantime.
+type StreamingPriceSource interface {
+	PriceSource
+	Stream(ctx context.Context, onQuote func(Quote)) error
+}
+
+const (
+	// streamReadTimeout drops a stream that sent nothing, not even a
+	// heartbeat, for this long.
+	streamReadTimeout = 30 * time.Second
+	// writeWait bounds writes to exchange streams.
+	writeWait        = 5 * time.Second
+	maxStreamBackoff = 30 * time.Second
+)
+
+// wsPriceStream is a public websocket ticker or trade feed.
+type wsPriceStream struct {
+	url string
+	// subscribe is sent once connected; nil when the URL selects the feed.
+	subscribe interface{}
+	// keepalive is sent every keepaliveInterval for venues that close idle
+	// connections without an application-level ping.
+	keepalive         []byte
+	keepaliveInterval time.Duration
+	// parse extracts a quote from a message and reports false for messages
+	// that carry none, such as heartbeats and subscription acks.
+	parse func(message []byte) (Quote, bool, error)
+}
+
+// streamingSource adds a websocket feed to a polled source.
+type streamingSource struct {
+	PriceSource
+	stream wsPriceStream
+}
+
+func withStream(factory PriceSourceFactory, stream wsPriceStream) PriceSourceFactory {
+	return func(client *http.Client) PriceSource {
+		return &streamingSource{PriceSource: factory(client), stream: stream}
+	}
+}
+
+func (s *streamingSource) Stream(ctx context.Context, onQuote func(Quote)) error {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, s.stream.url, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if s.stream.subscribe != nil {
+		conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := conn.WriteJSON(s.stream.subscribe); err != nil {
+			return err
+		}
+	}
+
+	// Closing the connection unblocks ReadMessage once ctx is done. After
+	// the subscription, this goroutine is the only writer.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		var keepalive <-chan time.Time
+		if s.stream.keepalive != nil {
+			ticker := time.NewTicker(s.stream.keepaliveInterval)
+			defer ticker.Stop()
+			keepalive = ticker.C
+		}
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				conn.Close()
+				return
+			case <-keepalive:
+				conn.SetWriteDeadline(time.Now().Add(writeWait))
+				if err := conn.WriteMessage(websocket.TextMessage, s.stream.keepalive); err != nil {
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(streamReadTimeout))
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+
+		quote, ok, err := s.stream.parse(message)
+		if err != nil {
+			return fmt.Errorf("%s stream: %v", s.Name(), err)
+		}
+		if ok && quote.Price > 0 {
+			onQuote(quote)
+		}
+	}
+}
+
+var binanceStream = wsPriceStream{
+	url: "wss://stream.binance.com:9443/ws/btcusdt@ticker",
+	parse: func(message []byte) (Quote, bool, error) {
+		var payload struct {
+			Event  string `json:"e"`
+			Close  string `json:"c"`
+			Volume string `json:"v"`
+		}
+		if err := json.Unmarshal(message, &payload); err != nil {
+			return Quote{}, false, err
+		}
+		if payload.Event != "24hrTicker" {
+			return Quote{}, false, nil
+		}
+		quote, err := parseQuote(payload.Close, payload.Volume)
+		return quote, err == nil, err
+	},
+}
+
+var coinbaseStream = wsPriceStream{
+	url: "wss://ws-feed.exchange.coinbase.com",
+	subscribe: map[string]interface{}{
+		"type":        "subscribe",
+		"product_ids": []string{"BTC-USD"},
+		"channels":    []string{"ticker"},
+	},
+	parse: func(message []byte) (Quote, bool, error) {
+		var payload struct {
+			Type      string `json:"type"`
+			Message   string `json:"message"`
+			Price     string `json:"price"`
+			Volume24h string `json:"volume_24h"`
+		}
+		if err := json.Unmarshal(message, &payload); err != nil {
+			return Quote{}, false, err
+		}
+		switch payload.Type {
+		case "ticker":
+			quote, err := parseQuote(payload.Price, payload.Volume24h)
+			return quote, err == nil, err
+		case "error":
+			return Quote{}, false, fmt.Errorf("%s", payload.Message)
+		}
+		return Quote{}, false, nil
+	},
+}
+
+// Kraken sends events as objects and channel data as arrays of
+// [channelID, data, channelName, pair].
+var krakenStream = wsPriceStream{
+	url: "wss://ws.kraken.com",
+	subscribe: map[string]interface{}{
+		"event":        "subscribe",
+		"pair":         []string{"XBT/USD"},
+		"subscription": map[string]string{"name": "ticker"},
+	},
+	parse: func(message []byte) (Quote, bool, error) {
+		if !bytes.HasPrefix(bytes.TrimSpace(message), []byte("[")) {
+			var event struct {
+				Event        string `json:"event"`
+				Status       string `json:"status"`
+				ErrorMessage string `json:"errorMessage"`
+			}
+			if err := json.Unmarshal(message, &event); err != nil {
+				return Quote{}, false, err
+			}
+			if event.Status == "error" {
+				return Quote{}, false, fmt.Errorf("%s", event.ErrorMessage)
+			}
+			return Quote{}, false, nil
+		}
+
+		var frame []json.RawMessage
+		if err := json.Unmarshal(message, &frame); err != nil {
+			return Quote{}, false, err
+		}
+		if len(frame) < 2 {
+			return Quote{}, false, nil
+		}
+		var ticker struct {
+			C []string `json:"c"`
+			V []string `json:"v"`
+		}
+		if err := json.Unmarshal(frame[1], &ticker); err != nil || len(ticker.C) == 0 {
+			return Quote{}, false, nil
+		}
+		volume := ""
+		if len(ticker.V) > 1 {
+			volume = ticker.V[1]
+		}
+		quote, err := parseQuote(ticker.C[0], volume)
+		return quote, err == nil, err
+	},
+}
+
+// Bitstamp has no ticker channel; the live trades feed carries the last
+// price but no volume.
+var bitstampStream = wsPriceStream{
+	url: "wss://ws.bitstamp.net",
+	subscribe: map[string]interface{}{
+		"event": "bts:subscribe",
+		"data":  map[string]string{"channel": "live_trades_btcusd"},
+	},
+	parse: func(message []byte) (Quote, bool, error) {
+		var payload struct {
+			Event string `json:"event"`
+			Data  struct {
+				Price float64 `json:"price"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(message, &payload); err != nil {
+			return Quote{}, false, err
+		}
+		switch payload.Event {
+		case "trade":
+			return Quote{Price: payload.Data.Price}, true, nil
+		case "bts:request_reconnect":
+			return Quote{}, false, fmt.Errorf("reconnect requested")
+		}
+		return Quote{}, false, nil
+	},
+}
+
+// OKX closes connections idle for 30s and answers a "ping" text frame with
+// "pong".
+var okxStream = wsPriceStream{
+	url: "wss://ws.okx.com:8443/ws/v5/public",
+	subscribe: map[string]interface{}{
+		"op":   "subscribe",
+		"args": []map[string]string{{"channel": "tickers", "instId": "BTC-USDT"}},
+	},
+	keepalive:         []byte("ping"),
+	keepaliveInterval: 20 * time.Second,
+	parse: func(message []byte) (Quote, bool, error) {
+		if string(message) == "pong" {
+			return Quote{}, false, nil
+		}
+		var payload struct {
+			Event string `json:"event"`
+			Msg   string `json:"msg"`
+			Data  []struct {
+				Last   string `json:"last"`
+				Vol24h string `json:"vol24h"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(message, &payload); err != nil {
+			return Quote{}, false, err
+		}
+		if payload.Event == "error" {
+			return Quote{}, false, fmt.Errorf("%s", payload.Msg)
+		}
+		if len(payload.Data) == 0 {
+			return Quote{}, false, nil
+		}
+		quote, err := parseQuote(payload.Data[0].Last, payload.Data[0].Vol24h)
+		return quote, err == nil, err
+	},
+}
+
+// streamBackoff returns the delay before reconnecting a stream that failed
+// attempt times in a row.
+func streamBackoff(attempt int) time.Duration {
+	delay := time.Second << uint(min(attempt, 5))
+	return min(delay, maxStreamBackoff)
+}
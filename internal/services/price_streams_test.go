@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestStreamParsers(t *testing.T) {
+	tests := []struct {
+		name    string
+		stream  wsPriceStream
+		message string
+		want    Quote
+		ok      bool
+		err     bool
+	}{
+		{"binance ticker", binanceStream, `{"e":"24hrTicker","c":"65000.10","v":"1234.5"}`, Quote{Price: 65000.10, Volume: 1234.5}, true, false},
+		{"binance other event", binanceStream, `{"e":"trade","p":"65000"}`, Quote{}, false, false},
+		{"coinbase ticker", coinbaseStream, `{"type":"ticker","price":"65001","volume_24h":"9000"}`, Quote{Price: 65001, Volume: 9000}, true, false},
+		{"coinbase subscriptions", coinbaseStream, `{"type":"subscriptions","channels":[]}`, Quote{}, false, false},
+		{"coinbase error", coinbaseStream, `{"type":"error","message":"Failed to subscribe"}`, Quote{}, false, true},
+		{"kraken ticker", krakenStream, `[340,{"c":["65002.5","0.01"],"v":["100","2500"]},"ticker","XBT/USD"]`, Quote{Price: 65002.5, Volume: 2500}, true, false},
+		{"kraken heartbeat", krakenStream, `{"event":"heartbeat"}`, Quote{}, false, false},
+		{"kraken subscription error", krakenStream, `{"event":"subscriptionStatus","status":"error","errorMessage":"Currency pair not supported"}`, Quote{}, false, true},
+		{"bitstamp trade", bitstampStream, `{"event":"trade","data":{"price":65003}}`, Quote{Price: 65003}, true, false},
+		{"bitstamp subscribed", bitstampStream, `{"event":"bts:subscription_succeeded","data":{}}`, Quote{}, false, false},
+		{"bitstamp reconnect", bitstampStream, `{"event":"bts:request_reconnect","data":{}}`, Quote{}, false, true},
+		{"okx ticker", okxStream, `{"arg":{"channel":"tickers"},"data":[{"last":"65004","vol24h":"321"}]}`, Quote{Price: 65004, Volume: 321}, true, false},
+		{"okx pong", okxStream, `pong`, Quote{}, false, false},
+		{"okx subscribed", okxStream, `{"event":"subscribe","arg":{"channel":"tickers"}}`, Quote{}, false, false},
+		{"okx error", okxStream, `{"event":"error","msg":"Invalid request"}`, Quote{}, false, true},
+		{"malformed", binanceStream, `not json`, Quote{}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quote, ok, err := tt.stream.parse([]byte(tt.message))
+			if quote != tt.want || ok != tt.ok || (err != nil) != tt.err {
+				t.Errorf("parse = %+v, %v, %v, want %+v, %v, error %v", quote, ok, err, tt.want, tt.ok, tt.err)
+			}
+		})
+	}
+}
+
+func TestStreamBackoff(t *testing.T) {
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 30 * time.Second, 30 * time.Second}
+	for attempt, delay := range want {
+		if got := streamBackoff(attempt); got != delay {
+			t.Errorf("streamBackoff(%d) = %s, want %s", attempt, got, delay)
+		}
+	}
+	if got := streamBackoff(100); got != maxStreamBackoff {
+		t.Errorf("streamBackoff(100) = %s, want %s", got, maxStreamBackoff)
+	}
+}
+
+// streamServer accepts one websocket, checks the subscription and sends
+// messages, then keeps the connection open until the client goes away or
+// closes it when hangUp is set.
+func streamServer(t *testing.T, messages []string, hangUp bool) (*httptest.Server, <-chan map[string]interface{}) {
+	t.Helper()
+	subscriptions := make(chan map[string]interface{}, 1)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var subscription map[string]interface{}
+		if err := conn.ReadJSON(&subscription); err != nil {
+			return
+		}
+		subscriptions <- subscription
+		for _, message := range messages {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+				return
+			}
+		}
+		if hangUp {
+			return
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, subscriptions
+}
+
+func testStreamingSource(url string) *streamingSource {
+	stream := bitstampStream
+	stream.url = "ws" + strings.TrimPrefix(url, "http")
+	return &streamingSource{PriceSource: namedSource("bitstamp"), stream: stream}
+}
+
+func TestStreamingSourceDeliversQuotes(t *testing.T) {
+	server, subscriptions := streamServer(t, []string{
+		`{"event":"bts:subscription_succeeded","data":{}}`,
+		`{"event":"trade","data":{"price":65000}}`,
+		`{"event":"trade","data":{"price":0}}`,
+		`{"event":"trade","data":{"price":65010}}`,
+	}, true)
+
+	var got []float64
+	err := testStreamingSource(server.URL).Stream(context.Background(), func(quote Quote) {
+		got = append(got, quote.Price)
+	})
+	if err == nil {
+		t.Fatal("Stream returned nil after the server hung up")
+	}
+
+	subscription := <-subscriptions
+	want, _ := json.Marshal(bitstampStream.subscribe)
+	if sent, _ := json.Marshal(subscription); string(sent) != string(want) {
+		t.Errorf("subscription = %s, want %s", sent, want)
+	}
+	if len(got) != 2 || got[0] != 65000 || got[1] != 65010 {
+		t.Errorf("quotes = %v, want [65000 65010]", got)
+	}
+}
+
+func TestStreamingSourceStopsWithContext(t *testing.T) {
+	server, _ := streamServer(t, []string{`{"event":"trade","data":{"price":65000}}`}, false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		errs <- testStreamingSource(server.URL).Stream(ctx, func(Quote) { cancel() })
+	}()
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Stream = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stream did not return after its context was cancelled")
+	}
+}
+
+func TestStreamingSourceFailsOnStreamError(t *testing.T) {
+	server, _ := streamServer(t, []string{`{"event":"bts:request_reconnect","data":{}}`}, false)
+
+	err := testStreamingSource(server.URL).Stream(context.Background(), func(Quote) {})
+	if err == nil || !strings.Contains(err.Error(), "bitstamp stream: reconnect requested") {
+		t.Errorf("Stream = %v, want the reconnect request", err)
+	}
+}
+
+func TestStreamFresh(t *testing.T) {
+	p := &PriceAggregator{quoteMaxAge: time.Second, states: make(map[string]*sourceState)}
+
+	p.recordQuote("polled", Quote{Price: 65000}, nil, false)
+	p.recordQuote("streamed", Quote{Price: 65000}, nil, true)
+	if p.streamFresh("polled") || !p.streamFresh("streamed") || p.streamFresh("unknown") {
+		t.Errorf("streamFresh polled %v streamed %v, want only the streamed source fresh",
+			p.streamFresh("polled"), p.streamFresh("streamed"))
+	}
+
+	p.states["streamed"].updatedAt = time.Now().Add(-2 * time.Second)
+	if p.streamFresh("streamed") {
+		t.Error("a stream quiet for longer than quoteMaxAge is still fresh")
+	}
+
+	p.recordStreamDown("streamed", errors.New("closed"))
+	if p.streamFresh("streamed") {
+		t.Error("a stream that went down is still fresh")
+	}
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"log"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// fetchStatsWindow is how many recent REST fetches the request, success
+	// and latency statistics cover.
+	fetchStatsWindow = 100
+	// deviationWindow is how many deviation samples, taken at most once per
+	// deviationSampleInterval, the deviation statistics cover.
+	deviationWindow         = 300
+	deviationSampleInterval = time.Second
+)
+
+// SourceStats describes a price source's recent health. Request counts,
+// success rate and latencies cover the last fetchStatsWindow REST fetches;
+// deviations from the aggregated price, in percent, cover about the last
+// five minutes the source had a fresh quote.
+type SourceStats struct {
+	Name              string     `json:"name"`
+	Weight            float64    `json:"weight"`
+	Requests          int        `json:"requests"`
+	SuccessRate       float64    `json:"success_rate"`
+	LatencyP50Ms      float64    `json:"latency_p50_ms"`
+	LatencyP90Ms      float64    `json:"latency_p90_ms"`
+	LatencyP99Ms      float64    `json:"latency_p99_ms"`
+	LastError         string     `json:"last_error,omitempty"`
+	LastErrorAt       *time.Time `json:"last_error_at,omitempty"`
+	LastPrice         float64    `json:"last_price"`
+	LastPriceAt       *time.Time `json:"last_price_at,omitempty"`
+	Streaming         bool       `json:"streaming"`
+	StreamQuotes      int64      `json:"stream_quotes"`
+	StreamDisconnects int64      `json:"stream_disconnects"`
+	Deviation         *float64   `json:"deviation_percent,omitempty"`
+	MeanAbsDeviation  float64    `json:"mean_abs_deviation_percent"`
+	MaxAbsDeviation   float64    `json:"max_abs_deviation_percent"`
+}
+
+// sourceStats is the bookkeeping behind SourceStats, kept in sourceState.
+type sourceStats struct {
+	fetches           ring[fetchResult]
+	deviations        ring[float64]
+	deviationAt       time.Time
+	lastError         string
+	lastErrorAt       time.Time
+	streamQuotes      int64
+	streamDisconnects int64
+	failing           bool
+}
+
+type fetchResult struct {
+	ok      bool
+	latency time.Duration
+}
+
+// ring keeps the last size values added.
+type ring[T any] struct {
+	values []T
+	next   int
+}
+
+func (r *ring[T]) add(v T, size int) {
+	if len(r.values) < size {
+		r.values = append(r.values, v)
+		return
+	}
+	r.values[r.next] = v
+	r.next = (r.next + 1) % size
+}
+
+// last returns the most recently added value; the ring must not be empty.
+func (r *ring[T]) last() T {
+	n := len(r.values)
+	return r.values[(r.next+n-1)%n]
+}
+
+// stateLocked returns name's state, creating it. statesMu must be held.
+func (p *PriceAggregator) stateLocked(name string) *sourceState {
+	state, ok := p.states[name]
+	if !ok {
+		state = &sourceState{}
+		p.states[name] = state
+	}
+	return state
+}
+
+// recordFetch adds a REST fetch to name's statistics and logs when the
+// source starts and stops failing.
+func (p *PriceAggregator) recordFetch(name string, latency time.Duration, err error) {
+	p.statesMu.Lock()
+	defer p.statesMu.Unlock()
+
+	stats := &p.stateLocked(name).stats
+	stats.fetches.add(fetchResult{ok: err == nil, latency: latency}, fetchStatsWindow)
+	if err != nil {
+		stats.lastError = err.Error()
+		stats.lastErrorAt = time.Now()
+		if !stats.failing {
+			stats.failing = true
+			log.Printf("Price source %s failing: %v", name, err)
+		}
+		return
+	}
+	if stats.failing {
+		stats.failing = false
+		log.Printf("Price source %s recovered", name)
+	}
+}
+
+// recordDeviations samples each fresh quote's deviation from the published
+// price, outliers included.
+func (p *PriceAggregator) recordDeviations(quotes []sourceQuote, price float64) {
+	p.statesMu.Lock()
+	defer p.statesMu.Unlock()
+
+	now := time.Now()
+	for _, q := range quotes {
+		state, ok := p.states[q.name]
+		if !ok || now.Sub(state.stats.deviationAt) < deviationSampleInterval {
+			continue
+		}
+		state.stats.deviations.add((q.Price-price)/price*100, deviationWindow)
+		state.stats.deviationAt = now
+	}
+}
+
+// SourceStats reports the health of every configured source.
+func (p *PriceAggregator) SourceStats() []SourceStats {
+	p.sourcesMu.RLock()
+	sources := p.sources
+	p.sourcesMu.RUnlock()
+
+	p.statesMu.Lock()
+	defer p.statesMu.Unlock()
+
+	result := make([]SourceStats, 0, len(sources))
+	for _, src := range sources {
+		s := SourceStats{Name: src.source.Name(), Weight: src.weight}
+		if state, ok := p.states[s.Name]; ok {
+			state.fill(&s)
+		}
+		result = append(result, s)
+	}
+	return result
+}
+
+func (state *sourceState) fill(s *SourceStats) {
+	stats := &state.stats
+
+	s.Requests = len(stats.fetches.values)
+	latencies := make([]float64, 0, s.Requests)
+	succeeded := 0
+	for _, f := range stats.fetches.values {
+		if f.ok {
+			succeeded++
+			latencies = append(latencies, float64(f.latency)/float64(time.Millisecond))
+		}
+	}
+	if s.Requests > 0 {
+		s.SuccessRate = float64(succeeded) / float64(s.Requests)
+	}
+	sort.Float64s(latencies)
+	s.LatencyP50Ms = percentile(latencies, 0.5)
+	s.LatencyP90Ms = percentile(latencies, 0.9)
+	s.LatencyP99Ms = percentile(latencies, 0.99)
+
+	if stats.lastError != "" {
+		at := stats.lastErrorAt
+		s.LastError = stats.lastError
+		s.LastErrorAt = &at
+	}
+	if !state.updatedAt.IsZero() {
+		at := state.updatedAt
+		s.LastPrice = state.quote.Price
+		s.LastPriceAt = &at
+	}
+	s.Streaming = state.streaming
+	s.StreamQuotes = stats.streamQuotes
+	s.StreamDisconnects = stats.streamDisconnects
+
+	if n := len(stats.deviations.values); n > 0 {
+		last := stats.deviations.last()
+		s.Deviation = &last
+		sum := 0.0
+		for _, d := range stats.deviations.values {
+			sum += math.Abs(d)
+			s.MaxAbsDeviation = max(s.MaxAbsDeviation, math.Abs(d))
+		}
+		s.MeanAbsDeviation = sum / float64(n)
+	}
+}
+
+// percentile returns the nearest-rank percentile of sorted values, or zero
+// when there are none.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(rank, 0)]
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRing(t *testing.T) {
+	var r ring[int]
+	for i := 1; i <= 5; i++ {
+		r.add(i, 3)
+		if got := r.last(); got != i {
+			t.Fatalf("after add(%d) last = %d", i, got)
+		}
+	}
+	if len(r.values) != 3 {
+		t.Fatalf("ring holds %d values, want 3", len(r.values))
+	}
+	sum := 0
+	for _, v := range r.values {
+		sum += v
+	}
+	if sum != 3+4+5 {
+		t.Errorf("ring values = %v, want the last three added", r.values)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []float64{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}
+	tests := []struct {
+		name   string
+		values []float64
+		p      float64
+		want   float64
+	}{
+		{"empty", nil, 0.5, 0},
+		{"single", []float64{7}, 0.99, 7},
+		{"p50", sorted, 0.5, 50},
+		{"p90", sorted, 0.9, 90},
+		{"p99", sorted, 0.99, 100},
+		{"p0", sorted, 0, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentile(tt.values, tt.p); got != tt.want {
+				t.Errorf("percentile(%v) = %v, want %v", tt.p, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSourceStats(t *testing.T) {
+	p := &PriceAggregator{states: make(map[string]*sourceState)}
+	p.sources = []sourceConfig{
+		{source: namedSource("a"), weight: 2},
+		{source: namedSource("b"), weight: 1},
+	}
+
+	p.recordFetch("a", 10*time.Millisecond, nil)
+	p.recordFetch("a", 30*time.Millisecond, nil)
+	p.recordFetch("a", time.Second, errors.New("timeout"))
+	p.recordFetch("a", 20*time.Millisecond, nil)
+	p.states["a"].quote = Quote{Price: 101}
+	p.states["a"].updatedAt = time.Now()
+	p.recordDeviations([]sourceQuote{{name: "a", Quote: Quote{Price: 101}}, {name: "c", Quote: Quote{Price: 99}}}, 100)
+	// A second sample within deviationSampleInterval is skipped.
+	p.recordDeviations([]sourceQuote{{name: "a", Quote: Quote{Price: 105}}}, 100)
+
+	stats := p.SourceStats()
+	if len(stats) != 2 {
+		t.Fatalf("got stats for %d sources, want 2", len(stats))
+	}
+
+	a := stats[0]
+	if a.Name != "a" || a.Weight != 2 {
+		t.Errorf("stats[0] = %s weight %v, want a weight 2", a.Name, a.Weight)
+	}
+	if a.Requests != 4 || a.SuccessRate != 0.75 {
+		t.Errorf("requests = %d success rate = %v, want 4 and 0.75", a.Requests, a.SuccessRate)
+	}
+	// Latencies cover successful fetches only.
+	if a.LatencyP50Ms != 20 || a.LatencyP99Ms != 30 {
+		t.Errorf("latency p50 = %v p99 = %v, want 20 and 30", a.LatencyP50Ms, a.LatencyP99Ms)
+	}
+	if a.LastError != "timeout" || a.LastErrorAt == nil {
+		t.Errorf("last error = %q at %v, want timeout", a.LastError, a.LastErrorAt)
+	}
+	if a.LastPrice != 101 || a.LastPriceAt == nil {
+		t.Errorf("last price = %v at %v, want 101", a.LastPrice, a.LastPriceAt)
+	}
+	if a.Deviation == nil || math.Abs(*a.Deviation-1) > 1e-9 || math.Abs(a.MaxAbsDeviation-1) > 1e-9 {
+		t.Errorf("deviation = %v max = %v, want 1%%", a.Deviation, a.MaxAbsDeviation)
+	}
+
+	b := stats[1]
+	if b.Name != "b" || b.Requests != 0 || b.SuccessRate != 0 || b.Deviation != nil || b.LastPriceAt != nil {
+		t.Errorf("stats for an unseen source = %+v, want empty", b)
+	}
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"math"
+	"time"
+
+	"btc-trading-bot/internal/models"
+	"btc-trading-bot/pkg/lnmarkets"
+	"btc-trading-bot/pkg/pricing"
+)
+
+// builtinStrategies are the bot's original automations, run in this order
+// ahead of any strategy instance. Each is configured through its own table
+// and endpoint, re-read on every price.
+func builtinStrategies() []*botStrategy {
+	return []*botStrategy{
+		{name: "margin_protection", Strategy: &marginProtectionStrategy{}},
+		{name: "take_profit", Strategy: &takeProfitStrategy{}},
+		{name: "entry_automation", Strategy: entryAutomationStrategy{}},
+		{name: "price_alert", Strategy: priceAlertStrategy{}},
+	}
+}
+
+// loadConfig reads the user's row of a built-in strategy's table. It reports
+// false, without error, when the user has not configured the strategy.
+func loadConfig(env *StrategyEnv, dest interface{}, table string) (bool, error) {
+	err := env.DB.Get(dest, "SELECT * FROM "+table+" WHERE user_id = $1", env.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+type entryAutomationStrategy struct{ BaseStrategy }
+
+func (entryAutomationStrategy) OnPrice(ctx context.Context, env *StrategyEnv, currentPrice float64) error {
+	var config models.EntryAutomation
+	if ok, err := loadConfig(env, &config, "entry_automation"); !ok || !config.IsEnabled {
+		return err
+	}
+
+	if config.FilledSlots >= config.NumberOfOrders {
+		return nil
+	}
+
+	targetPrice := config.InitialPrice + float64(config.FilledSlots)*config.PriceVariation
+	if math.Abs(currentPrice-targetPrice) > config.PriceVariation/2 {
+		return nil
+	}
+
+	if reason := env.EntryBlocked(); reason != "" {
+		log.Printf("Entry automation for user %d paused: %s", env.UserID, reason)
+		return nil
+	}
+	if env.Exchange == nil {
+		return nil
+	}
+
+	trade := &lnmarkets.TradeRequest{
+		Type:     lnmarkets.OrderTypeMarket,
+		Side:     tradeSide(config.OperationType),
+		Quantity: config.AmountPerOrder,
+		Leverage: config.Leverage,
+	}
+	order, err := env.OpenTrade(ctx, trade, pricing.TargetPrice(trade.Side, currentPrice, config.TakeProfitPerOrder))
+	if err != nil {
+		if ctx.Err() != nil {
+			log.Printf("Trade for user %d aborted: bot stopped", env.UserID)
+			return nil
+		}
+		return err
+	}
+
+	_, err = env.DB.Exec("UPDATE entry_automation SET filled_slots = filled_slots + 1 WHERE user_id = $1",
+		env.UserID)
+	if err != nil {
+		log.Printf("Error updating filled slots: %v", err)
+	}
+
+	log.Printf("Created new order: %s at price $%.2f", order.OrderID, currentPrice)
+	return nil
+}
+
+// tradeSide maps an entry automation operation type to an LN Markets side.
+func tradeSide(operationType string) string {
+	switch operationType {
+	case "sell", "short", lnmarkets.SideSell:
+		return lnmarkets.SideSell
+	}
+	return lnmarkets.SideBuy
+}
+
+type priceAlertStrategy struct{ BaseStrategy }
+
+// RunsOnFallback lets price alerts fire on aggregator prices; they place no
+// orders.
+func (priceAlertStrategy) RunsOnFallback() bool { return true }
+
+func (priceAlertStrategy) OnPrice(ctx context.Context, env *StrategyEnv, currentPrice float64) error {
+	var config models.PriceAlert
+	if ok, err := loadConfig(env, &config, "price_alert"); !ok || !config.IsEnabled {
+		return err
+	}
+
+	// Bot prices are in USD; the range is in the alert's currency.
+	currency := config.Currency
+	if currency == "" {
+		currency = "USD"
+	}
+	price, err := env.service.priceAggregator.FX().Convert(currentPrice, currency)
+	if err != nil {
+		return err
+	}
+
+	if price < config.MinPrice || price > config.MaxPrice {
+		if time.Since(config.LastAlert) >= time.Duration(config.CheckInterval)*time.Second {
+			log.Printf("PRICE ALERT: Bitcoin price %.2f %s is outside range %.2f - %.2f %s",
+				price, currency, config.MinPrice, config.MaxPrice, currency)
+
+			_, err := env.DB.Exec("UPDATE price_alert SET last_alert = $1 WHERE user_id = $2",
+				time.Now(), env.UserID)
+			if err != nil {
+				log.Printf("Error updating last alert: %v", err)
+			}
+		}
+	}
+	return nil
+}
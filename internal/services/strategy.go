@@ -0,0 +1,336 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"btc-trading-bot/internal/database"
+	"btc-trading-bot/internal/models"
+	"btc-trading-bot/pkg/lnmarkets"
+)
+
+// Strategy reacts to a running bot's prices and fills. A bot calls its
+// strategies one hook at a time from a single goroutine, in order: the
+// built-in strategies first, then the user's strategy instances by position.
+// A hook's error is logged and does not stop the other strategies.
+type Strategy interface {
+	// OnStart is called when the bot starts or its strategies are
+	// reloaded. A strategy whose OnStart fails is not run.
+	OnStart(ctx context.Context, env *StrategyEnv) error
+	OnPrice(ctx context.Context, env *StrategyEnv, price float64) error
+	// OnFill is called for every change to one of the bot's trades, such
+	// as fills, closes and liquidations, and for trades placed with
+	// StrategyEnv.OpenTrade.
+	OnFill(ctx context.Context, env *StrategyEnv, fill Fill) error
+	// OnStop is called when the bot stops or its strategies are reloaded.
+	OnStop(ctx context.Context, env *StrategyEnv) error
+}
+
+// FallbackStrategy is implemented by strategies that may act on prices from
+// the aggregator while it stands in for a stale LN Markets feed. Other
+// strategies get no OnPrice calls until LN Markets prices return.
+type FallbackStrategy interface {
+	Strategy
+	RunsOnFallback() bool
+}
+
+func runsOnFallback(strategy Strategy) bool {
+	f, ok := strategy.(FallbackStrategy)
+	return ok && f.RunsOnFallback()
+}
+
+// Fill is a change to one of the bot's trades. Event is the exchange event,
+// or FillEventCreated for trades placed by a strategy.
+type Fill struct {
+	Event string
+	Trade lnmarkets.TradeResponse
+}
+
+const FillEventCreated = "created"
+
+// BaseStrategy implements every hook as a no-op; embed it to implement only
+// the hooks a strategy needs.
+type BaseStrategy struct{}
+
+func (BaseStrategy) OnStart(ctx context.Context, env *StrategyEnv) error { return nil }
+func (BaseStrategy) OnPrice(ctx context.Context, env *StrategyEnv, price float64) error {
+	return nil
+}
+func (BaseStrategy) OnFill(ctx context.Context, env *StrategyEnv, fill Fill) error { return nil }
+func (BaseStrategy) OnStop(ctx context.Context, env *StrategyEnv) error            { return nil }
+
+// StrategyFactory builds a strategy from the JSON parameters of a strategy
+// instance, rejecting invalid ones.
+type StrategyFactory func(params json.RawMessage) (Strategy, error)
+
+var ErrUnknownStrategy = errors.New("unknown strategy")
+
+var (
+	strategiesMu sync.RWMutex
+	strategies   = make(map[string]StrategyFactory)
+	// singleStrategies keep state per user rather than per instance, so a
+	// user may have only one instance of them.
+	singleStrategies = make(map[string]bool)
+)
+
+// RegisterStrategy makes a strategy available to strategy instances under
+// name.
+func RegisterStrategy(name string, factory StrategyFactory) {
+	strategiesMu.Lock()
+	defer strategiesMu.Unlock()
+	strategies[name] = factory
+}
+
+// RegisterSingleStrategy registers a strategy a user may have only one
+// instance of.
+func RegisterSingleStrategy(name string, factory StrategyFactory) {
+	RegisterStrategy(name, factory)
+	strategiesMu.Lock()
+	defer strategiesMu.Unlock()
+	singleStrategies[name] = true
+}
+
+// IsSingleStrategy reports whether a user may have only one instance of the
+// strategy registered under name.
+func IsSingleStrategy(name string) bool {
+	strategiesMu.RLock()
+	defer strategiesMu.RUnlock()
+	return singleStrategies[name]
+}
+
+// StrategyNames lists the registered strategies.
+func StrategyNames() []string {
+	strategiesMu.RLock()
+	defer strategiesMu.RUnlock()
+
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewStrategy builds the strategy registered under name with params. It is
+// also how parameters are validated before they are stored.
+func NewStrategy(name string, params json.RawMessage) (Strategy, error) {
+	strategiesMu.RLock()
+	factory, ok := strategies[name]
+	strategiesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownStrategy, name)
+	}
+	if len(params) == 0 {
+		params = json.RawMessage("{}")
+	}
+	return factory(params)
+}
+
+// StrategyEnv is the bot a strategy runs in.
+type StrategyEnv struct {
+	UserID   int
+	Mode     string
+	Exchange lnmarkets.Exchange
+	DB       *database.Database
+
+	service *TradingService
+	bot     *BotInstance
+}
+
+// EntryBlocked returns why no new position should be opened right now, or
+// an empty string when entries are allowed.
+func (e *StrategyEnv) EntryBlocked() string {
+	// Never enter on a price the feed may have moved away from, nor on an
+	// index that disagrees with the spot market.
+	if !e.bot.feedFresh() {
+		return "price feed is stale"
+	}
+	if e.bot.onFallback() {
+		return "price feed is on the aggregator fallback"
+	}
+	if e.service.divergence.BlocksEntries(e.bot.network) {
+		return "abnormal index divergence"
+	}
+	return ""
+}
+
+// OpenOrders returns the bot's open and running orders.
+func (e *StrategyEnv) OpenOrders() ([]models.TradingOrder, error) {
+	var orders []models.TradingOrder
+	err := e.DB.Select(&orders, "SELECT * FROM trading_orders WHERE user_id = $1 AND mode = $2 AND status IN ('open', 'running')",
+		e.UserID, e.Mode)
+	return orders, err
+}
+
+// OpenTrade places trade and records it in the bot's orders with
+// takeProfitPrice. Strategies see it through OnFill once the current hook
+// returns. It must only be called from a hook.
+func (e *StrategyEnv) OpenTrade(ctx context.Context, trade *lnmarkets.TradeRequest, takeProfitPrice float64) (*models.TradingOrder, error) {
+	tradeResp, err := e.Exchange.CreateTradeContext(ctx, trade)
+	if err != nil {
+		return nil, err
+	}
+
+	orderType := "buy"
+	if trade.Side == lnmarkets.SideSell {
+		orderType = "sell"
+	}
+	order := &models.TradingOrder{
+		UserID:          e.UserID,
+		OrderID:         tradeResp.ID,
+		Type:            orderType,
+		Amount:          tradeResp.Quantity,
+		Price:           tradeResp.Price,
+		Leverage:        tradeResp.Leverage,
+		Status:          tradeResp.Status(),
+		Mode:            e.Mode,
+		TakeProfitPrice: takeProfitPrice,
+		CreatedAt:       time.Now(),
+		UpdatedAt:       time.Now(),
+	}
+
+	_, err = e.DB.NamedExec(`
+		INSERT INTO trading_orders (user_id, order_id, type, amount, price, leverage, status, mode, take_profit_price, created_at, updated_at)
+		VALUES (:user_id, :order_id, :type, :amount, :price, :leverage, :status, :mode, :take_profit_price, :created_at, :updated_at)
+	`, order)
+	if err != nil {
+		return nil, fmt.Errorf("trade %s placed but not saved: %v", tradeResp.ID, err)
+	}
+
+	e.bot.pendingFills = append(e.bot.pendingFills, Fill{Event: FillEventCreated, Trade: *tradeResp})
+	return order, nil
+}
+
+// botStrategy is a strategy running in a bot. Built-in strategies have no
+// instance.
+type botStrategy struct {
+	name       string
+	instanceID int
+	Strategy
+}
+
+func (b *botStrategy) String() string {
+	if b.instanceID == 0 {
+		return b.name
+	}
+	return fmt.Sprintf("%s #%d", b.name, b.instanceID)
+}
+
+// loadStrategies builds the bot's strategies: the built-ins, then the user's
+// enabled strategy instances. Instances that cannot be built, and further
+// instances of a single-instance strategy, are skipped.
+func (s *TradingService) loadStrategies(bot *BotInstance) []*botStrategy {
+	loaded := builtinStrategies()
+	seen := make(map[string]bool)
+
+	var instances []models.StrategyInstance
+	err := s.db.Select(&instances, "SELECT * FROM strategy_instances WHERE user_id = $1 AND is_enabled = true ORDER BY position, id",
+		bot.UserID)
+	if err != nil {
+		log.Printf("Error loading strategies for user %d: %v", bot.UserID, err)
+		return loaded
+	}
+
+	for _, instance := range instances {
+		if seen[instance.Strategy] && IsSingleStrategy(instance.Strategy) {
+			log.Printf("Skipping strategy %s #%d for user %d: only one instance may run", instance.Strategy, instance.ID, bot.UserID)
+			continue
+		}
+		seen[instance.Strategy] = true
+
+		strategy, err := NewStrategy(instance.Strategy, instance.Params)
+		if err != nil {
+			log.Printf("Skipping strategy %s #%d for user %d: %v", instance.Strategy, instance.ID, bot.UserID, err)
+			continue
+		}
+		loaded = append(loaded, &botStrategy{name: instance.Strategy, instanceID: instance.ID, Strategy: strategy})
+	}
+	return loaded
+}
+
+func (s *TradingService) startStrategies(bot *BotInstance) {
+	var started []*botStrategy
+	for _, strategy := range s.loadStrategies(bot) {
+		if err := strategy.OnStart(bot.ctx, bot.env); err != nil {
+			log.Printf("Strategy %s for user %d failed to start: %v", strategy, bot.UserID, err)
+			continue
+		}
+		started = append(started, strategy)
+	}
+	bot.strategies = started
+}
+
+// stopStrategies runs OnStop even when the bot's context is already
+// cancelled, so strategies can persist their state.
+func (s *TradingService) stopStrategies(bot *BotInstance) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(bot.ctx), 10*time.Second)
+	defer cancel()
+
+	for _, strategy := range bot.strategies {
+		if err := strategy.OnStop(ctx, bot.env); err != nil {
+			log.Printf("Strategy %s for user %d failed to stop: %v", strategy, bot.UserID, err)
+		}
+	}
+	bot.strategies = nil
+}
+
+// runStrategies calls hook on every strategy in order, then delivers the
+// fills of trades the strategies placed meanwhile.
+func (bot *BotInstance) runStrategies(event string, hook func(Strategy) error) {
+	for _, strategy := range bot.strategies {
+		if bot.ctx.Err() != nil {
+			return
+		}
+		if err := hook(strategy.Strategy); err != nil {
+			log.Printf("Strategy %s for user %d failed on %s: %v", strategy, bot.UserID, event, err)
+		}
+	}
+
+	for len(bot.pendingFills) > 0 && bot.ctx.Err() == nil {
+		fill := bot.pendingFills[0]
+		bot.pendingFills = bot.pendingFills[1:]
+		bot.runFill(fill)
+	}
+}
+
+func (bot *BotInstance) runFill(fill Fill) {
+	for _, strategy := range bot.strategies {
+		if bot.ctx.Err() != nil {
+			return
+		}
+		if err := strategy.OnFill(bot.ctx, bot.env, fill); err != nil {
+			log.Printf("Strategy %s for user %d failed on fill of %s: %v", strategy, bot.UserID, fill.Trade.ID, err)
+		}
+	}
+}
+
+// queueFill hands a trade change from the exchange to the bot's strategy
+// loop.
+func (bot *BotInstance) queueFill(fill Fill) {
+	select {
+	case bot.fills <- fill:
+	case <-bot.ctx.Done():
+	}
+}
+
+// ReloadStrategies makes a running bot pick up changes to its user's
+// strategy instances.
+func (s *TradingService) ReloadStrategies(userID int) {
+	s.botMutex.RLock()
+	bot, exists := s.runningBots[userID]
+	s.botMutex.RUnlock()
+	if !exists {
+		return
+	}
+
+	select {
+	case bot.reloadStrategies <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"btc-trading-bot/pkg/lnmarkets"
+	"btc-trading-bot/pkg/simulator"
+)
+
+// recordingStrategy records the hooks it receives in a shared log.
+type recordingStrategy struct {
+	BaseStrategy
+	name     string
+	log      *[]string
+	fallback bool
+	err      error
+}
+
+func (r *recordingStrategy) OnPrice(ctx context.Context, env *StrategyEnv, price float64) error {
+	*r.log = append(*r.log, r.name+":price")
+	return r.err
+}
+
+func (r *recordingStrategy) OnFill(ctx context.Context, env *StrategyEnv, fill Fill) error {
+	*r.log = append(*r.log, r.name+":fill:"+fill.Trade.ID)
+	return nil
+}
+
+func (r *recordingStrategy) RunsOnFallback() bool { return r.fallback }
+
+// newTestBot returns a bot with a fresh feed running strategies on a
+// simulated exchange at $50,000, without a database.
+func newTestBot(t *testing.T, strategies ...Strategy) *BotInstance {
+	t.Helper()
+	exchange := simulator.NewExchange(simulator.Config{InitialBalance: 1_000_000, FeeRate: 0.001, MaxLeverage: 100})
+	exchange.UpdatePrice(50000)
+	bot := &BotInstance{
+		UserID:     1,
+		Mode:       TradingModePaper,
+		Exchange:   exchange,
+		LastUpdate: time.Now(),
+		staleAfter: time.Minute,
+	}
+	bot.ctx, bot.cancel = context.WithCancel(context.Background())
+	t.Cleanup(bot.cancel)
+	bot.env = &StrategyEnv{UserID: bot.UserID, Mode: bot.Mode, Exchange: exchange, bot: bot}
+	for i, strategy := range strategies {
+		bot.strategies = append(bot.strategies, &botStrategy{name: "test", instanceID: i + 1, Strategy: strategy})
+	}
+	return bot
+}
+
+// openTestPosition opens a 1000 USD position at 10x on side on a fresh
+// simulated exchange at $50,000.
+func openTestPosition(t *testing.T, side string) (*simulator.Exchange, *lnmarkets.TradeResponse) {
+	t.Helper()
+	exchange := simulator.NewExchange(simulator.Config{InitialBalance: 10_000_000, FeeRate: 0.001, MaxLeverage: 100})
+	exchange.UpdatePrice(50_000)
+	trade, err := exchange.CreateTradeContext(context.Background(), &lnmarkets.TradeRequest{
+		Type: lnmarkets.OrderTypeMarket, Side: side, Quantity: 1000, Leverage: 10,
+	})
+	if err != nil {
+		t.Fatalf("CreateTrade: %v", err)
+	}
+	return exchange, trade
+}
+
+func TestFallbackPricesOnlyReachFallbackStrategies(t *testing.T) {
+	var log []string
+	bot := newTestBot(t,
+		&recordingStrategy{name: "entry", log: &log},
+		&recordingStrategy{name: "protect", log: &log, fallback: true},
+	)
+	s := &TradingService{}
+
+	s.handlePriceUpdate(bot.UserID, 50000, bot)
+	bot.stopFallback = func() {}
+	s.handlePriceUpdate(bot.UserID, 50100, bot)
+
+	want := []string{"entry:price", "protect:price", "protect:price"}
+	if !reflect.DeepEqual(log, want) {
+		t.Fatalf("hooks = %v, want %v", log, want)
+	}
+}
+
+// tradingStrategy opens a market long on the first price and queues its
+// fill the way StrategyEnv.OpenTrade does, without recording the order.
+type tradingStrategy struct {
+	recordingStrategy
+	traded bool
+}
+
+func (s *tradingStrategy) OnPrice(ctx context.Context, env *StrategyEnv, price float64) error {
+	s.recordingStrategy.OnPrice(ctx, env, price)
+	if s.traded {
+		return nil
+	}
+	s.traded = true
+	trade, err := env.Exchange.CreateTradeContext(ctx, &lnmarkets.TradeRequest{
+		Type: lnmarkets.OrderTypeMarket, Side: lnmarkets.SideBuy, Quantity: 100, Leverage: 10,
+	})
+	if err != nil {
+		return err
+	}
+	env.bot.pendingFills = append(env.bot.pendingFills, Fill{Event: FillEventCreated, Trade: *trade})
+	return nil
+}
+
+func TestRunStrategiesInOrder(t *testing.T) {
+	var log []string
+	bot := newTestBot(t,
+		&recordingStrategy{name: "first", log: &log},
+		&recordingStrategy{name: "failing", log: &log, err: errors.New("boom")},
+		&recordingStrategy{name: "last", log: &log},
+	)
+
+	bot.runStrategies("price", func(strategy Strategy) error {
+		return strategy.OnPrice(bot.ctx, bot.env, 50000)
+	})
+
+	want := []string{"first:price", "failing:price", "last:price"}
+	if !reflect.DeepEqual(log, want) {
+		t.Fatalf("hooks = %v, want %v", log, want)
+	}
+}
+
+func TestFillsFromHooksFollowTheHook(t *testing.T) {
+	var log []string
+	trader := &tradingStrategy{recordingStrategy: recordingStrategy{name: "trader", log: &log}}
+	bot := newTestBot(t, trader, &recordingStrategy{name: "watcher", log: &log})
+
+	for i := 0; i < 2; i++ {
+		bot.runStrategies("price", func(strategy Strategy) error {
+			return strategy.OnPrice(bot.ctx, bot.env, 50000)
+		})
+	}
+
+	positions, err := bot.Exchange.GetPositionsContext(context.Background(), "running")
+	if err != nil || len(positions) != 1 {
+		t.Fatalf("running positions = %v, %v, want one", positions, err)
+	}
+	id := positions[0].ID
+	want := []string{
+		"trader:price", "watcher:price",
+		"trader:fill:" + id, "watcher:fill:" + id,
+		"trader:price", "watcher:price",
+	}
+	if !reflect.DeepEqual(log, want) {
+		t.Fatalf("hooks = %v, want %v", log, want)
+	}
+	if len(bot.pendingFills) != 0 {
+		t.Errorf("pending fills = %v, want none", bot.pendingFills)
+	}
+}
+
+func TestStoppedBotRunsNoHooks(t *testing.T) {
+	var log []string
+	bot := newTestBot(t, &tradingStrategy{recordingStrategy: recordingStrategy{name: "trader", log: &log}})
+	bot.cancel()
+
+	bot.runStrategies("price", func(strategy Strategy) error {
+		return strategy.OnPrice(bot.ctx, bot.env, 50000)
+	})
+	if len(log) != 0 {
+		t.Fatalf("hooks = %v, want none", log)
+	}
+}
+
+func TestNewStrategy(t *testing.T) {
+	var got json.RawMessage
+	RegisterStrategy("test_params", func(params json.RawMessage) (Strategy, error) {
+		got = params
+		return BaseStrategy{}, nil
+	})
+	t.Cleanup(func() {
+		strategiesMu.Lock()
+		defer strategiesMu.Unlock()
+		delete(strategies, "test_params")
+	})
+
+	if _, err := NewStrategy("test_params", nil); err != nil || string(got) != "{}" {
+		t.Errorf("NewStrategy without params = %v, factory got %s, want {}", err, got)
+	}
+	if _, err := NewStrategy("no_such_strategy", nil); !errors.Is(err, ErrUnknownStrategy) {
+		t.Errorf("NewStrategy(unknown) = %v, want ErrUnknownStrategy", err)
+	}
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"btc-trading-bot/internal/models"
+	"btc-trading-bot/pkg/lnmarkets"
+	"btc-trading-bot/pkg/pricing"
+)
+
+// Outcomes of a take profit update, recorded in take_profit_updates.
+const (
+	TakeProfitUpdated = "updated"
+	TakeProfitFailed  = "failed"
+)
+
+// takeProfitRetryDelay is how long a failed take profit update waits before
+// it is retried.
+const takeProfitRetryDelay = time.Minute
+
+// takeProfitTolerance is how far, in USD, a position's take profit may be
+// from its target and still count as set.
+const takeProfitTolerance = 0.5
+
+// takeProfitStrategy sets the take profit of every running position on the
+// exchange, including ones opened outside the bot, to daily_percentage from
+// its entry price in the position's favour: above it for longs, below it for
+// shorts. Positions already past that target get it from the current price.
+// All positions are updated once a day; new positions as they fill. Failed
+// updates are retried until they succeed or the position closes.
+type takeProfitStrategy struct {
+	BaseStrategy
+	// pending holds the positions to update and when to try next.
+	pending map[string]time.Time
+}
+
+func (t *takeProfitStrategy) OnFill(ctx context.Context, env *StrategyEnv, fill Fill) error {
+	if t.pending == nil {
+		t.pending = make(map[string]time.Time)
+	}
+	if fill.Trade.Running {
+		if _, ok := t.pending[fill.Trade.ID]; !ok {
+			t.pending[fill.Trade.ID] = time.Now()
+		}
+	} else if fill.Trade.Closed || fill.Trade.Canceled {
+		delete(t.pending, fill.Trade.ID)
+	}
+	return nil
+}
+
+func (t *takeProfitStrategy) OnPrice(ctx context.Context, env *StrategyEnv, currentPrice float64) error {
+	var config models.TakeProfit
+	if ok, err := loadConfig(env, &config, "take_profit"); !ok || !config.IsEnabled {
+		return err
+	}
+	if env.Exchange == nil {
+		return nil
+	}
+
+	daily := time.Since(config.LastUpdate) >= 24*time.Hour
+	if !daily && !t.retryDue() {
+		return nil
+	}
+
+	positions, err := env.Exchange.GetPositionsContext(ctx, "running")
+	if err != nil {
+		return fmt.Errorf("getting running positions: %v", err)
+	}
+
+	pending := make(map[string]time.Time)
+	for _, position := range positions {
+		next, ok := t.pending[position.ID]
+		if !daily && (!ok || time.Now().Before(next)) {
+			if ok {
+				pending[position.ID] = next
+			}
+			continue
+		}
+		if err := t.update(ctx, env, config, position, currentPrice); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			pending[position.ID] = time.Now().Add(takeProfitRetryDelay)
+		}
+	}
+	// Positions no longer running need no take profit.
+	t.pending = pending
+
+	if !daily {
+		return nil
+	}
+	_, err = env.DB.Exec("UPDATE take_profit SET last_update = $1 WHERE user_id = $2",
+		time.Now(), env.UserID)
+	return err
+}
+
+func (t *takeProfitStrategy) retryDue() bool {
+	now := time.Now()
+	for _, next := range t.pending {
+		if !now.Before(next) {
+			return true
+		}
+	}
+	return false
+}
+
+// update sets position's take profit on the exchange and in the bot's orders.
+func (t *takeProfitStrategy) update(ctx context.Context, env *StrategyEnv, config models.TakeProfit, position lnmarkets.TradeResponse, currentPrice float64) error {
+	target := takeProfitTarget(position, config.DailyPercentage, currentPrice)
+	if math.Abs(position.TakeProfit-target) < takeProfitTolerance {
+		return nil
+	}
+
+	update := &models.TakeProfitUpdate{
+		UserID:             env.UserID,
+		Mode:               env.Mode,
+		PositionID:         position.ID,
+		Side:               position.Side,
+		Price:              currentPrice,
+		PreviousTakeProfit: position.TakeProfit,
+		TakeProfit:         target,
+	}
+
+	err := env.Exchange.UpdateTakeProfitContext(ctx, position.ID, target)
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		update.Status = TakeProfitFailed
+		update.Detail = err.Error()
+		log.Printf("Take profit update for position %s failed, retrying in %s: %v", position.ID, takeProfitRetryDelay, err)
+		recordTakeProfitUpdate(env, update)
+		return err
+	}
+
+	update.Status = TakeProfitUpdated
+	log.Printf("Take profit of position %s set to $%.2f (was $%.2f)", position.ID, target, position.TakeProfit)
+	recordTakeProfitUpdate(env, update)
+
+	_, err = env.DB.Exec("UPDATE trading_orders SET take_profit_price = $1, updated_at = $2 WHERE user_id = $3 AND order_id = $4 AND mode = $5",
+		target, time.Now(), env.UserID, position.ID, env.Mode)
+	if err != nil {
+		log.Printf("Error updating order %s take profit: %v", position.ID, err)
+	}
+	return nil
+}
+
+// takeProfitTarget is percent from position's entry price in its favour, or
+// from currentPrice once the price has passed that, rounded to the exchange's
+// price step.
+func takeProfitTarget(position lnmarkets.TradeResponse, percent, currentPrice float64) float64 {
+	entry := position.EntryPrice
+	if entry <= 0 {
+		entry = position.Price
+	}
+	target := pricing.TargetPrice(position.Side, entry, percent)
+	// The exchange rejects a take profit the price has already passed.
+	if profitPassed(position.Side, target, currentPrice) {
+		target = pricing.TargetPrice(position.Side, currentPrice, percent)
+	}
+	// LN Markets prices move in steps of $0.5.
+	return math.Round(target*2) / 2
+}
+
+// profitPassed reports whether price is already at or beyond target in the
+// favour of a position on side.
+func profitPassed(side string, target, price float64) bool {
+	if pricing.IsLong(side) {
+		return price >= target
+	}
+	return price <= target
+}
+
+func recordTakeProfitUpdate(env *StrategyEnv, update *models.TakeProfitUpdate) {
+	update.CreatedAt = time.Now()
+	_, err := env.DB.NamedExec(`
+		INSERT INTO take_profit_updates (user_id, mode, position_id, side, price, previous_take_profit, take_profit, status, detail, created_at)
+		VALUES (:user_id, :mode, :position_id, :side, :price, :previous_take_profit, :take_profit, :status, :detail, :created_at)
+	`, update)
+	if err != nil {
+		log.Printf("Error recording take profit update for position %s: %v", update.PositionID, err)
+	}
+}
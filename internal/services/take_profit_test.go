@@ -0,0 +1,101 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"btc-trading-bot/pkg/lnmarkets"
+)
+
+func TestTakeProfitTarget(t *testing.T) {
+	tests := []struct {
+		name     string
+		position lnmarkets.TradeResponse
+		price    float64
+		want     float64
+	}{
+		{"long from entry", lnmarkets.TradeResponse{Side: lnmarkets.SideBuy, EntryPrice: 50_000}, 50_500, 51_000},
+		{"long below entry", lnmarkets.TradeResponse{Side: lnmarkets.SideBuy, EntryPrice: 50_000}, 49_000, 51_000},
+		{"long past target", lnmarkets.TradeResponse{Side: lnmarkets.SideBuy, EntryPrice: 50_000}, 52_000, 53_040},
+		{"long at target", lnmarkets.TradeResponse{Side: lnmarkets.SideBuy, EntryPrice: 50_000}, 51_000, 52_020},
+		{"short from entry", lnmarkets.TradeResponse{Side: lnmarkets.SideSell, EntryPrice: 50_000}, 49_500, 49_000},
+		{"short above entry", lnmarkets.TradeResponse{Side: lnmarkets.SideSell, EntryPrice: 50_000}, 51_000, 49_000},
+		{"short past target", lnmarkets.TradeResponse{Side: lnmarkets.SideSell, EntryPrice: 50_000}, 48_000, 47_040},
+		{"order price without entry", lnmarkets.TradeResponse{Side: lnmarkets.SideBuy, Price: 40_000}, 40_000, 40_800},
+		{"rounded to half dollar", lnmarkets.TradeResponse{Side: lnmarkets.SideBuy, EntryPrice: 50_123}, 50_123, 51_125.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := takeProfitTarget(tt.position, 2, tt.price); got != tt.want {
+				t.Errorf("takeProfitTarget = %.2f, want %.2f", got, tt.want)
+			}
+		})
+	}
+}
+
+// The target set on the simulator closes a long above and a short below the
+// entry, at a profit.
+func TestTakeProfitTargetClosesInProfit(t *testing.T) {
+	tests := []struct {
+		name  string
+		side  string
+		moves []float64
+	}{
+		{"long", lnmarkets.SideBuy, []float64{49_000, 50_900, 51_100}},
+		{"short", lnmarkets.SideSell, []float64{51_000, 49_100, 48_900}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			exchange, trade := openTestPosition(t, tt.side)
+
+			target := takeProfitTarget(*trade, 2, 50_000)
+			if err := exchange.UpdateTakeProfitContext(ctx, trade.ID, target); err != nil {
+				t.Fatalf("UpdateTakeProfit(%.2f): %v", target, err)
+			}
+
+			last := len(tt.moves) - 1
+			for i, price := range tt.moves {
+				exchange.UpdatePrice(price)
+				position, err := exchange.GetPositionContext(ctx, trade.ID)
+				if err != nil {
+					t.Fatalf("GetPosition: %v", err)
+				}
+				if position.Closed != (i == last) {
+					t.Fatalf("closed at %.0f = %v, want %v", price, position.Closed, i == last)
+				}
+				if position.Closed && position.PL <= 0 {
+					t.Errorf("PL at take profit %.2f = %.0f, want a profit", target, position.PL)
+				}
+			}
+		})
+	}
+}
+
+func TestTakeProfitTracksFills(t *testing.T) {
+	strategy := &takeProfitStrategy{}
+	ctx := context.Background()
+	fill := func(trade lnmarkets.TradeResponse) {
+		strategy.OnFill(ctx, nil, Fill{Trade: trade})
+	}
+
+	fill(lnmarkets.TradeResponse{ID: "running", Running: true})
+	fill(lnmarkets.TradeResponse{ID: "open"})
+	fill(lnmarkets.TradeResponse{ID: "closed", Running: true})
+	fill(lnmarkets.TradeResponse{ID: "closed", Closed: true})
+
+	if len(strategy.pending) != 1 {
+		t.Fatalf("pending = %v, want only the running position", strategy.pending)
+	}
+	if !strategy.retryDue() {
+		t.Error("retryDue = false for a new fill, want true")
+	}
+
+	// A fill does not bring forward a retry that is waiting.
+	strategy.pending["running"] = time.Now().Add(takeProfitRetryDelay)
+	fill(lnmarkets.TradeResponse{ID: "running", Running: true})
+	if strategy.retryDue() {
+		t.Error("retryDue = true while the retry waits, want false")
+	}
+}
@@ -1,60 +1,119 @@
 package services
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"log"
-	"math"
 	"sync"
 	"time"
 
 	"btc-trading-bot/internal/database"
 	"btc-trading-bot/internal/models"
 	"btc-trading-bot/pkg/lnmarkets"
+	"btc-trading-bot/pkg/simulator"
 	"btc-trading-bot/pkg/websocket"
 )
 
 type TradingService struct {
-	db           *database.Database
-	lnClient     *lnmarkets.Client
-	wsClient     *websocket.Client
-	priceUpdates chan float64
-	stopChan     chan struct{}
+	db              *database.Database
+	priceAggregator *PriceAggregator
+	divergence      *DivergenceMonitor
+	exchange        lnmarkets.Exchange
+	wsClient        *websocket.Client
+	priceUpdates    chan float64
+	stopChan        chan struct{}
+	// ctx is the parent of every bot context; Stop cancels it.
+	ctx    context.Context
+	cancel context.CancelFunc
 	// Add bot management
 	runningBots map[int]*BotInstance
 	botMutex    sync.RWMutex
+	// paperExchanges holds each user's paper account once loaded.
+	paperExchanges map[int]*simulator.Exchange
+	paperMu        sync.Mutex
 }
 
 type BotInstance struct {
 	UserID       int
-	LNClient     *lnmarkets.Client
+	Mode         string
+	Exchange     lnmarkets.Exchange
 	WSClient     *websocket.Client
 	PriceUpdates chan float64
-	StopChan     chan struct{}
 	IsRunning    bool
 	LastPrice    float64
 	LastUpdate   time.Time
+	stopFeed     func()
+	// network is the LN Markets network whose prices the bot trades on.
+	network string
+	// feedMu guards the price and feed health fields, which the price loop,
+	// the feed watchdog and status requests share.
+	feedMu        sync.Mutex
+	staleAfter    time.Duration
+	fallback      bool
+	stale         bool
+	staleSince    time.Time
+	primaryUpdate time.Time
+	stopFallback  func()
+	// env, strategies and pendingFills belong to the bot's strategy loop,
+	// which fills and reloadStrategies feed.
+	env              *StrategyEnv
+	strategies       []*botStrategy
+	pendingFills     []Fill
+	fills            chan Fill
+	reloadStrategies chan struct{}
+	// ctx is cancelled by StopBot, aborting any in-flight exchange request
+	// made on the bot's behalf.
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
-type TradingConfig struct {
-	UserID           int
-	MarginProtection *models.MarginProtection
-	TakeProfit       *models.TakeProfit
-	EntryAutomation  *models.EntryAutomation
-	PriceAlert       *models.PriceAlert
-	LNMarketsConfig  *models.LNMarketsConfig
+// feedStatus reports the price feed connection state. Bots fed by the price
+// aggregator have no connection of their own.
+func (b *BotInstance) feedStatus() string {
+	if b.WSClient != nil {
+		return string(b.WSClient.State())
+	}
+	return "aggregator"
+}
+
+func (b *BotInstance) status() map[string]interface{} {
+	source := b.priceSource()
+
+	b.feedMu.Lock()
+	defer b.feedMu.Unlock()
+	return map[string]interface{}{
+		"is_running":   true,
+		"mode":         b.Mode,
+		"feed_status":  b.feedStatus(),
+		"feed_stale":   b.stale,
+		"price_source": source,
+		"last_price":   b.LastPrice,
+		"last_update":  b.LastUpdate,
+		"user_id":      b.UserID,
+	}
 }
 
-func NewTradingService(db *database.Database) *TradingService {
+func NewTradingService(db *database.Database, priceAggregator *PriceAggregator, divergence *DivergenceMonitor) *TradingService {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &TradingService{
-		db:           db,
-		priceUpdates: make(chan float64, 100),
-		stopChan:     make(chan struct{}),
-		runningBots:  make(map[int]*BotInstance),
+		db:              db,
+		priceAggregator: priceAggregator,
+		divergence:      divergence,
+		priceUpdates:    make(chan float64, 100),
+		stopChan:        make(chan struct{}),
+		ctx:             ctx,
+		cancel:          cancel,
+		runningBots:     make(map[int]*BotInstance),
+		paperExchanges:  make(map[int]*simulator.Exchange),
 	}
 }
 
-func (s *TradingService) InitializeClient(userID int) error {
+// Start feeds paper accounts from the price aggregator.
+func (s *TradingService) Start() {
+	go s.feedPaperExchanges()
+}
+
+func (s *TradingService) InitializeClient(userID int, mode string) error {
 	// Check if bot is already running
 	s.botMutex.Lock()
 	if bot, exists := s.runningBots[userID]; exists && bot.IsRunning {
@@ -63,47 +122,65 @@ func (s *TradingService) InitializeClient(userID int) error {
 	}
 	s.botMutex.Unlock()
 
-	var config models.LNMarketsConfig
-	err := s.db.Get(&config, "SELECT * FROM ln_markets_config WHERE user_id = $1", userID)
-	if err != nil {
-		return fmt.Errorf("LN Markets config not found: %v", err)
-	}
-
-	lnClient := lnmarkets.NewClient(config.APIKey, config.SecretKey, config.Passphrase, config.IsTestnet)
-
-	wsURL := "wss://api.lnmarkets.com"
-	if config.IsTestnet {
-		wsURL = "wss://api.testnet4.lnmarkets.com"
+	if mode == "" {
+		mode = defaultTradingMode()
 	}
 
-	wsClient := websocket.NewClient(wsURL)
-
-	if err := wsClient.Connect(); err != nil {
-		return fmt.Errorf("failed to connect to websocket: %v", err)
+	bot := &BotInstance{
+		UserID:           userID,
+		Mode:             mode,
+		PriceUpdates:     make(chan float64, 100),
+		IsRunning:        true,
+		LastUpdate:       time.Now(),
+		staleAfter:       feedStaleAfter(),
+		fallback:         feedFallbackEnabled(),
+		primaryUpdate:    time.Now(),
+		fills:            make(chan Fill, 256),
+		reloadStrategies: make(chan struct{}, 1),
 	}
+	bot.ctx, bot.cancel = context.WithCancel(s.ctx)
 
-	priceUpdates := make(chan float64, 100)
-	stopChan := make(chan struct{})
+	switch mode {
+	case TradingModePaper:
+		exchange, err := s.loadPaperExchange(userID, true)
+		if err != nil {
+			bot.cancel()
+			return err
+		}
+		bot.Exchange = exchange
+		bot.network = DefaultNetwork()
+		s.subscribeAggregatedPrices(bot)
+	case TradingModeLive, TradingModeSimulated:
+		var config models.LNMarketsConfig
+		err := s.db.Get(&config, "SELECT * FROM ln_markets_config WHERE user_id = $1", userID)
+		if err != nil && mode == TradingModeLive {
+			bot.cancel()
+			return fmt.Errorf("LN Markets config not found: %v", err)
+		}
 
-	wsClient.OnPriceUpdate(func(data []byte) {
-		var priceData lnmarkets.PriceData
-		if err := json.Unmarshal(data, &priceData); err == nil {
-			priceUpdates <- priceData.Price
+		if mode == TradingModeSimulated {
+			bot.Exchange = newSimulatedExchange()
+		} else {
+			bot.Exchange = lnmarkets.NewClient(config.APIKey, config.SecretKey, config.Passphrase, config.IsTestnet)
 		}
-	})
+		bot.network = networkName(config.IsTestnet)
 
-	if err := wsClient.Subscribe("futures:btc_usd:last-price"); err != nil {
-		return fmt.Errorf("failed to subscribe to price updates: %v", err)
+		if err := s.connectPriceFeed(bot, &config); err != nil {
+			bot.cancel()
+			return err
+		}
+	default:
+		bot.cancel()
+		return fmt.Errorf("unknown trading mode %q", mode)
 	}
 
-	bot := &BotInstance{
-		UserID:       userID,
-		LNClient:     lnClient,
-		WSClient:     wsClient,
-		PriceUpdates: priceUpdates,
-		StopChan:     stopChan,
-		IsRunning:    true,
-		LastUpdate:   time.Now(),
+	bot.env = &StrategyEnv{
+		UserID:   userID,
+		Mode:     mode,
+		Exchange: bot.Exchange,
+		DB:       s.db,
+		service:  s,
+		bot:      bot,
 	}
 
 	s.botMutex.Lock()
@@ -111,227 +188,163 @@ func (s *TradingService) InitializeClient(userID int) error {
 	s.botMutex.Unlock()
 
 	go s.processPriceUpdates(userID, bot)
+	go s.watchFeed(bot)
 
-	log.Printf("Bot started for user %d", userID)
+	log.Printf("Bot started for user %d in %s mode", userID, mode)
 	return nil
 }
 
-func (s *TradingService) processPriceUpdates(userID int, bot *BotInstance) {
-	for {
-		select {
-		case price := <-bot.PriceUpdates:
-			bot.LastPrice = price
-			bot.LastUpdate = time.Now()
-			s.handlePriceUpdate(userID, price, bot)
-		case <-bot.StopChan:
-			log.Printf("Bot stopped for user %d", userID)
-			return
-		}
-	}
-}
-
-func (s *TradingService) handlePriceUpdate(userID int, price float64, bot *BotInstance) {
-	log.Printf("Price update for user %d: $%.2f", userID, price)
-
-	config, err := s.getTradingConfig(userID)
-	if err != nil {
-		log.Printf("Error getting trading config: %v", err)
-		return
-	}
-
-	go s.checkMarginProtection(config, price, bot)
-	go s.checkTakeProfit(config, price, bot)
-	go s.checkEntryAutomation(config, price, bot)
-	go s.checkPriceAlert(config, price, bot)
-}
-
-func (s *TradingService) getTradingConfig(userID int) (*TradingConfig, error) {
-	config := &TradingConfig{UserID: userID}
-
-	var marginProtection models.MarginProtection
-	err := s.db.Get(&marginProtection, "SELECT * FROM margin_protection WHERE user_id = $1", userID)
-	if err == nil {
-		config.MarginProtection = &marginProtection
-	}
-
-	var takeProfit models.TakeProfit
-	err = s.db.Get(&takeProfit, "SELECT * FROM take_profit WHERE user_id = $1", userID)
-	if err == nil {
-		config.TakeProfit = &takeProfit
-	}
-
-	var entryAutomation models.EntryAutomation
-	err = s.db.Get(&entryAutomation, "SELECT * FROM entry_automation WHERE user_id = $1", userID)
-	if err == nil {
-		config.EntryAutomation = &entryAutomation
-	}
-
-	var priceAlert models.PriceAlert
-	err = s.db.Get(&priceAlert, "SELECT * FROM price_alert WHERE user_id = $1", userID)
-	if err == nil {
-		config.PriceAlert = &priceAlert
-	}
-
-	var lnConfig models.LNMarketsConfig
-	err = s.db.Get(&lnConfig, "SELECT * FROM ln_markets_config WHERE user_id = $1", userID)
-	if err == nil {
-		config.LNMarketsConfig = &lnConfig
+// connectPriceFeed streams LN Markets last-price updates into the bot. Live
+// bots also follow their own trade events to keep order statuses current.
+func (s *TradingService) connectPriceFeed(bot *BotInstance, config *models.LNMarketsConfig) error {
+	wsURL := "wss://api.lnmarkets.com"
+	if config.IsTestnet {
+		wsURL = "wss://api.testnet4.lnmarkets.com"
 	}
 
-	return config, nil
-}
-
-func (s *TradingService) checkMarginProtection(config *TradingConfig, currentPrice float64, bot *BotInstance) {
-	if config.MarginProtection == nil || !config.MarginProtection.IsEnabled {
-		return
-	}
+	wsClient := websocket.NewClient(wsURL)
+	wsClient.OnStateChange(func(state websocket.State) {
+		log.Printf("Price feed for user %d is %s", bot.UserID, state)
+	})
 
-	var orders []models.TradingOrder
-	err := s.db.Select(&orders, "SELECT * FROM trading_orders WHERE user_id = $1 AND status = 'open'", config.UserID)
-	if err != nil {
-		log.Printf("Error getting open orders: %v", err)
-		return
+	if err := wsClient.Connect(); err != nil {
+		return fmt.Errorf("failed to connect to websocket: %v", err)
 	}
 
-	for _, order := range orders {
-		liquidationPrice := order.Price * (1 - float64(order.Leverage)/100)
-
-		distanceToLiquidation := math.Abs(currentPrice-liquidationPrice) / liquidationPrice * 100
-
-		if distanceToLiquidation <= config.MarginProtection.ActivationDistance {
-			log.Printf("Margin protection activated for order %s", order.OrderID)
-
-			newTakeProfitPrice := liquidationPrice * (1 + config.MarginProtection.NewLiquidationDistance/100)
-
-			if bot.LNClient != nil {
-				log.Printf("Updating take profit to $%.2f", newTakeProfitPrice)
-			}
-			_, err := s.db.Exec("UPDATE trading_orders SET take_profit_price = $1, updated_at = $2 WHERE id = $3",
-				newTakeProfitPrice, time.Now(), order.ID)
-			if err != nil {
-				log.Printf("Error updating order: %v", err)
+	ctx, cancel := context.WithTimeout(bot.ctx, websocket.DefaultCallTimeout)
+	defer cancel()
+
+	// Only the latest price matters: the subscription conflates while the
+	// bot is busy and the send never blocks the handler. Prices replayed
+	// after a reconnect may be old, and are not passed on if stale.
+	priceUpdates := bot.PriceUpdates
+	_, err := websocket.Handle(ctx, wsClient, websocket.TopicLastPrice, func(update websocket.PriceUpdate) {
+		if update.Time > 0 {
+			if age := time.Since(time.UnixMilli(update.Time)); age > bot.staleAfter {
+				log.Printf("Ignoring price for user %d from %s ago", bot.UserID, age.Round(time.Second))
+				return
 			}
 		}
-	}
-}
-
-func (s *TradingService) checkTakeProfit(config *TradingConfig, currentPrice float64, bot *BotInstance) {
-	if config.TakeProfit == nil || !config.TakeProfit.IsEnabled {
-		return
-	}
-
-	if time.Since(config.TakeProfit.LastUpdate) < 24*time.Hour {
-		return
-	}
-
-	var orders []models.TradingOrder
-	err := s.db.Select(&orders, "SELECT * FROM trading_orders WHERE user_id = $1 AND status = 'open'", config.UserID)
+		bot.markPrimary()
+		select {
+		case priceUpdates <- update.Price:
+		default:
+		}
+	}, websocket.WithPolicy(websocket.Conflate))
 	if err != nil {
-		log.Printf("Error getting open orders: %v", err)
-		return
+		wsClient.Disconnect()
+		return fmt.Errorf("failed to subscribe to price updates: %v", err)
 	}
 
-	for _, order := range orders {
-		newTakeProfitPrice := order.Price * (1 + config.TakeProfit.DailyPercentage/100)
-
-		if bot.LNClient != nil {
-			log.Printf("Updating take profit for order %s to $%.2f", order.OrderID, newTakeProfitPrice)
+	if bot.Mode == TradingModeLive && config.APIKey != "" {
+		err := wsClient.Authenticate(ctx, config.APIKey, config.SecretKey, config.Passphrase)
+		if err == nil {
+			_, err = websocket.Handle(ctx, wsClient, websocket.TopicUserTrades, func(event websocket.TradeEvent) {
+				s.handleTradeEvent(bot, event)
+			}, websocket.WithBuffer(256))
 		}
-
-		_, err := s.db.Exec("UPDATE trading_orders SET take_profit_price = $1, updated_at = $2 WHERE id = $3",
-			newTakeProfitPrice, time.Now(), order.ID)
 		if err != nil {
-			log.Printf("Error updating order: %v", err)
+			// Prices still flow; order statuses are just not pushed.
+			log.Printf("Trade events unavailable for user %d: %v", bot.UserID, err)
 		}
 	}
 
-	_, err = s.db.Exec("UPDATE take_profit SET last_update = $1 WHERE user_id = $2",
-		time.Now(), config.UserID)
-	if err != nil {
-		log.Printf("Error updating take profit last_update: %v", err)
-	}
+	bot.WSClient = wsClient
+	return nil
 }
 
-func (s *TradingService) checkEntryAutomation(config *TradingConfig, currentPrice float64, bot *BotInstance) {
-	if config.EntryAutomation == nil || !config.EntryAutomation.IsEnabled {
+// handleTradeEvent mirrors exchange-side trade changes, such as fills and
+// liquidations, into the bot's stored orders.
+func (s *TradingService) handleTradeEvent(bot *BotInstance, event websocket.TradeEvent) {
+	status := event.Trade.Status()
+	if event.Trade.ID == "" || status == "unknown" {
 		return
 	}
 
-	if config.EntryAutomation.FilledSlots >= config.EntryAutomation.NumberOfOrders {
+	result, err := s.db.Exec("UPDATE trading_orders SET status = $1, updated_at = $2 WHERE user_id = $3 AND order_id = $4 AND mode = $5",
+		status, time.Now(), bot.UserID, event.Trade.ID, bot.Mode)
+	if err != nil {
+		log.Printf("Error updating order %s from trade event: %v", event.Trade.ID, err)
 		return
 	}
+	if rows, _ := result.RowsAffected(); rows > 0 {
+		log.Printf("Order %s is now %s (%s)", event.Trade.ID, status, event.Event)
+	}
 
-	targetPrice := config.EntryAutomation.InitialPrice +
-		float64(config.EntryAutomation.FilledSlots)*config.EntryAutomation.PriceVariation
+	bot.queueFill(Fill{Event: event.Event, Trade: event.Trade})
+}
 
-	if math.Abs(currentPrice-targetPrice) <= config.EntryAutomation.PriceVariation/2 {
-		trade := &lnmarkets.TradeRequest{
-			Type:     config.EntryAutomation.OperationType,
-			Amount:   config.EntryAutomation.AmountPerOrder,
-			Price:    currentPrice,
-			Leverage: config.EntryAutomation.Leverage,
+// subscribeAggregatedPrices feeds the bot from the multi-exchange aggregator
+// instead of the LN Markets websocket.
+func (s *TradingService) subscribeAggregatedPrices(bot *BotInstance) {
+	ch, unsubscribe := s.priceAggregator.Subscribe()
+	bot.stopFeed = unsubscribe
+
+	go func() {
+		for snap := range ch {
+			bot.markPrimary()
+			select {
+			case bot.PriceUpdates <- snap.Price:
+			default:
+			}
 		}
+	}()
+}
 
-		if bot.LNClient != nil {
-			tradeResp, err := bot.LNClient.CreateTrade(trade)
-			if err != nil {
-				log.Printf("Error creating trade: %v", err)
-				return
-			}
+// processPriceUpdates is the bot's strategy loop: prices, fills and reloads
+// are handled one at a time, in order of arrival.
+func (s *TradingService) processPriceUpdates(userID int, bot *BotInstance) {
+	s.startStrategies(bot)
+	defer s.stopStrategies(bot)
 
-			order := &models.TradingOrder{
-				UserID:          config.UserID,
-				OrderID:         tradeResp.ID,
-				Type:            tradeResp.Type,
-				Amount:          tradeResp.Amount,
-				Price:           tradeResp.Price,
-				Leverage:        tradeResp.Leverage,
-				Status:          tradeResp.Status,
-				TakeProfitPrice: currentPrice * (1 + config.EntryAutomation.TakeProfitPerOrder/100),
-				CreatedAt:       time.Now(),
-				UpdatedAt:       time.Now(),
+	for {
+		select {
+		case price := <-bot.PriceUpdates:
+			// Strategies only act on the latest price.
+			for drained := false; !drained; {
+				select {
+				case price = <-bot.PriceUpdates:
+				default:
+					drained = true
+				}
 			}
-
-			_, err = s.db.NamedExec(`
-				INSERT INTO trading_orders (user_id, order_id, type, amount, price, leverage, status, take_profit_price, created_at, updated_at)
-				VALUES (:user_id, :order_id, :type, :amount, :price, :leverage, :status, :take_profit_price, :created_at, :updated_at)
-			`, order)
-			if err != nil {
-				log.Printf("Error saving order: %v", err)
-				return
+			if recovered, downtime := bot.recordPrice(price); recovered {
+				log.Printf("Price feed for user %d recovered after %s", userID, downtime.Round(time.Second))
+				s.recordFeedEvent(bot, FeedEventRecovered, fmt.Sprintf("stale for %s", downtime.Round(time.Second)))
 			}
-
-			_, err = s.db.Exec("UPDATE entry_automation SET filled_slots = filled_slots + 1 WHERE user_id = $1",
-				config.UserID)
-			if err != nil {
-				log.Printf("Error updating filled slots: %v", err)
+			if feeder, ok := bot.Exchange.(priceFeeder); ok {
+				feeder.UpdatePrice(price)
 			}
-
-			log.Printf("Created new order: %s at price $%.2f", tradeResp.ID, currentPrice)
+			s.handlePriceUpdate(userID, price, bot)
+		case fill := <-bot.fills:
+			bot.runStrategies("fill", func(strategy Strategy) error {
+				return strategy.OnFill(bot.ctx, bot.env, fill)
+			})
+		case <-bot.reloadStrategies:
+			s.stopStrategies(bot)
+			s.startStrategies(bot)
+			log.Printf("Strategies reloaded for user %d", userID)
+		case <-bot.ctx.Done():
+			log.Printf("Bot stopped for user %d", userID)
+			return
 		}
 	}
 }
 
-func (s *TradingService) checkPriceAlert(config *TradingConfig, currentPrice float64, bot *BotInstance) {
-	if config.PriceAlert == nil || !config.PriceAlert.IsEnabled {
-		return
-	}
-
-	if currentPrice < config.PriceAlert.MinPrice || currentPrice > config.PriceAlert.MaxPrice {
-		if time.Since(config.PriceAlert.LastAlert) >= time.Duration(config.PriceAlert.CheckInterval)*time.Second {
-			log.Printf("PRICE ALERT: Bitcoin price $%.2f is outside range $%.2f - $%.2f",
-				currentPrice, config.PriceAlert.MinPrice, config.PriceAlert.MaxPrice)
+func (s *TradingService) handlePriceUpdate(userID int, price float64, bot *BotInstance) {
+	log.Printf("Price update for user %d: $%.2f", userID, price)
 
-			_, err := s.db.Exec("UPDATE price_alert SET last_alert = $1 WHERE user_id = $2",
-				time.Now(), config.UserID)
-			if err != nil {
-				log.Printf("Error updating last alert: %v", err)
-			}
+	fallback := bot.onFallback()
+	bot.runStrategies("price", func(strategy Strategy) error {
+		if fallback && !runsOnFallback(strategy) {
+			return nil
 		}
-	}
+		return strategy.OnPrice(bot.ctx, bot.env, price)
+	})
 }
 
 func (s *TradingService) Stop() {
+	s.cancel()
 	close(s.stopChan)
 	if s.wsClient != nil {
 		s.wsClient.Disconnect()
@@ -343,10 +356,13 @@ func (s *TradingService) StopBot(userID int) error {
 	defer s.botMutex.Unlock()
 
 	if bot, exists := s.runningBots[userID]; exists && bot.IsRunning {
-		close(bot.StopChan)
+		bot.cancel()
 		if bot.WSClient != nil {
 			bot.WSClient.Disconnect()
 		}
+		if bot.stopFeed != nil {
+			bot.stopFeed()
+		}
 		bot.IsRunning = false
 		delete(s.runningBots, userID)
 		log.Printf("Bot stopped for user %d", userID)
@@ -361,12 +377,7 @@ func (s *TradingService) GetBotStatus(userID int) (map[string]interface{}, error
 	defer s.botMutex.RUnlock()
 
 	if bot, exists := s.runningBots[userID]; exists && bot.IsRunning {
-		return map[string]interface{}{
-			"is_running":  true,
-			"last_price":  bot.LastPrice,
-			"last_update": bot.LastUpdate,
-			"user_id":     bot.UserID,
-		}, nil
+		return bot.status(), nil
 	}
 
 	return map[string]interface{}{
@@ -382,12 +393,7 @@ func (s *TradingService) GetAllBotStatuses() map[int]map[string]interface{} {
 	statuses := make(map[int]map[string]interface{})
 	for userID, bot := range s.runningBots {
 		if bot.IsRunning {
-			statuses[userID] = map[string]interface{}{
-				"is_running":  true,
-				"last_price":  bot.LastPrice,
-				"last_update": bot.LastUpdate,
-				"user_id":     bot.UserID,
-			}
+			statuses[userID] = bot.status()
 		}
 	}
 
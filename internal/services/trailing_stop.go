@@ -0,0 +1,252 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"btc-trading-bot/internal/models"
+	"btc-trading-bot/pkg/lnmarkets"
+	"btc-trading-bot/pkg/pricing"
+)
+
+func init() {
+	RegisterSingleStrategy("trailing_stop", newTrailingStopStrategy)
+}
+
+// trailingPositionsRefresh is how long running positions fetched from the
+// exchange are reused between prices. Fills refresh them sooner.
+const trailingPositionsRefresh = 10 * time.Second
+
+// trailingStopParams configures a trailing stop. The trail is either
+// TrailUSD or TrailPercent of the water mark.
+type trailingStopParams struct {
+	TrailUSD     float64 `json:"trail_usd"`
+	TrailPercent float64 `json:"trail_percent"`
+	// ActivationPercent is the move in the position's favour, from its
+	// entry price, before the stop starts to trail.
+	ActivationPercent float64 `json:"activation_percent"`
+	// StepUSD is the least the stop-loss moves at a time.
+	StepUSD float64 `json:"step_usd"`
+}
+
+// trailingStopStrategy trails the stop-loss of every running position on the
+// exchange behind its water mark: the highest price since entry for longs,
+// the lowest for shorts. The stop only ever moves in the position's favour.
+// Water marks are stored in trailing_stops as they move, so a restart resumes
+// the trail. Its state is kept per position rather than per instance, so a
+// user may run only one trailing stop.
+type trailingStopStrategy struct {
+	BaseStrategy
+	params    trailingStopParams
+	stops     map[string]*models.TrailingStop
+	positions []lnmarkets.TradeResponse
+	fetchedAt time.Time
+}
+
+func newTrailingStopStrategy(raw json.RawMessage) (Strategy, error) {
+	var params trailingStopParams
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, fmt.Errorf("invalid trailing stop params: %v", err)
+	}
+	switch {
+	case (params.TrailUSD > 0) == (params.TrailPercent > 0):
+		return nil, errors.New("exactly one of trail_usd and trail_percent must be positive")
+	case params.TrailUSD < 0 || params.TrailPercent < 0 || params.TrailPercent >= 100:
+		return nil, errors.New("trail_percent must be between 0 and 100 and trail_usd positive")
+	case params.ActivationPercent < 0 || params.StepUSD < 0:
+		return nil, errors.New("activation_percent and step_usd must not be negative")
+	}
+	return &trailingStopStrategy{params: params}, nil
+}
+
+func (t *trailingStopStrategy) OnStart(ctx context.Context, env *StrategyEnv) error {
+	var stops []models.TrailingStop
+	err := env.DB.Select(&stops, "SELECT * FROM trailing_stops WHERE user_id = $1 AND mode = $2",
+		env.UserID, env.Mode)
+	if err != nil {
+		return fmt.Errorf("loading trailing stops: %v", err)
+	}
+
+	t.stops = make(map[string]*models.TrailingStop, len(stops))
+	for i := range stops {
+		t.stops[stops[i].PositionID] = &stops[i]
+	}
+	t.fetchedAt = time.Time{}
+	return nil
+}
+
+func (t *trailingStopStrategy) OnFill(ctx context.Context, env *StrategyEnv, fill Fill) error {
+	t.fetchedAt = time.Time{}
+	if fill.Trade.Closed || fill.Trade.Canceled {
+		t.forget(env, fill.Trade.ID)
+	}
+	return nil
+}
+
+func (t *trailingStopStrategy) OnPrice(ctx context.Context, env *StrategyEnv, currentPrice float64) error {
+	if env.Exchange == nil {
+		return nil
+	}
+
+	if time.Since(t.fetchedAt) > trailingPositionsRefresh {
+		positions, err := env.Exchange.GetPositionsContext(ctx, "running")
+		if err != nil {
+			return fmt.Errorf("getting running positions: %v", err)
+		}
+		t.positions = positions
+		t.fetchedAt = time.Now()
+
+		running := make(map[string]bool, len(positions))
+		for _, position := range positions {
+			running[position.ID] = true
+		}
+		for id := range t.stops {
+			if !running[id] {
+				t.forget(env, id)
+			}
+		}
+	}
+
+	for i := range t.positions {
+		position := &t.positions[i]
+		if err := t.trail(ctx, env, position, currentPrice); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Printf("Trailing stop for position %s failed: %v", position.ID, err)
+		}
+	}
+	return nil
+}
+
+// trail moves position's water mark to currentPrice when it is better and,
+// once the trail is active, its stop-loss up behind the water mark. The stop
+// is saved whenever it changes.
+func (t *trailingStopStrategy) trail(ctx context.Context, env *StrategyEnv, position *lnmarkets.TradeResponse, currentPrice float64) error {
+	stop, ok := t.stops[position.ID]
+	if !ok {
+		entry := position.EntryPrice
+		if entry <= 0 {
+			entry = position.Price
+		}
+		stop = &models.TrailingStop{
+			UserID:     env.UserID,
+			Mode:       env.Mode,
+			PositionID: position.ID,
+			Side:       position.Side,
+			EntryPrice: entry,
+			WaterMark:  entry,
+			StopLoss:   position.StopLoss,
+			CreatedAt:  time.Now(),
+		}
+		t.stops[position.ID] = stop
+	}
+
+	wasActive := stop.IsActive
+	changed := t.params.advance(stop, currentPrice) || !ok
+	if stop.IsActive && !wasActive {
+		log.Printf("Trailing stop for position %s active at $%.2f", position.ID, stop.WaterMark)
+	}
+
+	var moveErr error
+	if target, move := t.params.stopLoss(stop, position.StopLoss, currentPrice); move {
+		moveErr = env.Exchange.UpdateStopLossContext(ctx, position.ID, target)
+		if moveErr == nil {
+			log.Printf("Trailing stop moved stop-loss of position %s to $%.2f (was $%.2f, water mark $%.2f)",
+				position.ID, target, position.StopLoss, stop.WaterMark)
+			position.StopLoss = target
+			stop.StopLoss = target
+			changed = true
+		}
+	}
+
+	if changed {
+		stop.UpdatedAt = time.Now()
+		if err := saveTrailingStop(env, stop); err != nil {
+			return err
+		}
+	}
+	return moveErr
+}
+
+// advance moves stop's water mark to price when it is better and activates
+// the trail once the water mark is activation_percent past the entry. It
+// reports whether the stop changed.
+func (p trailingStopParams) advance(stop *models.TrailingStop, price float64) bool {
+	long := pricing.IsLong(stop.Side)
+	changed := false
+	if (long && price > stop.WaterMark) || (!long && price < stop.WaterMark) {
+		stop.WaterMark = price
+		changed = true
+	}
+	if !stop.IsActive {
+		activation := pricing.TargetPrice(stop.Side, stop.EntryPrice, p.ActivationPercent)
+		if profitPassed(stop.Side, activation, stop.WaterMark) {
+			stop.IsActive = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+// stopLoss returns the stop-loss trailing stop's water mark and whether the
+// position's current stop-loss should move there. The stop only moves in the
+// position's favour, by at least a step, and never to where the price has
+// already gone.
+func (p trailingStopParams) stopLoss(stop *models.TrailingStop, current, price float64) (float64, bool) {
+	if !stop.IsActive {
+		return 0, false
+	}
+	long := pricing.IsLong(stop.Side)
+
+	trail := p.TrailUSD
+	if p.TrailPercent > 0 {
+		trail = stop.WaterMark * p.TrailPercent / 100
+	}
+	target := stop.WaterMark - trail
+	if !long {
+		target = stop.WaterMark + trail
+	}
+	// LN Markets prices move in steps of $0.5.
+	target = math.Round(target*2) / 2
+
+	step := max(p.StepUSD, 0.5)
+	if current > 0 && ((long && target < current+step) || (!long && target > current-step)) {
+		return 0, false
+	}
+	if target <= 0 || (long && target >= price) || (!long && target <= price) {
+		return 0, false
+	}
+	return target, true
+}
+
+// forget drops the state of a position that is no longer running.
+func (t *trailingStopStrategy) forget(env *StrategyEnv, positionID string) {
+	if _, ok := t.stops[positionID]; !ok {
+		return
+	}
+	delete(t.stops, positionID)
+	_, err := env.DB.Exec("DELETE FROM trailing_stops WHERE user_id = $1 AND mode = $2 AND position_id = $3",
+		env.UserID, env.Mode, positionID)
+	if err != nil {
+		log.Printf("Error deleting trailing stop for position %s: %v", positionID, err)
+	}
+}
+
+func saveTrailingStop(env *StrategyEnv, stop *models.TrailingStop) error {
+	_, err := env.DB.NamedExec(`
+		INSERT INTO trailing_stops (user_id, mode, position_id, side, entry_price, water_mark, stop_loss, is_active, created_at, updated_at)
+		VALUES (:user_id, :mode, :position_id, :side, :entry_price, :water_mark, :stop_loss, :is_active, :created_at, :updated_at)
+		ON CONFLICT (user_id, mode, position_id) DO UPDATE
+		SET water_mark = EXCLUDED.water_mark, stop_loss = EXCLUDED.stop_loss, is_active = EXCLUDED.is_active, updated_at = EXCLUDED.updated_at
+	`, stop)
+	if err != nil {
+		return fmt.Errorf("saving trailing stop for position %s: %v", stop.PositionID, err)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"btc-trading-bot/internal/models"
+	"btc-trading-bot/pkg/lnmarkets"
+)
+
+func TestTrailingStopParams(t *testing.T) {
+	tests := []struct {
+		params string
+		valid  bool
+	}{
+		{`{"trail_usd": 500}`, true},
+		{`{"trail_percent": 2, "activation_percent": 1, "step_usd": 50}`, true},
+		{`{}`, false},
+		{`{"trail_usd": 500, "trail_percent": 2}`, false},
+		{`{"trail_percent": 100}`, false},
+		{`{"trail_usd": 500, "step_usd": -1}`, false},
+		{`{"trail_usd": "500"}`, false},
+	}
+	for _, tt := range tests {
+		_, err := NewStrategy("trailing_stop", json.RawMessage(tt.params))
+		if (err == nil) != tt.valid {
+			t.Errorf("NewStrategy(trailing_stop, %s) error = %v, want valid %v", tt.params, err, tt.valid)
+		}
+	}
+	if !IsSingleStrategy("trailing_stop") {
+		t.Error("trailing_stop allows several instances, want one")
+	}
+}
+
+func TestTrailingStopAdvance(t *testing.T) {
+	params := trailingStopParams{TrailUSD: 500, ActivationPercent: 2}
+	tests := []struct {
+		name      string
+		side      string
+		prices    []float64
+		waterMark float64
+		active    bool
+		changed   []bool
+	}{
+		{"long rising", lnmarkets.SideBuy, []float64{50_500, 50_200, 51_000}, 51_000, true, []bool{true, false, true}},
+		{"long below activation", lnmarkets.SideBuy, []float64{50_900, 49_000}, 50_900, false, []bool{true, false}},
+		{"short falling", lnmarkets.SideSell, []float64{49_500, 49_800, 49_000}, 49_000, true, []bool{true, false, true}},
+		{"short below activation", lnmarkets.SideSell, []float64{49_100, 51_000}, 49_100, false, []bool{true, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stop := &models.TrailingStop{Side: tt.side, EntryPrice: 50_000, WaterMark: 50_000}
+			for i, price := range tt.prices {
+				if changed := params.advance(stop, price); changed != tt.changed[i] {
+					t.Errorf("advance(%.0f) = %v, want %v", price, changed, tt.changed[i])
+				}
+			}
+			if stop.WaterMark != tt.waterMark || stop.IsActive != tt.active {
+				t.Errorf("water mark %.0f active %v, want %.0f %v", stop.WaterMark, stop.IsActive, tt.waterMark, tt.active)
+			}
+		})
+	}
+}
+
+func TestTrailingStopLoss(t *testing.T) {
+	tests := []struct {
+		name      string
+		params    trailingStopParams
+		side      string
+		waterMark float64
+		current   float64
+		price     float64
+		want      float64
+		move      bool
+	}{
+		{"long first stop", trailingStopParams{TrailUSD: 500}, lnmarkets.SideBuy, 52_000, 0, 52_000, 51_500, true},
+		{"long raises stop", trailingStopParams{TrailUSD: 500}, lnmarkets.SideBuy, 52_000, 51_000, 51_800, 51_500, true},
+		{"long never lowers", trailingStopParams{TrailUSD: 500}, lnmarkets.SideBuy, 52_000, 51_800, 52_000, 0, false},
+		{"long below step", trailingStopParams{TrailUSD: 500, StepUSD: 100}, lnmarkets.SideBuy, 52_000, 51_450, 52_000, 0, false},
+		{"long percent", trailingStopParams{TrailPercent: 2}, lnmarkets.SideBuy, 52_000, 0, 52_000, 50_960, true},
+		{"long price passed", trailingStopParams{TrailUSD: 500}, lnmarkets.SideBuy, 52_000, 0, 51_400, 0, false},
+		{"short first stop", trailingStopParams{TrailUSD: 500}, lnmarkets.SideSell, 48_000, 0, 48_000, 48_500, true},
+		{"short lowers stop", trailingStopParams{TrailUSD: 500}, lnmarkets.SideSell, 48_000, 49_000, 48_200, 48_500, true},
+		{"short never raises", trailingStopParams{TrailUSD: 500}, lnmarkets.SideSell, 48_000, 48_200, 48_000, 0, false},
+		{"short percent rounded", trailingStopParams{TrailPercent: 1}, lnmarkets.SideSell, 48_123, 0, 48_123, 48_604, true},
+		{"short price passed", trailingStopParams{TrailUSD: 500}, lnmarkets.SideSell, 48_000, 0, 48_600, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stop := &models.TrailingStop{Side: tt.side, WaterMark: tt.waterMark, IsActive: true}
+			got, move := tt.params.stopLoss(stop, tt.current, tt.price)
+			if got != tt.want || move != tt.move {
+				t.Errorf("stopLoss = %.2f, %v, want %.2f, %v", got, move, tt.want, tt.move)
+			}
+		})
+	}
+
+	inactive := &models.TrailingStop{Side: lnmarkets.SideBuy, WaterMark: 52_000}
+	if _, move := (trailingStopParams{TrailUSD: 500}).stopLoss(inactive, 0, 52_000); move {
+		t.Error("stopLoss moves an inactive trail")
+	}
+}
+
+// A long trailed on the simulator is stopped out behind its high, in profit.
+func TestTrailingStopClosesBehindHigh(t *testing.T) {
+	ctx := context.Background()
+	exchange, trade := openTestPosition(t, lnmarkets.SideBuy)
+
+	params := trailingStopParams{TrailUSD: 500, ActivationPercent: 1}
+	stop := &models.TrailingStop{Side: trade.Side, EntryPrice: 50_000, WaterMark: 50_000}
+	for _, price := range []float64{50_300, 50_800, 52_000, 51_700, 52_400, 52_100} {
+		exchange.UpdatePrice(price)
+		position, err := exchange.GetPositionContext(ctx, trade.ID)
+		if err != nil {
+			t.Fatalf("GetPosition: %v", err)
+		}
+		if !position.Running {
+			t.Fatalf("position stopped out at %.0f", price)
+		}
+		params.advance(stop, price)
+		if target, move := params.stopLoss(stop, position.StopLoss, price); move {
+			if err := exchange.UpdateStopLossContext(ctx, trade.ID, target); err != nil {
+				t.Fatalf("UpdateStopLoss(%.2f): %v", target, err)
+			}
+		}
+	}
+
+	exchange.UpdatePrice(51_800)
+	position, err := exchange.GetPositionContext(ctx, trade.ID)
+	if err != nil {
+		t.Fatalf("GetPosition: %v", err)
+	}
+	if !position.Closed || position.StopLoss != 51_900 {
+		t.Fatalf("position closed %v with stop-loss %.2f, want closed at 51900", position.Closed, position.StopLoss)
+	}
+	if position.PL <= 0 {
+		t.Errorf("PL = %.0f, want a profit", position.PL)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"math/rand"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"btc-trading-bot/internal/database"
 	"btc-trading-bot/internal/handlers"
@@ -63,12 +65,22 @@ func main() {
 
 	jwtSecret := getEnv("JWT_SECRET", randomString(32))
 	authService := services.NewAuthService(db, jwtSecret)
-	tradingService := services.NewTradingService(db)
 	priceAggregator := services.NewPriceAggregator()
 	priceAggregator.Start()
+	go reloadOnHangup(priceAggregator)
+	marketDataService := services.NewMarketDataService()
+	divergenceMonitor := services.NewDivergenceMonitor(db, priceAggregator)
+	divergenceMonitor.Start()
+	tradingService := services.NewTradingService(db, priceAggregator, divergenceMonitor)
+	tradingService.Start()
+	fundingService := services.NewFundingService(db)
+	candleService := services.NewCandleService(db, priceAggregator)
+	candleService.Start()
 
 	authHandler := handlers.NewAuthHandler(authService)
 	tradingHandler := handlers.NewTradingHandler(db, tradingService)
+	fundingHandler := handlers.NewFundingHandler(fundingService)
+	marketHandler := handlers.NewMarketHandler(marketDataService, candleService, priceAggregator, divergenceMonitor)
 	wsHandler := handlers.NewWebSocketHandler(priceAggregator, authService)
 
 	router := mux.NewRouter()
@@ -99,11 +111,22 @@ func main() {
 	protected.HandleFunc("/lnmarkets/config", tradingHandler.SetLNMarketsConfig).Methods("POST")
 	protected.HandleFunc("/lnmarkets/config", tradingHandler.GetLNMarketsConfig).Methods("GET")
 
+	protected.HandleFunc("/lnmarkets/deposits", fundingHandler.CreateDeposit).Methods("POST")
+	protected.HandleFunc("/lnmarkets/deposits", fundingHandler.GetDeposits).Methods("GET")
+	protected.HandleFunc("/lnmarkets/withdrawals", fundingHandler.RequestWithdrawal).Methods("POST")
+	protected.HandleFunc("/lnmarkets/withdrawals", fundingHandler.GetWithdrawals).Methods("GET")
+	protected.HandleFunc("/lnmarkets/withdrawals/requests", fundingHandler.GetWithdrawalRequests).Methods("GET")
+	protected.HandleFunc("/lnmarkets/withdrawals/{id}/confirm", fundingHandler.ConfirmWithdrawal).Methods("POST")
+	protected.HandleFunc("/lnmarkets/withdrawal-limit", fundingHandler.SetWithdrawalLimit).Methods("POST")
+	protected.HandleFunc("/lnmarkets/withdrawal-limit", fundingHandler.GetWithdrawalLimit).Methods("GET")
+
 	protected.HandleFunc("/trading/margin-protection", tradingHandler.SetMarginProtection).Methods("POST")
 	protected.HandleFunc("/trading/margin-protection", tradingHandler.GetMarginProtection).Methods("GET")
+	protected.HandleFunc("/trading/margin-protection/history", tradingHandler.GetMarginInterventions).Methods("GET")
 
 	protected.HandleFunc("/trading/take-profit", tradingHandler.SetTakeProfit).Methods("POST")
 	protected.HandleFunc("/trading/take-profit", tradingHandler.GetTakeProfit).Methods("GET")
+	protected.HandleFunc("/trading/take-profit/history", tradingHandler.GetTakeProfitUpdates).Methods("GET")
 
 	protected.HandleFunc("/trading/entry-automation", tradingHandler.SetEntryAutomation).Methods("POST")
 	protected.HandleFunc("/trading/entry-automation", tradingHandler.GetEntryAutomation).Methods("GET")
@@ -113,16 +136,47 @@ func main() {
 
 	protected.HandleFunc("/trading/orders", tradingHandler.GetOrders).Methods("GET")
 
+	protected.HandleFunc("/trading/strategies", tradingHandler.GetStrategies).Methods("GET")
+	protected.HandleFunc("/trading/strategies", tradingHandler.CreateStrategy).Methods("POST")
+	protected.HandleFunc("/trading/strategies/{id}", tradingHandler.UpdateStrategy).Methods("POST")
+	protected.HandleFunc("/trading/strategies/{id}", tradingHandler.DeleteStrategy).Methods("DELETE")
+	protected.HandleFunc("/trading/trailing-stops", tradingHandler.GetTrailingStops).Methods("GET")
+
 	protected.HandleFunc("/trading/bot/start", tradingHandler.StartBot).Methods("POST")
 	protected.HandleFunc("/trading/bot/stop", tradingHandler.StopBot).Methods("POST")
 	protected.HandleFunc("/trading/bot/status", tradingHandler.GetBotStatus).Methods("GET")
+	protected.HandleFunc("/trading/bot/feed-events", tradingHandler.GetFeedEvents).Methods("GET")
 	protected.HandleFunc("/trading/account/balance", tradingHandler.GetAccountBalance).Methods("GET")
 	protected.HandleFunc("/trading/positions", tradingHandler.GetPositions).Methods("GET")
+	protected.HandleFunc("/trading/positions", tradingHandler.CreateTrade).Methods("POST")
+	protected.HandleFunc("/trading/positions/close-all", tradingHandler.CloseAllPositions).Methods("POST")
+	protected.HandleFunc("/trading/positions/cancel-all", tradingHandler.CancelAllTrades).Methods("POST")
 	protected.HandleFunc("/trading/positions/{id}", tradingHandler.GetPosition).Methods("GET")
 	protected.HandleFunc("/trading/positions/{id}/close", tradingHandler.ClosePosition).Methods("POST")
+	protected.HandleFunc("/trading/positions/{id}/cancel", tradingHandler.CancelTrade).Methods("POST")
+	protected.HandleFunc("/trading/positions/{id}/add-margin", tradingHandler.AddMargin).Methods("POST")
+	protected.HandleFunc("/trading/positions/{id}/cash-in", tradingHandler.CashIn).Methods("POST")
 	protected.HandleFunc("/trading/positions/{id}/take-profit", tradingHandler.UpdateTakeProfit).Methods("POST")
 	protected.HandleFunc("/trading/positions/{id}/stop-loss", tradingHandler.UpdateStopLoss).Methods("POST")
 
+	protected.HandleFunc("/trading/options", tradingHandler.GetOptionTrades).Methods("GET")
+	protected.HandleFunc("/trading/options", tradingHandler.CreateOptionTrade).Methods("POST")
+	protected.HandleFunc("/trading/options/instruments", tradingHandler.GetOptionInstruments).Methods("GET")
+	protected.HandleFunc("/trading/options/instruments/{name}", tradingHandler.GetOptionInstrument).Methods("GET")
+	protected.HandleFunc("/trading/options/settlements", tradingHandler.GetOptionSettlements).Methods("GET")
+	protected.HandleFunc("/trading/options/{id}/close", tradingHandler.CloseOptionTrade).Methods("POST")
+
+	protected.HandleFunc("/market/ticker", marketHandler.GetTicker).Methods("GET")
+	protected.HandleFunc("/market/index", marketHandler.GetIndexHistory).Methods("GET")
+	protected.HandleFunc("/market/price", marketHandler.GetPriceHistory).Methods("GET")
+	protected.HandleFunc("/market/ohlc", marketHandler.GetOHLC).Methods("GET")
+	protected.HandleFunc("/market/carry-fees", marketHandler.GetCarryFees).Methods("GET")
+	protected.HandleFunc("/market/leaderboard", marketHandler.GetLeaderboard).Methods("GET")
+	protected.HandleFunc("/market/candles", marketHandler.GetCandles).Methods("GET")
+	protected.HandleFunc("/market/sources", marketHandler.GetSourceStats).Methods("GET")
+	protected.HandleFunc("/market/divergence", marketHandler.GetDivergence).Methods("GET")
+	protected.HandleFunc("/market/divergence/events", marketHandler.GetDivergenceEvents).Methods("GET")
+
 	router.HandleFunc("/api/ws/btc-price", wsHandler.StreamBTCPrice).Methods("GET")
 
 	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -136,6 +190,20 @@ func main() {
 	log.Fatal(http.ListenAndServe(":"+port, router))
 }
 
+// reloadOnHangup re-reads .env and the price aggregator sources on SIGHUP.
+func reloadOnHangup(priceAggregator *services.PriceAggregator) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	for range hangup {
+		if err := godotenv.Overload(); err != nil {
+			log.Printf("No .env file reloaded: %v", err)
+		}
+		if err := priceAggregator.Reload(); err != nil {
+			log.Printf("Keeping current price sources: %v", err)
+		}
+	}
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
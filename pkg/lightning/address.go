@@ -0,0 +1,162 @@
+package lightning
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrPrivateAddress is returned when a Lightning address, its callback or a
+// redirect points at a loopback, private or otherwise non-public address.
+var ErrPrivateAddress = errors.New("lightning address resolves to a non-public address")
+
+// httpClient only connects to public addresses. The check runs on the
+// address actually dialled, so DNS rebinding and redirects are covered too.
+var httpClient = &http.Client{
+	Timeout: 15 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: dialPublicOnly,
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+}
+
+// nonPublic lists ranges that are unicast but not reachable on the internet.
+var nonPublic = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil || !isPublic(ip) {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+	}
+	return nil
+}
+
+func isPublic(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublic {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsAddress reports whether s looks like a Lightning address (user@domain).
+func IsAddress(s string) bool {
+	user, domain, found := strings.Cut(strings.TrimSpace(s), "@")
+	return found && user != "" && strings.Contains(domain, ".") && !strings.ContainsAny(domain, "/@ ")
+}
+
+// ResolveAddress fetches an invoice of amount sats for a Lightning address
+// using the LNURL-pay protocol (LUD-06 and LUD-16).
+func ResolveAddress(ctx context.Context, address string, amount int64) (string, error) {
+	if !IsAddress(address) {
+		return "", fmt.Errorf("invalid lightning address %q", address)
+	}
+	user, domain, _ := strings.Cut(strings.ToLower(strings.TrimSpace(address)), "@")
+
+	var params struct {
+		Status      string `json:"status"`
+		Reason      string `json:"reason"`
+		Tag         string `json:"tag"`
+		Callback    string `json:"callback"`
+		MinSendable int64  `json:"minSendable"`
+		MaxSendable int64  `json:"maxSendable"`
+	}
+	wellKnown := "https://" + domain + "/.well-known/lnurlp/" + url.PathEscape(user)
+	if err := getJSON(ctx, wellKnown, &params); err != nil {
+		return "", fmt.Errorf("failed to resolve lightning address: %w", err)
+	}
+	if strings.EqualFold(params.Status, "ERROR") {
+		return "", fmt.Errorf("lightning address error: %s", params.Reason)
+	}
+	if params.Tag != "payRequest" || params.Callback == "" {
+		return "", fmt.Errorf("lightning address does not accept payments")
+	}
+
+	msat := amount * 1000
+	if msat < params.MinSendable || (params.MaxSendable > 0 && msat > params.MaxSendable) {
+		return "", fmt.Errorf("amount %d sats outside the range accepted by %s (%d-%d sats)",
+			amount, address, params.MinSendable/1000, params.MaxSendable/1000)
+	}
+
+	callback, err := url.Parse(params.Callback)
+	if err != nil || callback.Scheme != "https" {
+		return "", fmt.Errorf("invalid lightning address callback")
+	}
+	query := callback.Query()
+	query.Set("amount", strconv.FormatInt(msat, 10))
+	callback.RawQuery = query.Encode()
+
+	var payment struct {
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+		PR     string `json:"pr"`
+	}
+	if err := getJSON(ctx, callback.String(), &payment); err != nil {
+		return "", fmt.Errorf("failed to request invoice: %w", err)
+	}
+	if strings.EqualFold(payment.Status, "ERROR") {
+		return "", fmt.Errorf("lightning address error: %s", payment.Reason)
+	}
+
+	// Never pay more than was asked for, whatever the remote service says.
+	invoiceAmount, ok, err := InvoiceAmount(payment.PR)
+	if err != nil {
+		return "", err
+	}
+	if !ok || invoiceAmount != amount {
+		return "", fmt.Errorf("lightning address returned an invoice for the wrong amount")
+	}
+
+	return payment.PR, nil
+}
+
+func getJSON(ctx context.Context, rawURL string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s returned %d", req.URL.Host, resp.StatusCode)
+	}
+
+	return json.Unmarshal(body, v)
+}
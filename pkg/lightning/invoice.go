@@ -0,0 +1,73 @@
+// Package lightning holds the small amount of Lightning Network plumbing the
+// bot needs for withdrawals: reading BOLT11 invoice amounts and resolving
+// Lightning addresses into invoices.
+package lightning
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// msatPerBTC is the number of millisatoshis in one bitcoin.
+const msatPerBTC = 100_000_000_000
+
+// IsInvoice reports whether s looks like a BOLT11 payment request.
+func IsInvoice(s string) bool {
+	s = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(s)), "lightning:")
+	// lnbc also covers regtest (lnbcrt), lntb covers signet (lntbs).
+	return strings.HasPrefix(s, "lnbc") || strings.HasPrefix(s, "lntb")
+}
+
+// InvoiceAmount returns the amount encoded in a BOLT11 invoice, in sats,
+// rounded up to a whole sat. ok is false for invoices that leave the amount to
+// the payer. Only the human-readable part is inspected; the signature is not
+// verified.
+func InvoiceAmount(invoice string) (sats int64, ok bool, err error) {
+	invoice = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(invoice)), "lightning:")
+	sep := strings.LastIndex(invoice, "1")
+	if !IsInvoice(invoice) || sep < 0 {
+		return 0, false, fmt.Errorf("not a BOLT11 invoice")
+	}
+
+	hrp := invoice[:sep]
+	for _, prefix := range []string{"lnbcrt", "lntbs", "lnbc", "lntb"} {
+		if strings.HasPrefix(hrp, prefix) {
+			hrp = strings.TrimPrefix(hrp, prefix)
+			break
+		}
+	}
+	if hrp == "" {
+		return 0, false, nil
+	}
+
+	// The amount is a BTC value followed by an optional multiplier, expressed
+	// here in millisatoshis per unit.
+	unit := int64(msatPerBTC)
+	digits := hrp
+	switch hrp[len(hrp)-1] {
+	case 'm':
+		unit, digits = msatPerBTC/1_000, hrp[:len(hrp)-1]
+	case 'u':
+		unit, digits = msatPerBTC/1_000_000, hrp[:len(hrp)-1]
+	case 'n':
+		unit, digits = msatPerBTC/1_000_000_000, hrp[:len(hrp)-1]
+	case 'p':
+		// Pico-bitcoin amounts must be whole millisatoshis.
+		value, err := strconv.ParseInt(hrp[:len(hrp)-1], 10, 64)
+		if err != nil || value%10 != 0 {
+			return 0, false, fmt.Errorf("invalid invoice amount %q", hrp)
+		}
+		return ceilSats(value / 10), true, nil
+	}
+
+	value, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || value <= 0 {
+		return 0, false, fmt.Errorf("invalid invoice amount %q", hrp)
+	}
+	return ceilSats(value * unit), true, nil
+}
+
+func ceilSats(msat int64) int64 {
+	return (msat + 999) / 1000
+}
@@ -2,12 +2,15 @@ package lnmarkets
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"os"
@@ -21,22 +24,89 @@ type Client struct {
 	Passphrase string
 	BaseURL    string
 	HTTPClient *http.Client
+	// MaxRetries bounds how many times a failed request is retried. GET
+	// requests are retried on network errors and 5xx responses; any request
+	// rejected with 429 is retried once the rate limit allows it.
+	MaxRetries int
+
+	limiter *tokenBucket
 }
 
+const (
+	retryBaseDelay = 250 * time.Millisecond
+	retryMaxDelay  = 5 * time.Second
+)
+
+// Order types accepted by TradeRequest.Type.
+const (
+	OrderTypeMarket = "m"
+	OrderTypeLimit  = "l"
+)
+
+// Trade sides accepted by TradeRequest.Side.
+const (
+	SideBuy  = "b"
+	SideSell = "s"
+)
+
+// TradeRequest opens a futures trade. Either Quantity (USD) or Margin (sats)
+// must be set; Price is required for limit orders only.
 type TradeRequest struct {
-	Type     string  `json:"type"`
-	Amount   float64 `json:"amount"`
-	Price    float64 `json:"price"`
-	Leverage float64 `json:"leverage"`
+	Type       string  `json:"type"`
+	Side       string  `json:"side"`
+	Quantity   float64 `json:"quantity,omitempty"`
+	Margin     int64   `json:"margin,omitempty"`
+	Leverage   float64 `json:"leverage"`
+	Price      float64 `json:"price,omitempty"`
+	TakeProfit float64 `json:"takeprofit,omitempty"`
+	StopLoss   float64 `json:"stoploss,omitempty"`
 }
 
+// TradeResponse is a futures trade as returned by LN Markets. Quantity and
+// prices are in USD; margin, fees and PL are in sats.
 type TradeResponse struct {
-	ID       string  `json:"id"`
-	Type     string  `json:"type"`
-	Amount   float64 `json:"amount"`
-	Price    float64 `json:"price"`
-	Leverage float64 `json:"leverage"`
-	Status   string  `json:"status"`
+	ID                string  `json:"id"`
+	UID               string  `json:"uid"`
+	Type              string  `json:"type"`
+	Side              string  `json:"side"`
+	Quantity          float64 `json:"quantity"`
+	Margin            float64 `json:"margin"`
+	Leverage          float64 `json:"leverage"`
+	Price             float64 `json:"price"`
+	EntryPrice        float64 `json:"entry_price"`
+	EntryMargin       float64 `json:"entry_margin"`
+	Liquidation       float64 `json:"liquidation"`
+	StopLoss          float64 `json:"stoploss"`
+	TakeProfit        float64 `json:"takeprofit"`
+	ExitPrice         float64 `json:"exit_price"`
+	PL                float64 `json:"pl"`
+	OpeningFee        float64 `json:"opening_fee"`
+	ClosingFee        float64 `json:"closing_fee"`
+	MaintenanceMargin float64 `json:"maintenance_margin"`
+	SumCarryFees      float64 `json:"sum_carry_fees"`
+	CreationTS        int64   `json:"creation_ts"`
+	MarketFilledTS    int64   `json:"market_filled_ts"`
+	ClosedTS          int64   `json:"closed_ts"`
+	LastUpdateTS      int64   `json:"last_update_ts"`
+	Open              bool    `json:"open"`
+	Running           bool    `json:"running"`
+	Canceled          bool    `json:"canceled"`
+	Closed            bool    `json:"closed"`
+}
+
+// Status summarizes the trade state flags as open, running, canceled or closed.
+func (t *TradeResponse) Status() string {
+	switch {
+	case t.Canceled:
+		return "canceled"
+	case t.Closed:
+		return "closed"
+	case t.Running:
+		return "running"
+	case t.Open:
+		return "open"
+	}
+	return "unknown"
 }
 
 type PriceData struct {
@@ -64,6 +134,8 @@ func NewClient(apiKey, secretKey, passphrase string, isTestnet bool) *Client {
 		HTTPClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
+		MaxRetries: 3,
+		limiter:    limiterFor(apiKey),
 	}
 }
 
@@ -83,7 +155,83 @@ func (c *Client) createSignature(timestamp, method, path, params string) string
 	return signature
 }
 
-func (c *Client) makeRequest(method, path string, data interface{}) ([]byte, error) {
+// makeRequest sends a signed request, retrying as described on MaxRetries.
+// Cancelling ctx aborts the in-flight request as well as any pending wait for
+// the rate limiter or a retry.
+func (c *Client) makeRequest(ctx context.Context, method, path string, data interface{}) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		if c.limiter != nil {
+			if err := c.limiter.wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		body, err := c.doRequest(ctx, method, path, data)
+		if err == nil {
+			return body, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		var apiErr *APIError
+		errors.As(err, &apiErr)
+		delay := retryDelay(attempt, apiErr)
+
+		if errors.Is(err, ErrRateLimited) && c.limiter != nil {
+			c.limiter.pause(delay)
+		}
+
+		if attempt >= c.MaxRetries || !retryable(method, err) {
+			return nil, err
+		}
+
+		if os.Getenv("DEBUG_LNMARKETS") == "true" {
+			fmt.Printf("DEBUG: Retrying %s %s in %v after error: %v\n", method, path, delay, err)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// retryable reports whether a failed request may be sent again. Requests
+// rejected by the rate limiter were never processed, so any method can be
+// retried; other failures are only retried for idempotent GET requests.
+func retryable(method string, err error) bool {
+	if errors.Is(err, ErrRateLimited) {
+		return true
+	}
+	if method != "GET" {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return errors.Is(err, ErrServer)
+	}
+	// Transport errors such as timeouts and connection resets.
+	return true
+}
+
+// retryDelay honours Retry-After and otherwise backs off exponentially with
+// full jitter.
+func retryDelay(attempt int, apiErr *APIError) time.Duration {
+	if apiErr != nil && apiErr.RetryAfter > 0 {
+		return apiErr.RetryAfter
+	}
+	backoff := retryBaseDelay << attempt
+	if backoff > retryMaxDelay || backoff <= 0 {
+		backoff = retryMaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(backoff)) + 1)
+}
+
+func (c *Client) doRequest(ctx context.Context, method, path string, data interface{}) ([]byte, error) {
 	timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
 
 	var params string
@@ -143,7 +291,7 @@ func (c *Client) makeRequest(method, path string, data interface{}) ([]byte, err
 	signaturePath := baseURL.Path + cleanPath
 	signature := c.createSignature(timestamp, method, signaturePath, params)
 
-	req, err := http.NewRequest(method, c.BaseURL+fullPath, body)
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+fullPath, body)
 	if err != nil {
 		return nil, err
 	}
@@ -183,14 +331,18 @@ func (c *Client) makeRequest(method, path string, data interface{}) ([]byte, err
 	}
 
 	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("API error: %s - %s", resp.Status, string(respBody))
+		return nil, newAPIError(resp, respBody)
 	}
 
 	return respBody, nil
 }
 
 func (c *Client) GetUser() (*UserData, error) {
-	resp, err := c.makeRequest("GET", "/user", nil)
+	return c.GetUserContext(context.Background())
+}
+
+func (c *Client) GetUserContext(ctx context.Context) (*UserData, error) {
+	resp, err := c.makeRequest(ctx, "GET", "/user", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -204,7 +356,11 @@ func (c *Client) GetUser() (*UserData, error) {
 }
 
 func (c *Client) CreateTrade(trade *TradeRequest) (*TradeResponse, error) {
-	resp, err := c.makeRequest("POST", "/futures/trade", trade)
+	return c.CreateTradeContext(context.Background(), trade)
+}
+
+func (c *Client) CreateTradeContext(ctx context.Context, trade *TradeRequest) (*TradeResponse, error) {
+	resp, err := c.makeRequest(ctx, "POST", "/futures", trade)
 	if err != nil {
 		return nil, err
 	}
@@ -218,7 +374,11 @@ func (c *Client) CreateTrade(trade *TradeRequest) (*TradeResponse, error) {
 }
 
 func (c *Client) GetTrades() ([]TradeResponse, error) {
-	resp, err := c.makeRequest("GET", "/futures/trades", nil)
+	return c.GetTradesContext(context.Background())
+}
+
+func (c *Client) GetTradesContext(ctx context.Context) ([]TradeResponse, error) {
+	resp, err := c.makeRequest(ctx, "GET", "/futures/trades", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -232,7 +392,11 @@ func (c *Client) GetTrades() ([]TradeResponse, error) {
 }
 
 func (c *Client) GetTrade(tradeID string) (*TradeResponse, error) {
-	resp, err := c.makeRequest("GET", "/futures/trade/"+tradeID, nil)
+	return c.GetTradeContext(context.Background(), tradeID)
+}
+
+func (c *Client) GetTradeContext(ctx context.Context, tradeID string) (*TradeResponse, error) {
+	resp, err := c.makeRequest(ctx, "GET", "/futures/trade/"+tradeID, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -246,12 +410,19 @@ func (c *Client) GetTrade(tradeID string) (*TradeResponse, error) {
 }
 
 func (c *Client) CloseTrade(tradeID string) error {
-	_, err := c.makeRequest("POST", "/futures/close", map[string]string{"id": tradeID})
-	return err
+	return c.ClosePosition(tradeID)
+}
+
+func (c *Client) CloseTradeContext(ctx context.Context, tradeID string) error {
+	return c.ClosePositionContext(ctx, tradeID)
 }
 
 func (c *Client) GetPrice() (*PriceData, error) {
-	resp, err := c.makeRequest("GET", "/oracle/index", nil)
+	return c.GetPriceContext(context.Background())
+}
+
+func (c *Client) GetPriceContext(ctx context.Context) (*PriceData, error) {
+	resp, err := c.makeRequest(ctx, "GET", "/oracle/index", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -265,7 +436,11 @@ func (c *Client) GetPrice() (*PriceData, error) {
 }
 
 func (c *Client) GetAccountBalance() (*UserData, error) {
-	resp, err := c.makeRequest("GET", "/user", nil)
+	return c.GetAccountBalanceContext(context.Background())
+}
+
+func (c *Client) GetAccountBalanceContext(ctx context.Context) (*UserData, error) {
+	resp, err := c.makeRequest(ctx, "GET", "/user", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -279,6 +454,10 @@ func (c *Client) GetAccountBalance() (*UserData, error) {
 }
 
 func (c *Client) GetPositions(positionType string) ([]TradeResponse, error) {
+	return c.GetPositionsContext(context.Background(), positionType)
+}
+
+func (c *Client) GetPositionsContext(ctx context.Context, positionType string) ([]TradeResponse, error) {
 	var path string
 	if positionType != "" {
 		path = "/futures?type=" + positionType
@@ -286,7 +465,7 @@ func (c *Client) GetPositions(positionType string) ([]TradeResponse, error) {
 		path = "/futures?type=running"
 	}
 
-	resp, err := c.makeRequest("GET", path, nil)
+	resp, err := c.makeRequest(ctx, "GET", path, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -300,7 +479,11 @@ func (c *Client) GetPositions(positionType string) ([]TradeResponse, error) {
 }
 
 func (c *Client) GetPosition(positionID string) (*TradeResponse, error) {
-	resp, err := c.makeRequest("GET", "/futures/trades/"+positionID, nil)
+	return c.GetPositionContext(context.Background(), positionID)
+}
+
+func (c *Client) GetPositionContext(ctx context.Context, positionID string) (*TradeResponse, error) {
+	resp, err := c.makeRequest(ctx, "GET", "/futures/trades/"+positionID, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -314,22 +497,125 @@ func (c *Client) GetPosition(positionID string) (*TradeResponse, error) {
 }
 
 func (c *Client) ClosePosition(positionID string) error {
-	_, err := c.makeRequest("POST", "/futures/close", map[string]string{"id": positionID})
+	return c.ClosePositionContext(context.Background(), positionID)
+}
+
+func (c *Client) ClosePositionContext(ctx context.Context, positionID string) error {
+	_, err := c.makeRequest(ctx, "DELETE", "/futures", map[string]interface{}{"id": positionID})
 	return err
 }
 
+// CloseAllPositions closes every running trade at market.
+func (c *Client) CloseAllPositions() ([]TradeResponse, error) {
+	return c.CloseAllPositionsContext(context.Background())
+}
+
+func (c *Client) CloseAllPositionsContext(ctx context.Context) ([]TradeResponse, error) {
+	return c.bulkTradeRequest(ctx, "DELETE", "/futures/all/close")
+}
+
+// CancelTrade cancels an open (not yet filled) limit order.
+func (c *Client) CancelTrade(tradeID string) (*TradeResponse, error) {
+	return c.CancelTradeContext(context.Background(), tradeID)
+}
+
+func (c *Client) CancelTradeContext(ctx context.Context, tradeID string) (*TradeResponse, error) {
+	resp, err := c.makeRequest(ctx, "POST", "/futures/cancel", map[string]interface{}{"id": tradeID})
+	if err != nil {
+		return nil, err
+	}
+
+	var trade TradeResponse
+	if err := json.Unmarshal(resp, &trade); err != nil {
+		return nil, err
+	}
+
+	return &trade, nil
+}
+
+// CancelAllTrades cancels every open limit order.
+func (c *Client) CancelAllTrades() ([]TradeResponse, error) {
+	return c.CancelAllTradesContext(context.Background())
+}
+
+func (c *Client) CancelAllTradesContext(ctx context.Context) ([]TradeResponse, error) {
+	return c.bulkTradeRequest(ctx, "DELETE", "/futures/all/cancel")
+}
+
+// AddMargin moves amount sats from the account balance into a running trade,
+// pushing its liquidation price further away.
+func (c *Client) AddMargin(positionID string, amount int64) (*TradeResponse, error) {
+	return c.AddMarginContext(context.Background(), positionID, amount)
+}
+
+func (c *Client) AddMarginContext(ctx context.Context, positionID string, amount int64) (*TradeResponse, error) {
+	return c.marginRequest(ctx, "/futures/add-margin", positionID, amount)
+}
+
+// CashIn withdraws amount sats of margin or profit from a running trade back
+// to the account balance.
+func (c *Client) CashIn(positionID string, amount int64) (*TradeResponse, error) {
+	return c.CashInContext(context.Background(), positionID, amount)
+}
+
+func (c *Client) CashInContext(ctx context.Context, positionID string, amount int64) (*TradeResponse, error) {
+	return c.marginRequest(ctx, "/futures/cash-in", positionID, amount)
+}
+
 func (c *Client) UpdateTakeProfit(positionID string, takeProfitPrice float64) error {
-	_, err := c.makeRequest("POST", "/futures/take-profit", map[string]interface{}{
-		"id":    positionID,
-		"price": takeProfitPrice,
-	})
-	return err
+	return c.UpdateTakeProfitContext(context.Background(), positionID, takeProfitPrice)
+}
+
+func (c *Client) UpdateTakeProfitContext(ctx context.Context, positionID string, takeProfitPrice float64) error {
+	return c.updateTrade(ctx, positionID, "takeprofit", takeProfitPrice)
 }
 
 func (c *Client) UpdateStopLoss(positionID string, stopLossPrice float64) error {
-	_, err := c.makeRequest("POST", "/futures/stop-loss", map[string]interface{}{
+	return c.UpdateStopLossContext(context.Background(), positionID, stopLossPrice)
+}
+
+func (c *Client) UpdateStopLossContext(ctx context.Context, positionID string, stopLossPrice float64) error {
+	return c.updateTrade(ctx, positionID, "stoploss", stopLossPrice)
+}
+
+func (c *Client) updateTrade(ctx context.Context, positionID, field string, value float64) error {
+	_, err := c.makeRequest(ctx, "PUT", "/futures", map[string]interface{}{
 		"id":    positionID,
-		"price": stopLossPrice,
+		"type":  field,
+		"value": value,
 	})
 	return err
 }
+
+func (c *Client) marginRequest(ctx context.Context, path, positionID string, amount int64) (*TradeResponse, error) {
+	resp, err := c.makeRequest(ctx, "POST", path, map[string]interface{}{
+		"id":     positionID,
+		"amount": amount,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var trade TradeResponse
+	if err := json.Unmarshal(resp, &trade); err != nil {
+		return nil, err
+	}
+
+	return &trade, nil
+}
+
+func (c *Client) bulkTradeRequest(ctx context.Context, method, path string) ([]TradeResponse, error) {
+	resp, err := c.makeRequest(ctx, method, path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Trades []TradeResponse `json:"trades"`
+	}
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return result.Trades, nil
+}
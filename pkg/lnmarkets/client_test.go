@@ -0,0 +1,179 @@
+package lnmarkets
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testClient returns a client for server with its own rate limit bucket.
+func testClient(t *testing.T, server *httptest.Server) *Client {
+	t.Helper()
+	client := NewClient("key-"+t.Name(), "secret", "passphrase", false)
+	client.BaseURL = server.URL
+	return client
+}
+
+// failingServer answers the first failures requests with status and the rest
+// with an empty JSON object, counting every request.
+func failingServer(t *testing.T, failures int32, status int, retryAfter string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= failures {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(status)
+			w.Write([]byte(`{"message":"try again"}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		failures int32
+		status   int
+		requests int32
+		err      error
+	}{
+		{"GET retried on server error", "GET", 2, http.StatusBadGateway, 3, nil},
+		{"GET gives up after MaxRetries", "GET", 10, http.StatusInternalServerError, 4, ErrServer},
+		{"POST not retried on server error", "POST", 1, http.StatusInternalServerError, 1, ErrServer},
+		{"POST retried when rate limited", "POST", 1, http.StatusTooManyRequests, 2, nil},
+		{"client errors not retried", "GET", 1, http.StatusBadRequest, 1, ErrBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := failingServer(t, tt.failures, tt.status, "")
+			client := testClient(t, server)
+
+			_, err := client.makeRequest(context.Background(), tt.method, "/futures", nil)
+			if tt.err == nil && err != nil {
+				t.Fatalf("makeRequest: %v", err)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Fatalf("makeRequest error = %v, want %v", err, tt.err)
+			}
+			if got := requests.Load(); got != tt.requests {
+				t.Errorf("requests = %d, want %d", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestRateLimitedHonoursRetryAfter(t *testing.T) {
+	server, requests := failingServer(t, 1, http.StatusTooManyRequests, "1")
+	client := testClient(t, server)
+
+	start := time.Now()
+	if _, err := client.makeRequest(context.Background(), "GET", "/futures", nil); err != nil {
+		t.Fatalf("makeRequest: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want at least the 1s Retry-After", elapsed)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("requests = %d, want 2", got)
+	}
+}
+
+func TestRateLimitedPausesSharedKey(t *testing.T) {
+	server, _ := failingServer(t, 1, http.StatusTooManyRequests, "2")
+	client := testClient(t, server)
+	client.MaxRetries = 0
+
+	_, err := client.makeRequest(context.Background(), "GET", "/futures", nil)
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("makeRequest error = %v, want ErrRateLimited", err)
+	}
+
+	// Another client with the same key must wait out the pause.
+	other := testClient(t, server)
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if _, err := other.makeRequest(ctx, "GET", "/futures", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("request during pause = %v, want deadline exceeded", err)
+	}
+}
+
+func TestPublicClientsDoNotShareLimiter(t *testing.T) {
+	if NewClient("", "", "", false).limiter == NewClient("", "", "", false).limiter {
+		t.Fatal("public clients share a rate limit bucket")
+	}
+	if NewClient("k", "", "", false).limiter != NewClient("k", "", "", true).limiter {
+		t.Fatal("clients with the same key do not share a rate limit bucket")
+	}
+}
+
+func TestRetryDelayJitter(t *testing.T) {
+	for attempt := 0; attempt < 8; attempt++ {
+		ceiling := min(retryBaseDelay<<attempt, retryMaxDelay)
+		for i := 0; i < 100; i++ {
+			delay := retryDelay(attempt, nil)
+			if delay <= 0 || delay > ceiling {
+				t.Fatalf("retryDelay(%d) = %v, want within (0, %v]", attempt, delay, ceiling)
+			}
+		}
+	}
+
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 20; i++ {
+		seen[retryDelay(3, nil)] = true
+	}
+	if len(seen) < 2 {
+		t.Error("retryDelay has no jitter")
+	}
+}
+
+func TestRetryDelayUsesRetryAfter(t *testing.T) {
+	apiErr := &APIError{StatusCode: http.StatusTooManyRequests, RetryAfter: 7 * time.Second}
+	if got := retryDelay(0, apiErr); got != 7*time.Second {
+		t.Fatalf("retryDelay = %v, want 7s", got)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		min   time.Duration
+		max   time.Duration
+	}{
+		{"", 0, 0},
+		{"3", 3 * time.Second, 3 * time.Second},
+		{"-1", 0, 0},
+		{"soon", 0, 0},
+		{time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat), 8 * time.Second, 10 * time.Second},
+		{time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0, 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value); got < tt.min || got > tt.max {
+			t.Errorf("parseRetryAfter(%q) = %v, want within [%v, %v]", tt.value, got, tt.min, tt.max)
+		}
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	bucket := newTokenBucket(10, 2)
+	if bucket.reserve() != 0 || bucket.reserve() != 0 {
+		t.Fatal("burst tokens not available")
+	}
+	if delay := bucket.reserve(); delay <= 0 || delay > 100*time.Millisecond {
+		t.Fatalf("reserve after burst = %v, want within (0, 100ms]", delay)
+	}
+
+	bucket.pause(time.Second)
+	if delay := bucket.reserve(); delay < 900*time.Millisecond {
+		t.Fatalf("reserve while paused = %v, want about 1s", delay)
+	}
+}
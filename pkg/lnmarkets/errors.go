@@ -0,0 +1,112 @@
+package lnmarkets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error kinds returned by the client. Use errors.Is to classify a failure and
+// errors.As with *APIError to inspect the exchange response.
+var (
+	ErrBadRequest        = errors.New("lnmarkets: bad request")
+	ErrUnauthorized      = errors.New("lnmarkets: unauthorized")
+	ErrForbidden         = errors.New("lnmarkets: forbidden")
+	ErrNotFound          = errors.New("lnmarkets: not found")
+	ErrInsufficientFunds = errors.New("lnmarkets: insufficient balance or margin")
+	ErrRateLimited       = errors.New("lnmarkets: rate limited")
+	ErrServer            = errors.New("lnmarkets: server error")
+)
+
+// APIError is returned for every LN Markets response with a status >= 400.
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Body       string
+	// RetryAfter is the delay requested by the exchange, if any.
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("API error: %d %s - %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+	}
+	return fmt.Sprintf("API error: %d %s - %s", e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
+// Unwrap exposes the error kind so callers can use errors.Is.
+func (e *APIError) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case e.StatusCode >= 500:
+		return ErrServer
+	case e.StatusCode == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case e.StatusCode == http.StatusForbidden:
+		return ErrForbidden
+	case e.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case isInsufficientFunds(e.Code, e.Message):
+		return ErrInsufficientFunds
+	default:
+		return ErrBadRequest
+	}
+}
+
+// newAPIError parses the LN Markets error body, which carries a message and
+// optionally a machine-readable code.
+func newAPIError(resp *http.Response, body []byte) *APIError {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Body:       string(body),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+
+	var payload struct {
+		Code    interface{} `json:"code"`
+		Message string      `json:"message"`
+		Error   string      `json:"error"`
+	}
+	if err := json.Unmarshal(body, &payload); err == nil {
+		apiErr.Message = payload.Message
+		if apiErr.Message == "" {
+			apiErr.Message = payload.Error
+		}
+		if payload.Code != nil {
+			apiErr.Code = fmt.Sprintf("%v", payload.Code)
+		}
+	}
+
+	return apiErr
+}
+
+func isInsufficientFunds(code, message string) bool {
+	text := strings.ToLower(code + " " + message)
+	for _, hint := range []string{"insufficient", "not enough", "balance too low", "margin too low"} {
+		if strings.Contains(text, hint) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
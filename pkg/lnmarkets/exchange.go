@@ -0,0 +1,26 @@
+package lnmarkets
+
+import "context"
+
+// Exchange is the set of futures operations the trading bot depends on.
+// *Client implements it against the LN Markets API; other implementations,
+// such as the in-process simulator, can be swapped in for local runs.
+//
+// Every operation takes a context so callers can abandon slow requests, e.g.
+// when an HTTP client disconnects or a bot is stopped.
+type Exchange interface {
+	CreateTradeContext(ctx context.Context, trade *TradeRequest) (*TradeResponse, error)
+	ClosePositionContext(ctx context.Context, positionID string) error
+	CloseAllPositionsContext(ctx context.Context) ([]TradeResponse, error)
+	CancelTradeContext(ctx context.Context, tradeID string) (*TradeResponse, error)
+	CancelAllTradesContext(ctx context.Context) ([]TradeResponse, error)
+	AddMarginContext(ctx context.Context, positionID string, amount int64) (*TradeResponse, error)
+	CashInContext(ctx context.Context, positionID string, amount int64) (*TradeResponse, error)
+	GetPositionsContext(ctx context.Context, positionType string) ([]TradeResponse, error)
+	GetPositionContext(ctx context.Context, positionID string) (*TradeResponse, error)
+	GetAccountBalanceContext(ctx context.Context) (*UserData, error)
+	UpdateTakeProfitContext(ctx context.Context, positionID string, takeProfitPrice float64) error
+	UpdateStopLossContext(ctx context.Context, positionID string, stopLossPrice float64) error
+}
+
+var _ Exchange = (*Client)(nil)
@@ -0,0 +1,173 @@
+package lnmarkets
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+)
+
+// Ticker is the current futures quote. CarryFeeRate is charged on the
+// position notional at every carry fee fixing.
+type Ticker struct {
+	Index             float64 `json:"index"`
+	LastPrice         float64 `json:"lastPrice"`
+	BidPrice          float64 `json:"bidPrice"`
+	AskPrice          float64 `json:"askPrice"`
+	CarryFeeRate      float64 `json:"carryFeeRate"`
+	CarryFeeTimestamp int64   `json:"carryFeeTimestamp"`
+}
+
+// PricePoint is a single value of the index or last-price history.
+type PricePoint struct {
+	Time  int64   `json:"time"`
+	Value float64 `json:"value"`
+}
+
+// OHLC is a futures price candle. Time is the opening time in milliseconds.
+type OHLC struct {
+	Time   int64   `json:"time"`
+	Open   float64 `json:"open"`
+	High   float64 `json:"high"`
+	Low    float64 `json:"low"`
+	Close  float64 `json:"close"`
+	Volume float64 `json:"volume"`
+}
+
+// CarryFee is a carry fee fixing. Rate is the fee rate applied at Time.
+type CarryFee struct {
+	ID       string  `json:"id"`
+	FixingID string  `json:"fixing_id"`
+	Rate     float64 `json:"fee_rate"`
+	Price    float64 `json:"price"`
+	Time     int64   `json:"time"`
+}
+
+// LeaderboardEntry is a trader's ranking by realized PL (sats).
+type LeaderboardEntry struct {
+	Username  string  `json:"username"`
+	PL        float64 `json:"pl"`
+	Direction int     `json:"direction"`
+}
+
+// Leaderboard ranks traders over several periods.
+type Leaderboard struct {
+	Daily   []LeaderboardEntry `json:"daily"`
+	Weekly  []LeaderboardEntry `json:"weekly"`
+	Monthly []LeaderboardEntry `json:"monthly"`
+	AllTime []LeaderboardEntry `json:"all-time"`
+}
+
+// HistoryRange bounds a history request. From and To are Unix milliseconds;
+// zero values are left to the exchange defaults.
+type HistoryRange struct {
+	From  int64
+	To    int64
+	Limit int
+}
+
+func (h HistoryRange) query(values url.Values) string {
+	if h.From > 0 {
+		values.Set("from", strconv.FormatInt(h.From, 10))
+	}
+	if h.To > 0 {
+		values.Set("to", strconv.FormatInt(h.To, 10))
+	}
+	if h.Limit > 0 {
+		values.Set("limit", strconv.Itoa(h.Limit))
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	return "?" + values.Encode()
+}
+
+func (c *Client) GetTicker() (*Ticker, error) {
+	return c.GetTickerContext(context.Background())
+}
+
+func (c *Client) GetTickerContext(ctx context.Context) (*Ticker, error) {
+	var ticker Ticker
+	if err := c.getJSON(ctx, "/futures/ticker", &ticker); err != nil {
+		return nil, err
+	}
+	return &ticker, nil
+}
+
+func (c *Client) GetIndexHistory(r HistoryRange) ([]PricePoint, error) {
+	return c.GetIndexHistoryContext(context.Background(), r)
+}
+
+func (c *Client) GetIndexHistoryContext(ctx context.Context, r HistoryRange) ([]PricePoint, error) {
+	var history []struct {
+		Time  int64   `json:"time"`
+		Index float64 `json:"index"`
+	}
+	if err := c.getJSON(ctx, "/futures/history/index"+r.query(url.Values{}), &history); err != nil {
+		return nil, err
+	}
+
+	points := make([]PricePoint, 0, len(history))
+	for _, h := range history {
+		points = append(points, PricePoint{Time: h.Time, Value: h.Index})
+	}
+	return points, nil
+}
+
+func (c *Client) GetPriceHistory(r HistoryRange) ([]PricePoint, error) {
+	return c.GetPriceHistoryContext(context.Background(), r)
+}
+
+func (c *Client) GetPriceHistoryContext(ctx context.Context, r HistoryRange) ([]PricePoint, error) {
+	var points []PricePoint
+	if err := c.getJSON(ctx, "/futures/history/price"+r.query(url.Values{}), &points); err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+func (c *Client) GetOHLC(interval string, r HistoryRange) ([]OHLC, error) {
+	return c.GetOHLCContext(context.Background(), interval, r)
+}
+
+// GetOHLCContext returns futures price candles. interval is one of the
+// exchange ranges, e.g. 1m, 5m, 1h or 1d.
+func (c *Client) GetOHLCContext(ctx context.Context, interval string, r HistoryRange) ([]OHLC, error) {
+	var candles []OHLC
+	if err := c.getJSON(ctx, "/futures/ohlcs"+r.query(url.Values{"range": {interval}}), &candles); err != nil {
+		return nil, err
+	}
+	return candles, nil
+}
+
+func (c *Client) GetCarryFees(r HistoryRange) ([]CarryFee, error) {
+	return c.GetCarryFeesContext(context.Background(), r)
+}
+
+func (c *Client) GetCarryFeesContext(ctx context.Context, r HistoryRange) ([]CarryFee, error) {
+	var fees []CarryFee
+	if err := c.getJSON(ctx, "/futures/carry-fees"+r.query(url.Values{}), &fees); err != nil {
+		return nil, err
+	}
+	return fees, nil
+}
+
+func (c *Client) GetLeaderboard() (*Leaderboard, error) {
+	return c.GetLeaderboardContext(context.Background())
+}
+
+func (c *Client) GetLeaderboardContext(ctx context.Context) (*Leaderboard, error) {
+	var leaderboard Leaderboard
+	if err := c.getJSON(ctx, "/futures/leaderboard", &leaderboard); err != nil {
+		return nil, err
+	}
+	return &leaderboard, nil
+}
+
+func (c *Client) getJSON(ctx context.Context, path string, v interface{}) error {
+	resp, err := c.makeRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(resp, v)
+}
@@ -0,0 +1,228 @@
+package lnmarkets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Option types as encoded in instrument names and OptionTrade.Type.
+const (
+	OptionTypeCall = "c"
+	OptionTypePut  = "p"
+)
+
+// Option settlement styles accepted by OptionTradeRequest.Settlement.
+const (
+	SettlementCash     = "cash"
+	SettlementPhysical = "physical"
+)
+
+// OptionsExchange is implemented by exchanges that also trade options. It is
+// kept apart from Exchange because the simulator has no options book.
+type OptionsExchange interface {
+	GetOptionInstrumentsContext(ctx context.Context) ([]string, error)
+	GetOptionInstrumentContext(ctx context.Context, name string) (*OptionInstrument, error)
+	CreateOptionTradeContext(ctx context.Context, trade *OptionTradeRequest) (*OptionTrade, error)
+	CloseOptionTradeContext(ctx context.Context, tradeID string) (*OptionTrade, error)
+	GetOptionTradesContext(ctx context.Context, status string) ([]OptionTrade, error)
+}
+
+var _ OptionsExchange = (*Client)(nil)
+
+// OptionInstrument describes a listed option. Name has the form
+// BTC.<expiry date>.<strike>.<C|P>, e.g. BTC.2024-03-29.60000.P.
+type OptionInstrument struct {
+	Name       string    `json:"name"`
+	Type       string    `json:"type"`
+	Strike     float64   `json:"strike"`
+	Expiry     time.Time `json:"expiry"`
+	Volatility float64   `json:"volatility,omitempty"`
+}
+
+// ParseInstrument decodes the type, strike and expiry from an instrument name.
+func ParseInstrument(name string) (*OptionInstrument, error) {
+	parts := strings.Split(name, ".")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("invalid instrument name %q", name)
+	}
+
+	expiry, err := time.Parse("2006-01-02", parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid instrument expiry %q", parts[1])
+	}
+	// Options expire at 08:00 UTC on the expiry date.
+	expiry = expiry.Add(8 * time.Hour)
+
+	strike, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid instrument strike %q", parts[2])
+	}
+
+	optionType := strings.ToLower(parts[3])
+	if optionType != OptionTypeCall && optionType != OptionTypePut {
+		return nil, fmt.Errorf("invalid instrument type %q", parts[3])
+	}
+
+	return &OptionInstrument{Name: name, Type: optionType, Strike: strike, Expiry: expiry}, nil
+}
+
+// OptionTradeRequest buys an option. Quantity is in USD.
+type OptionTradeRequest struct {
+	Side           string  `json:"side"`
+	Quantity       float64 `json:"quantity"`
+	Settlement     string  `json:"settlement"`
+	InstrumentName string  `json:"instrument_name"`
+}
+
+// OptionTrade is an options position as returned by LN Markets. Quantity,
+// strike and forward are in USD; margin, premium, fees and PL are in sats.
+type OptionTrade struct {
+	ID                string  `json:"id"`
+	UID               string  `json:"uid"`
+	Type              string  `json:"type"`
+	Side              string  `json:"side"`
+	Quantity          float64 `json:"quantity"`
+	Margin            float64 `json:"margin"`
+	Strike            float64 `json:"strike"`
+	Forward           float64 `json:"forward"`
+	Premium           float64 `json:"premium"`
+	Volatility        float64 `json:"volatility"`
+	Settlement        string  `json:"settlement"`
+	InstrumentName    string  `json:"instrument_name"`
+	PL                float64 `json:"pl"`
+	OpeningFee        float64 `json:"opening_fee"`
+	ClosingFee        float64 `json:"closing_fee"`
+	MaintenanceMargin float64 `json:"maintenance_margin"`
+	FixingID          string  `json:"fixing_id"`
+	CreationTS        int64   `json:"creation_ts"`
+	ClosedTS          int64   `json:"closed_ts"`
+	ExpiryTS          int64   `json:"expiry_ts"`
+	Running           bool    `json:"running"`
+	Closed            bool    `json:"closed"`
+	Expired           bool    `json:"expired"`
+}
+
+// Status summarizes the trade state flags as running, expired or closed.
+func (t *OptionTrade) Status() string {
+	switch {
+	case t.Expired:
+		return "expired"
+	case t.Closed:
+		return "closed"
+	case t.Running:
+		return "running"
+	}
+	return "unknown"
+}
+
+func (c *Client) GetOptionInstruments() ([]string, error) {
+	return c.GetOptionInstrumentsContext(context.Background())
+}
+
+func (c *Client) GetOptionInstrumentsContext(ctx context.Context) ([]string, error) {
+	resp, err := c.makeRequest(ctx, "GET", "/options/instruments", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var instruments []string
+	if err := json.Unmarshal(resp, &instruments); err != nil {
+		return nil, err
+	}
+
+	return instruments, nil
+}
+
+func (c *Client) GetOptionInstrument(name string) (*OptionInstrument, error) {
+	return c.GetOptionInstrumentContext(context.Background(), name)
+}
+
+// GetOptionInstrumentContext returns the instrument with its current implied
+// volatility.
+func (c *Client) GetOptionInstrumentContext(ctx context.Context, name string) (*OptionInstrument, error) {
+	instrument, err := ParseInstrument(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadRequest, err)
+	}
+
+	resp, err := c.makeRequest(ctx, "GET", "/options/instrument?instrument_name="+url.QueryEscape(name), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var details struct {
+		Volatility float64 `json:"volatility"`
+	}
+	if err := json.Unmarshal(resp, &details); err != nil {
+		return nil, err
+	}
+	instrument.Volatility = details.Volatility
+
+	return instrument, nil
+}
+
+func (c *Client) CreateOptionTrade(trade *OptionTradeRequest) (*OptionTrade, error) {
+	return c.CreateOptionTradeContext(context.Background(), trade)
+}
+
+func (c *Client) CreateOptionTradeContext(ctx context.Context, trade *OptionTradeRequest) (*OptionTrade, error) {
+	resp, err := c.makeRequest(ctx, "POST", "/options", trade)
+	if err != nil {
+		return nil, err
+	}
+
+	var option OptionTrade
+	if err := json.Unmarshal(resp, &option); err != nil {
+		return nil, err
+	}
+
+	return &option, nil
+}
+
+func (c *Client) CloseOptionTrade(tradeID string) (*OptionTrade, error) {
+	return c.CloseOptionTradeContext(context.Background(), tradeID)
+}
+
+// CloseOptionTradeContext sells a running option back before expiry.
+func (c *Client) CloseOptionTradeContext(ctx context.Context, tradeID string) (*OptionTrade, error) {
+	resp, err := c.makeRequest(ctx, "DELETE", "/options", map[string]interface{}{"id": tradeID})
+	if err != nil {
+		return nil, err
+	}
+
+	var option OptionTrade
+	if err := json.Unmarshal(resp, &option); err != nil {
+		return nil, err
+	}
+
+	return &option, nil
+}
+
+func (c *Client) GetOptionTrades(status string) ([]OptionTrade, error) {
+	return c.GetOptionTradesContext(context.Background(), status)
+}
+
+// GetOptionTradesContext lists option trades by status: running (the
+// default) or closed, which includes options settled at expiry.
+func (c *Client) GetOptionTradesContext(ctx context.Context, status string) ([]OptionTrade, error) {
+	if status == "" {
+		status = "running"
+	}
+
+	resp, err := c.makeRequest(ctx, "GET", "/options?status="+status, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var options []OptionTrade
+	if err := json.Unmarshal(resp, &options); err != nil {
+		return nil, err
+	}
+
+	return options, nil
+}
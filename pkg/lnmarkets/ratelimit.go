@@ -0,0 +1,112 @@
+package lnmarkets
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Default client-side limits, shared by every Client using the same API key.
+// Clients without a key only call public endpoints and get a bucket each.
+const (
+	DefaultRateLimit = 2.0 // requests per second
+	DefaultRateBurst = 5
+)
+
+// tokenBucket is a token-bucket limiter. A 429 from the exchange pauses the
+// bucket so every caller sharing the key backs off together.
+type tokenBucket struct {
+	mu          sync.Mutex
+	rate        float64
+	burst       float64
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+var limiters = struct {
+	sync.Mutex
+	byKey map[string]*tokenBucket
+}{byKey: make(map[string]*tokenBucket)}
+
+// limiterFor returns the limiter shared by all clients using apiKey. Public
+// clients, with no key, are not throttled by one another: price sources,
+// market data and the divergence monitor each poll through their own client.
+func limiterFor(apiKey string) *tokenBucket {
+	if apiKey == "" {
+		return newTokenBucket(DefaultRateLimit, DefaultRateBurst)
+	}
+
+	limiters.Lock()
+	defer limiters.Unlock()
+
+	bucket, ok := limiters.byKey[apiKey]
+	if !ok {
+		bucket = newTokenBucket(DefaultRateLimit, DefaultRateBurst)
+		limiters.byKey[apiKey] = bucket
+	}
+	return bucket
+}
+
+// wait blocks until a token is available or ctx is done.
+func (b *tokenBucket) wait(ctx context.Context) error {
+	for {
+		delay := b.reserve()
+		if delay <= 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// reserve takes a token if one is available and otherwise returns how long
+// to wait before trying again.
+func (b *tokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(b.pausedUntil) {
+		return b.pausedUntil.Sub(now)
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// pause stops handing out tokens for d.
+func (b *tokenBucket) pause(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if until := time.Now().Add(d); until.After(b.pausedUntil) {
+		b.pausedUntil = until
+		b.tokens = 0
+		b.last = until
+	}
+}
@@ -0,0 +1,106 @@
+package lnmarkets
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// DepositInvoice is a Lightning invoice that credits the account once paid.
+type DepositInvoice struct {
+	DepositID      string `json:"depositId"`
+	PaymentRequest string `json:"paymentRequest"`
+	Expiry         int64  `json:"expiry"`
+}
+
+// WithdrawalResult is the outcome of paying a withdrawal invoice. Amount and
+// Fee are in sats.
+type WithdrawalResult struct {
+	ID          string `json:"id"`
+	PaymentHash string `json:"paymentHash"`
+	Amount      int64  `json:"amount"`
+	Fee         int64  `json:"fee"`
+	SuccessTime int64  `json:"successTime"`
+}
+
+// Transfer is an entry of the account deposit or withdrawal history.
+type Transfer struct {
+	ID          string `json:"id"`
+	UID         string `json:"uid"`
+	Type        string `json:"type"`
+	Amount      int64  `json:"amount"`
+	Fee         int64  `json:"fee"`
+	PaymentHash string `json:"payment_hash"`
+	Success     bool   `json:"success"`
+	TS          int64  `json:"ts"`
+}
+
+// Deposit creates a Lightning invoice of amount sats that credits the account.
+func (c *Client) Deposit(amount int64) (*DepositInvoice, error) {
+	return c.DepositContext(context.Background(), amount)
+}
+
+func (c *Client) DepositContext(ctx context.Context, amount int64) (*DepositInvoice, error) {
+	resp, err := c.makeRequest(ctx, "POST", "/user/deposit", map[string]interface{}{"amount": amount})
+	if err != nil {
+		return nil, err
+	}
+
+	var invoice DepositInvoice
+	if err := json.Unmarshal(resp, &invoice); err != nil {
+		return nil, err
+	}
+
+	return &invoice, nil
+}
+
+// Withdraw pays amount sats from the account balance to a BOLT11 invoice.
+func (c *Client) Withdraw(amount int64, invoice string) (*WithdrawalResult, error) {
+	return c.WithdrawContext(context.Background(), amount, invoice)
+}
+
+func (c *Client) WithdrawContext(ctx context.Context, amount int64, invoice string) (*WithdrawalResult, error) {
+	resp, err := c.makeRequest(ctx, "POST", "/user/withdraw", map[string]interface{}{
+		"amount":  amount,
+		"invoice": invoice,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result WithdrawalResult
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (c *Client) GetDeposits() ([]Transfer, error) {
+	return c.GetDepositsContext(context.Background())
+}
+
+func (c *Client) GetDepositsContext(ctx context.Context) ([]Transfer, error) {
+	return c.transferHistory(ctx, "/user/deposits")
+}
+
+func (c *Client) GetWithdrawals() ([]Transfer, error) {
+	return c.GetWithdrawalsContext(context.Background())
+}
+
+func (c *Client) GetWithdrawalsContext(ctx context.Context) ([]Transfer, error) {
+	return c.transferHistory(ctx, "/user/withdrawals")
+}
+
+func (c *Client) transferHistory(ctx context.Context, path string) ([]Transfer, error) {
+	resp, err := c.makeRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var transfers []Transfer
+	if err := json.Unmarshal(resp, &transfers); err != nil {
+		return nil, err
+	}
+
+	return transfers, nil
+}
@@ -0,0 +1,131 @@
+// Package pricing implements the LN Markets inverse BTC futures formulas.
+// Quantity is the position notional in USD and prices are in USD per BTC,
+// while margin, fees and PnL are settled in sats.
+package pricing
+
+import (
+	"math"
+
+	"btc-trading-bot/pkg/lnmarkets"
+)
+
+const SatsPerBTC = 100_000_000
+
+// IsLong reports whether side is a long, accepting LN Markets sides as well
+// as "buy" and "long".
+func IsLong(side string) bool {
+	switch side {
+	case lnmarkets.SideBuy, "buy", "long":
+		return true
+	}
+	return false
+}
+
+// Notional is the value in sats of quantity USD at price.
+func Notional(quantity, price float64) float64 {
+	if price <= 0 {
+		return 0
+	}
+	return quantity / price * SatsPerBTC
+}
+
+// Margin is the sats needed to open quantity USD at price with leverage.
+func Margin(quantity, price, leverage float64) float64 {
+	if leverage <= 0 {
+		return 0
+	}
+	return Notional(quantity, price) / leverage
+}
+
+// Quantity is the USD notional margin sats open at price with leverage.
+func Quantity(margin, price, leverage float64) float64 {
+	return margin * leverage * price / SatsPerBTC
+}
+
+// Leverage is the effective leverage of quantity USD entered at price and
+// backed by margin sats.
+func Leverage(quantity, price, margin float64) float64 {
+	if margin <= 0 {
+		return 0
+	}
+	return Notional(quantity, price) / margin
+}
+
+// Liquidation is the price at which the loss of a position equals its
+// margin. A long liquidates where 1/price exceeds 1/entry by
+// margin/quantity, a short where it falls short by as much. It returns zero
+// for a short collateralized past any price rise.
+//
+// Fees and maintenance margin are not taken into account: the whole margin
+// is assumed to absorb losses. An exchange that reserves part of the margin
+// for the closing fee or a maintenance requirement liquidates slightly
+// closer to entry, so pass the margin net of those for a conservative price.
+func Liquidation(side string, entryPrice, quantity, margin float64) float64 {
+	if quantity <= 0 || entryPrice <= 0 {
+		return 0
+	}
+	offset := margin / (quantity * SatsPerBTC)
+	if IsLong(side) {
+		return 1 / (1/entryPrice + offset)
+	}
+	if 1/entryPrice-offset <= 0 {
+		return 0
+	}
+	return 1 / (1/entryPrice - offset)
+}
+
+// PL is the profit in sats of a position of quantity USD entered at
+// entryPrice and closed at exitPrice, before fees.
+func PL(side string, quantity, entryPrice, exitPrice float64) float64 {
+	if entryPrice <= 0 || exitPrice <= 0 {
+		return 0
+	}
+	pl := quantity * (1/entryPrice - 1/exitPrice) * SatsPerBTC
+	if !IsLong(side) {
+		return -pl
+	}
+	return pl
+}
+
+// Fee is the trading fee in sats charged at rate, e.g. 0.001 for 0.1%, for
+// opening or closing quantity USD at price.
+func Fee(quantity, price, rate float64) float64 {
+	return Notional(quantity, price) * rate
+}
+
+// CarryFee is the sats a position pays at a carry fee fixing with rate at
+// price. A positive rate is paid by longs to shorts, so shorts get a negative
+// fee; a negative rate is paid the other way round.
+func CarryFee(side string, quantity, price, rate float64) float64 {
+	fee := Notional(quantity, price) * rate
+	if !IsLong(side) {
+		return -fee
+	}
+	return fee
+}
+
+// MarginForLiquidation returns the sats of margin that move a position's
+// liquidation price from liquidation to target, or zero when target is not
+// further from the price than liquidation.
+func MarginForLiquidation(side string, quantity, liquidation, target float64) int64 {
+	if quantity <= 0 || liquidation <= 0 || target <= 0 {
+		return 0
+	}
+	delta := 1/target - 1/liquidation
+	if !IsLong(side) {
+		delta = -delta
+	}
+	if delta <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(delta * quantity * SatsPerBTC))
+}
+
+// TargetPrice is price moved percent in the position's favour: up for a
+// long, down for a short. A negative percent moves it against the position.
+func TargetPrice(side string, price, percent float64) float64 {
+	if IsLong(side) {
+		return price * (1 + percent/100)
+	}
+	return price * (1 - percent/100)
+}
@@ -0,0 +1,170 @@
+package pricing
+
+import (
+	"math"
+	"testing"
+
+	"btc-trading-bot/pkg/lnmarkets"
+)
+
+// The examples trade 1000 USD at 10x from $50,000: a notional of 2,000,000
+// sats and a margin of 200,000 sats, worked through the LN Markets inverse
+// futures formulas.
+
+func assertClose(t *testing.T, name string, got, want float64) {
+	t.Helper()
+	if math.Abs(got-want) > 0.01 {
+		t.Errorf("%s = %.4f, want %.4f", name, got, want)
+	}
+}
+
+func TestMarginAndQuantity(t *testing.T) {
+	tests := []struct {
+		quantity, price, leverage, margin float64
+	}{
+		{1000, 50_000, 10, 200_000},
+		{1000, 50_000, 1, 2_000_000},
+		{500, 25_000, 20, 100_000},
+		{1000, 50_000, 0, 0},
+	}
+	for _, tt := range tests {
+		assertClose(t, "Margin", Margin(tt.quantity, tt.price, tt.leverage), tt.margin)
+		if tt.leverage > 0 {
+			assertClose(t, "Quantity", Quantity(tt.margin, tt.price, tt.leverage), tt.quantity)
+			assertClose(t, "Leverage", Leverage(tt.quantity, tt.price, tt.margin), tt.leverage)
+		}
+	}
+}
+
+func TestLiquidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		side     string
+		quantity float64
+		margin   float64
+		want     float64
+	}{
+		{"long 10x", lnmarkets.SideBuy, 1000, 200_000, 45_454.55},
+		{"short 10x", lnmarkets.SideSell, 1000, 200_000, 55_555.56},
+		{"long 5x", lnmarkets.SideBuy, 1000, 400_000, 41_666.67},
+		{"short 5x", lnmarkets.SideSell, 1000, 400_000, 62_500},
+		{"long 1x", "long", 1000, 2_000_000, 25_000},
+		{"short 1x", "short", 1000, 2_000_000, 0},
+		{"no quantity", lnmarkets.SideBuy, 0, 200_000, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertClose(t, "Liquidation", Liquidation(tt.side, 50_000, tt.quantity, tt.margin), tt.want)
+		})
+	}
+}
+
+func TestPL(t *testing.T) {
+	tests := []struct {
+		name string
+		side string
+		exit float64
+		want float64
+	}{
+		{"long up", lnmarkets.SideBuy, 55_000, 181_818.18},
+		{"long down", lnmarkets.SideBuy, 45_000, -222_222.22},
+		{"short up", lnmarkets.SideSell, 55_000, -181_818.18},
+		{"short down", lnmarkets.SideSell, 45_000, 222_222.22},
+		{"flat", lnmarkets.SideBuy, 50_000, 0},
+		{"no exit", lnmarkets.SideBuy, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertClose(t, "PL", PL(tt.side, 1000, 50_000, tt.exit), tt.want)
+		})
+	}
+}
+
+// A position losing its whole margin is exactly at its liquidation price.
+func TestPLAtLiquidation(t *testing.T) {
+	for _, side := range []string{lnmarkets.SideBuy, lnmarkets.SideSell} {
+		liquidation := Liquidation(side, 50_000, 1000, 200_000)
+		assertClose(t, side+" PL at liquidation", PL(side, 1000, 50_000, liquidation), -200_000)
+	}
+}
+
+func TestMarginForLiquidation(t *testing.T) {
+	tests := []struct {
+		name        string
+		side        string
+		liquidation float64
+		target      float64
+		want        int64
+	}{
+		{"long further", lnmarkets.SideBuy, 50_000, 40_000, 500_000},
+		{"long closer", lnmarkets.SideBuy, 40_000, 50_000, 0},
+		{"short further", lnmarkets.SideSell, 50_000, 62_500, 400_000},
+		{"short closer", lnmarkets.SideSell, 62_500, 50_000, 0},
+		{"no target", lnmarkets.SideBuy, 50_000, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := MarginForLiquidation(tt.side, 1000, tt.liquidation, tt.target)
+			// Rounding up may add a sat to an exact result.
+			if got < tt.want || got > tt.want+1 {
+				t.Errorf("MarginForLiquidation = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// Adding the margin for a target to the 10x example moves its liquidation
+// there.
+func TestMarginForLiquidationReachesTarget(t *testing.T) {
+	tests := []struct {
+		side   string
+		target float64
+	}{
+		{lnmarkets.SideBuy, 41_666.67},
+		{lnmarkets.SideSell, 62_500},
+	}
+	for _, tt := range tests {
+		liquidation := Liquidation(tt.side, 50_000, 1000, 200_000)
+		added := MarginForLiquidation(tt.side, 1000, liquidation, tt.target)
+		if added < 200_000 || added > 200_001 {
+			t.Errorf("%s margin added = %d, want 200000", tt.side, added)
+		}
+		moved := Liquidation(tt.side, 50_000, 1000, 200_000+float64(added))
+		if math.Abs(moved-tt.target) > 0.05 {
+			t.Errorf("%s liquidation after adding %d sats = %.2f, want %.2f", tt.side, added, moved, tt.target)
+		}
+	}
+}
+
+func TestTargetPrice(t *testing.T) {
+	tests := []struct {
+		side    string
+		percent float64
+		want    float64
+	}{
+		{lnmarkets.SideBuy, 10, 55_000},
+		{lnmarkets.SideBuy, -10, 45_000},
+		{lnmarkets.SideSell, 10, 45_000},
+		{lnmarkets.SideSell, -10, 55_000},
+	}
+	for _, tt := range tests {
+		assertClose(t, tt.side+" TargetPrice", TargetPrice(tt.side, 50_000, tt.percent), tt.want)
+	}
+}
+
+func TestCarryFee(t *testing.T) {
+	tests := []struct {
+		side string
+		rate float64
+		want float64
+	}{
+		{lnmarkets.SideBuy, 0.0001, 200},
+		{lnmarkets.SideSell, 0.0001, -200},
+		{lnmarkets.SideBuy, -0.0001, -200},
+		{lnmarkets.SideSell, -0.0001, 200},
+	}
+	for _, tt := range tests {
+		assertClose(t, tt.side+" CarryFee", CarryFee(tt.side, 1000, 50_000, tt.rate), tt.want)
+	}
+	assertClose(t, "Fee", Fee(1000, 50_000, 0.001), 2_000)
+}
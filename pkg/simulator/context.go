@@ -0,0 +1,95 @@
+package simulator
+
+import (
+	"context"
+
+	"btc-trading-bot/pkg/lnmarkets"
+)
+
+// The simulator answers synchronously, so the context variants only refuse to
+// start work once ctx is done. An operation that has started always completes,
+// mirroring an exchange that received the request before the caller gave up.
+
+func (e *Exchange) CreateTradeContext(ctx context.Context, trade *lnmarkets.TradeRequest) (*lnmarkets.TradeResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return e.CreateTrade(trade)
+}
+
+func (e *Exchange) ClosePositionContext(ctx context.Context, positionID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return e.ClosePosition(positionID)
+}
+
+func (e *Exchange) CloseAllPositionsContext(ctx context.Context) ([]lnmarkets.TradeResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return e.CloseAllPositions()
+}
+
+func (e *Exchange) CancelTradeContext(ctx context.Context, tradeID string) (*lnmarkets.TradeResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return e.CancelTrade(tradeID)
+}
+
+func (e *Exchange) CancelAllTradesContext(ctx context.Context) ([]lnmarkets.TradeResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return e.CancelAllTrades()
+}
+
+func (e *Exchange) AddMarginContext(ctx context.Context, positionID string, amount int64) (*lnmarkets.TradeResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return e.AddMargin(positionID, amount)
+}
+
+func (e *Exchange) CashInContext(ctx context.Context, positionID string, amount int64) (*lnmarkets.TradeResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return e.CashIn(positionID, amount)
+}
+
+func (e *Exchange) GetPositionsContext(ctx context.Context, positionType string) ([]lnmarkets.TradeResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return e.GetPositions(positionType)
+}
+
+func (e *Exchange) GetPositionContext(ctx context.Context, positionID string) (*lnmarkets.TradeResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return e.GetPosition(positionID)
+}
+
+func (e *Exchange) GetAccountBalanceContext(ctx context.Context) (*lnmarkets.UserData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return e.GetAccountBalance()
+}
+
+func (e *Exchange) UpdateTakeProfitContext(ctx context.Context, positionID string, takeProfitPrice float64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return e.UpdateTakeProfit(positionID, takeProfitPrice)
+}
+
+func (e *Exchange) UpdateStopLossContext(ctx context.Context, positionID string, stopLossPrice float64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return e.UpdateStopLoss(positionID, stopLossPrice)
+}
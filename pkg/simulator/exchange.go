@@ -0,0 +1,574 @@
+package simulator
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"btc-trading-bot/pkg/lnmarkets"
+	"btc-trading-bot/pkg/pricing"
+)
+
+// Config controls the behaviour of a simulated exchange.
+type Config struct {
+	// InitialBalance is the starting account balance in sats.
+	InitialBalance float64
+	// FeeRate is charged on the notional value (in sats) when a position is
+	// opened and again when it is closed, e.g. 0.001 for 0.1%.
+	FeeRate float64
+	// MaxLeverage rejects trades above this leverage when greater than zero.
+	MaxLeverage float64
+	// IDPrefix is prepended to generated position IDs. Defaults to "sim".
+	IDPrefix string
+	// Store, when set, receives every balance and position change so the
+	// account can be restored after a restart.
+	Store Store
+}
+
+// Store persists the state of a simulated account.
+type Store interface {
+	SaveBalance(balance float64) error
+	SavePosition(pos Position) error
+}
+
+// Exchange is an in-process implementation of lnmarkets.Exchange. Market
+// trades are filled at the last price pushed through UpdatePrice, limit
+// orders rest until the price crosses them, and positions are settled as
+// inverse BTC futures: quantity is expressed in USD while margin, fees and
+// PnL are expressed in sats.
+type Exchange struct {
+	mu        sync.Mutex
+	config    Config
+	balance   float64
+	price     float64
+	nextID    int64
+	positions map[string]*Position
+}
+
+// Position is the state of a simulated trade. Quantity and prices are in USD,
+// margin, fees and PL are in sats. Status is one of open (resting limit
+// order), running, closed, canceled or liquidated.
+type Position struct {
+	ID          string
+	Type        string
+	Side        string
+	Quantity    float64
+	EntryPrice  float64
+	Leverage    float64
+	Margin      float64
+	Liquidation float64
+	TakeProfit  float64
+	StopLoss    float64
+	OpeningFee  float64
+	ClosingFee  float64
+	ExitPrice   float64
+	PL          float64
+	Status      string
+	CreatedAt   time.Time
+	FilledAt    time.Time
+	ClosedAt    time.Time
+}
+
+var _ lnmarkets.Exchange = (*Exchange)(nil)
+
+func NewExchange(config Config) *Exchange {
+	if config.IDPrefix == "" {
+		config.IDPrefix = "sim"
+	}
+	return &Exchange{
+		config:    config,
+		balance:   config.InitialBalance,
+		positions: make(map[string]*Position),
+	}
+}
+
+// Restore replaces the account state with a previously persisted balance and
+// set of positions. New positions are numbered after the highest restored ID.
+func (e *Exchange) Restore(balance float64, positions []Position) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.balance = balance
+	e.positions = make(map[string]*Position, len(positions))
+	for i := range positions {
+		pos := positions[i]
+		if pos.Type == "" {
+			pos.Type = lnmarkets.OrderTypeMarket
+		}
+		e.positions[pos.ID] = &pos
+	}
+
+	e.nextID = int64(len(positions))
+	for id := range e.positions {
+		n, err := strconv.ParseInt(id[strings.LastIndex(id, "-")+1:], 10, 64)
+		if err == nil && n > e.nextID {
+			e.nextID = n
+		}
+	}
+}
+
+// UpdatePrice feeds a new market price into the simulator. Limit orders the
+// price crossed are filled, and running positions whose liquidation,
+// take-profit or stop-loss level was crossed are settled.
+func (e *Exchange) UpdatePrice(price float64) {
+	if price <= 0 {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.price = price
+	for _, pos := range e.positions {
+		switch pos.Status {
+		case "open":
+			// A buy limit fills once the market trades at or below it, a
+			// sell limit once it trades at or above it.
+			if !pos.crossed(pos.EntryPrice, price, false) {
+				continue
+			}
+			pos.Status = "running"
+			pos.FilledAt = time.Now()
+		case "running":
+			switch {
+			case pos.Liquidation > 0 && pos.crossed(pos.Liquidation, price, false):
+				e.liquidate(pos)
+			case pos.TakeProfit > 0 && pos.crossed(pos.TakeProfit, price, true):
+				e.settle(pos, pos.TakeProfit)
+			case pos.StopLoss > 0 && pos.crossed(pos.StopLoss, price, false):
+				e.settle(pos, pos.StopLoss)
+			default:
+				continue
+			}
+		default:
+			continue
+		}
+		e.persist(pos)
+	}
+}
+
+// Price returns the last price pushed into the simulator.
+func (e *Exchange) Price() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.price
+}
+
+func (e *Exchange) CreateTrade(trade *lnmarkets.TradeRequest) (*lnmarkets.TradeResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.price <= 0 {
+		return nil, fmt.Errorf("%w: no market price available yet", lnmarkets.ErrServer)
+	}
+	if trade.Leverage < 1 {
+		return nil, fmt.Errorf("%w: leverage must be at least 1", lnmarkets.ErrBadRequest)
+	}
+	if e.config.MaxLeverage > 0 && trade.Leverage > e.config.MaxLeverage {
+		return nil, fmt.Errorf("%w: leverage %.0f exceeds maximum %.0f", lnmarkets.ErrBadRequest, trade.Leverage, e.config.MaxLeverage)
+	}
+
+	orderType := trade.Type
+	if orderType == "" {
+		orderType = lnmarkets.OrderTypeMarket
+	}
+
+	entryPrice := e.price
+	switch orderType {
+	case lnmarkets.OrderTypeMarket:
+	case lnmarkets.OrderTypeLimit:
+		if trade.Price <= 0 {
+			return nil, fmt.Errorf("%w: limit orders require a price", lnmarkets.ErrBadRequest)
+		}
+		entryPrice = trade.Price
+	default:
+		return nil, fmt.Errorf("%w: unsupported order type %q", lnmarkets.ErrBadRequest, trade.Type)
+	}
+
+	side := lnmarkets.SideBuy
+	if !pricing.IsLong(trade.Side) {
+		side = lnmarkets.SideSell
+	}
+
+	quantity := trade.Quantity
+	var margin float64
+	switch {
+	case quantity > 0:
+		margin = pricing.Margin(quantity, entryPrice, trade.Leverage)
+	case trade.Margin > 0:
+		margin = float64(trade.Margin)
+		quantity = pricing.Quantity(margin, entryPrice, trade.Leverage)
+	default:
+		return nil, fmt.Errorf("%w: either quantity or margin must be positive", lnmarkets.ErrBadRequest)
+	}
+
+	fee := pricing.Fee(quantity, entryPrice, e.config.FeeRate)
+	if margin+fee > e.balance {
+		return nil, fmt.Errorf("%w: need %.0f sats, have %.0f sats", lnmarkets.ErrInsufficientFunds, margin+fee, e.balance)
+	}
+
+	e.nextID++
+	pos := &Position{
+		ID:         fmt.Sprintf("%s-%d", e.config.IDPrefix, e.nextID),
+		Type:       orderType,
+		Side:       side,
+		Quantity:   quantity,
+		EntryPrice: entryPrice,
+		Leverage:   trade.Leverage,
+		Margin:     margin,
+		TakeProfit: trade.TakeProfit,
+		StopLoss:   trade.StopLoss,
+		OpeningFee: fee,
+		Status:     "running",
+		CreatedAt:  time.Now(),
+		FilledAt:   time.Now(),
+	}
+	pos.Liquidation = pricing.Liquidation(pos.Side, pos.EntryPrice, pos.Quantity, pos.Margin)
+	if orderType == lnmarkets.OrderTypeLimit && !pos.crossed(entryPrice, e.price, false) {
+		pos.Status = "open"
+		pos.FilledAt = time.Time{}
+	}
+
+	e.balance -= margin + fee
+	e.positions[pos.ID] = pos
+	e.persist(pos)
+
+	resp := pos.toResponse(e.price)
+	return &resp, nil
+}
+
+func (e *Exchange) ClosePosition(positionID string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	pos, err := e.positionWithStatus(positionID, "running")
+	if err != nil {
+		return err
+	}
+
+	e.settle(pos, e.price)
+	e.persist(pos)
+	return nil
+}
+
+func (e *Exchange) CloseAllPositions() ([]lnmarkets.TradeResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var closed []lnmarkets.TradeResponse
+	for _, pos := range e.sortedPositions("running") {
+		e.settle(pos, e.price)
+		e.persist(pos)
+		closed = append(closed, pos.toResponse(e.price))
+	}
+	return closed, nil
+}
+
+func (e *Exchange) CancelTrade(tradeID string) (*lnmarkets.TradeResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	pos, err := e.positionWithStatus(tradeID, "open")
+	if err != nil {
+		return nil, err
+	}
+
+	e.cancel(pos)
+	e.persist(pos)
+
+	resp := pos.toResponse(e.price)
+	return &resp, nil
+}
+
+func (e *Exchange) CancelAllTrades() ([]lnmarkets.TradeResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var canceled []lnmarkets.TradeResponse
+	for _, pos := range e.sortedPositions("open") {
+		e.cancel(pos)
+		e.persist(pos)
+		canceled = append(canceled, pos.toResponse(e.price))
+	}
+	return canceled, nil
+}
+
+func (e *Exchange) AddMargin(positionID string, amount int64) (*lnmarkets.TradeResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	pos, err := e.positionWithStatus(positionID, "running")
+	if err != nil {
+		return nil, err
+	}
+	if amount <= 0 {
+		return nil, fmt.Errorf("%w: amount must be positive", lnmarkets.ErrBadRequest)
+	}
+	if float64(amount) > e.balance {
+		return nil, fmt.Errorf("%w: need %d sats, have %.0f sats", lnmarkets.ErrInsufficientFunds, amount, e.balance)
+	}
+
+	e.balance -= float64(amount)
+	pos.Margin += float64(amount)
+	pos.Leverage = pricing.Leverage(pos.Quantity, pos.EntryPrice, pos.Margin)
+	pos.Liquidation = pricing.Liquidation(pos.Side, pos.EntryPrice, pos.Quantity, pos.Margin)
+	e.persist(pos)
+
+	resp := pos.toResponse(e.price)
+	return &resp, nil
+}
+
+func (e *Exchange) CashIn(positionID string, amount int64) (*lnmarkets.TradeResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	pos, err := e.positionWithStatus(positionID, "running")
+	if err != nil {
+		return nil, err
+	}
+	if amount <= 0 {
+		return nil, fmt.Errorf("%w: amount must be positive", lnmarkets.ErrBadRequest)
+	}
+
+	// Cashing in takes sats out of the position's equity; what is left must
+	// still keep the position away from liquidation at the current price.
+	equity := pos.Margin + pos.pl(e.price)
+	remaining := equity - float64(amount)
+	if remaining <= 0 {
+		return nil, fmt.Errorf("%w: cannot cash in %d sats, position equity is %.0f sats", lnmarkets.ErrInsufficientFunds, amount, equity)
+	}
+
+	// Realize the PnL into a new entry at the current price, as the exchange
+	// does, then withdraw the requested amount from the margin.
+	pos.EntryPrice = e.price
+	pos.Margin = remaining
+	pos.Leverage = pricing.Leverage(pos.Quantity, pos.EntryPrice, pos.Margin)
+	pos.Liquidation = pricing.Liquidation(pos.Side, pos.EntryPrice, pos.Quantity, pos.Margin)
+	e.balance += float64(amount)
+	e.persist(pos)
+
+	resp := pos.toResponse(e.price)
+	return &resp, nil
+}
+
+func (e *Exchange) GetPositions(positionType string) ([]lnmarkets.TradeResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var list []*Position
+	switch positionType {
+	case "", "running":
+		list = e.sortedPositions("running")
+	case "open":
+		list = e.sortedPositions("open")
+	case "closed":
+		list = e.sortedPositions("closed", "canceled", "liquidated")
+	default:
+		return nil, fmt.Errorf("%w: unsupported position type %q", lnmarkets.ErrBadRequest, positionType)
+	}
+
+	positions := make([]lnmarkets.TradeResponse, 0, len(list))
+	for _, pos := range list {
+		positions = append(positions, pos.toResponse(e.price))
+	}
+	return positions, nil
+}
+
+func (e *Exchange) GetPosition(positionID string) (*lnmarkets.TradeResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	pos, ok := e.positions[positionID]
+	if !ok {
+		return nil, fmt.Errorf("%w: position %s", lnmarkets.ErrNotFound, positionID)
+	}
+
+	resp := pos.toResponse(e.price)
+	return &resp, nil
+}
+
+func (e *Exchange) GetAccountBalance() (*lnmarkets.UserData, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return &lnmarkets.UserData{
+		ID:       "simulated",
+		Balance:  e.balance,
+		Currency: "sats",
+	}, nil
+}
+
+func (e *Exchange) UpdateTakeProfit(positionID string, takeProfitPrice float64) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	pos, err := e.positionWithStatus(positionID, "running", "open")
+	if err != nil {
+		return err
+	}
+	pos.TakeProfit = takeProfitPrice
+	e.persist(pos)
+	return nil
+}
+
+func (e *Exchange) UpdateStopLoss(positionID string, stopLossPrice float64) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	pos, err := e.positionWithStatus(positionID, "running", "open")
+	if err != nil {
+		return err
+	}
+	pos.StopLoss = stopLossPrice
+	e.persist(pos)
+	return nil
+}
+
+// positionWithStatus looks up a position that must be in one of the given
+// states. Callers must hold e.mu.
+func (e *Exchange) positionWithStatus(positionID string, statuses ...string) (*Position, error) {
+	pos, ok := e.positions[positionID]
+	if !ok {
+		return nil, fmt.Errorf("%w: position %s", lnmarkets.ErrNotFound, positionID)
+	}
+	for _, status := range statuses {
+		if pos.Status == status {
+			return pos, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: position %s is %s", lnmarkets.ErrBadRequest, positionID, pos.Status)
+}
+
+// sortedPositions returns the positions in any of the given states ordered by
+// creation time. Callers must hold e.mu.
+func (e *Exchange) sortedPositions(statuses ...string) []*Position {
+	var list []*Position
+	for _, pos := range e.positions {
+		for _, status := range statuses {
+			if pos.Status == status {
+				list = append(list, pos)
+				break
+			}
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+// settle closes a position at exitPrice and credits margin plus PnL minus the
+// closing fee back to the balance. Callers must hold e.mu.
+func (e *Exchange) settle(pos *Position, exitPrice float64) {
+	pl := pos.pl(exitPrice)
+	fee := pricing.Fee(pos.Quantity, exitPrice, e.config.FeeRate)
+
+	pos.ExitPrice = exitPrice
+	pos.PL = pl
+	pos.ClosingFee = fee
+	pos.Status = "closed"
+	pos.ClosedAt = time.Now()
+
+	if credit := pos.Margin + pl - fee; credit > 0 {
+		e.balance += credit
+	}
+}
+
+// cancel refunds the margin and opening fee reserved by a resting limit
+// order. Callers must hold e.mu.
+func (e *Exchange) cancel(pos *Position) {
+	e.balance += pos.Margin + pos.OpeningFee
+	pos.OpeningFee = 0
+	pos.Status = "canceled"
+	pos.ClosedAt = time.Now()
+}
+
+// liquidate closes a position losing its whole margin. Callers must hold e.mu.
+func (e *Exchange) liquidate(pos *Position) {
+	pos.ExitPrice = pos.Liquidation
+	pos.PL = -pos.Margin
+	pos.Status = "liquidated"
+	pos.ClosedAt = time.Now()
+}
+
+// persist hands the changed position and the balance to the configured store.
+// Callers must hold e.mu.
+func (e *Exchange) persist(pos *Position) {
+	if e.config.Store == nil {
+		return
+	}
+	if err := e.config.Store.SavePosition(*pos); err != nil {
+		log.Printf("Error saving simulated position %s: %v", pos.ID, err)
+	}
+	if err := e.config.Store.SaveBalance(e.balance); err != nil {
+		log.Printf("Error saving simulated balance: %v", err)
+	}
+}
+
+// crossed reports whether price reached level. favourable selects the
+// direction that is profitable for the position (take-profit) as opposed to
+// the losing direction (stop-loss, liquidation and limit fills).
+func (p *Position) crossed(level, price float64, favourable bool) bool {
+	up := pricing.IsLong(p.Side)
+	if !favourable {
+		up = !up
+	}
+	if up {
+		return price >= level
+	}
+	return price <= level
+}
+
+// pl is the profit in sats of closing the position at exitPrice.
+func (p *Position) pl(exitPrice float64) float64 {
+	return pricing.PL(p.Side, p.Quantity, p.EntryPrice, exitPrice)
+}
+
+// toResponse converts the position to the LN Markets trade model. Running
+// positions report their unrealized PL at the given market price.
+func (p *Position) toResponse(price float64) lnmarkets.TradeResponse {
+	side := lnmarkets.SideBuy
+	if !pricing.IsLong(p.Side) {
+		side = lnmarkets.SideSell
+	}
+
+	pl := p.PL
+	if p.Status == "running" && price > 0 {
+		pl = p.pl(price)
+	}
+
+	resp := lnmarkets.TradeResponse{
+		ID:          p.ID,
+		Type:        p.Type,
+		Side:        side,
+		Quantity:    p.Quantity,
+		Margin:      p.Margin,
+		Leverage:    p.Leverage,
+		Price:       p.EntryPrice,
+		Liquidation: p.Liquidation,
+		StopLoss:    p.StopLoss,
+		TakeProfit:  p.TakeProfit,
+		ExitPrice:   p.ExitPrice,
+		PL:          pl,
+		OpeningFee:  p.OpeningFee,
+		ClosingFee:  p.ClosingFee,
+		CreationTS:  p.CreatedAt.UnixMilli(),
+		Open:        p.Status == "open",
+		Running:     p.Status == "running",
+		Canceled:    p.Status == "canceled",
+		Closed:      p.Status == "closed" || p.Status == "liquidated",
+	}
+	if p.Status != "open" && p.Status != "canceled" {
+		resp.EntryPrice = p.EntryPrice
+		resp.EntryMargin = p.Margin
+	}
+	if !p.FilledAt.IsZero() {
+		resp.MarketFilledTS = p.FilledAt.UnixMilli()
+	}
+	if !p.ClosedAt.IsZero() {
+		resp.ClosedTS = p.ClosedAt.UnixMilli()
+	}
+	return resp
+}
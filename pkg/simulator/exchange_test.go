@@ -0,0 +1,349 @@
+package simulator
+
+import (
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+
+	"btc-trading-bot/pkg/lnmarkets"
+)
+
+// The tests trade 1000 USD at 10x from $50,000: a notional of 2,000,000 sats,
+// a margin of 200,000 sats and, at 0.1%, an opening fee of 2,000 sats.
+const (
+	testBalance  = 1_000_000
+	testFeeRate  = 0.001
+	testPrice    = 50_000
+	testQuantity = 1000
+	testLeverage = 10
+	testMargin   = 200_000
+	testFee      = 2_000
+)
+
+func newTestExchange(t *testing.T, store Store) *Exchange {
+	t.Helper()
+	e := NewExchange(Config{InitialBalance: testBalance, FeeRate: testFeeRate, MaxLeverage: 100, Store: store})
+	e.UpdatePrice(testPrice)
+	return e
+}
+
+func openTrade(t *testing.T, e *Exchange, trade lnmarkets.TradeRequest) *lnmarkets.TradeResponse {
+	t.Helper()
+	if trade.Quantity == 0 && trade.Margin == 0 {
+		trade.Quantity = testQuantity
+	}
+	if trade.Leverage == 0 {
+		trade.Leverage = testLeverage
+	}
+	resp, err := e.CreateTrade(&trade)
+	if err != nil {
+		t.Fatalf("CreateTrade(%+v): %v", trade, err)
+	}
+	return resp
+}
+
+func position(t *testing.T, e *Exchange, id string) *lnmarkets.TradeResponse {
+	t.Helper()
+	resp, err := e.GetPosition(id)
+	if err != nil {
+		t.Fatalf("GetPosition(%s): %v", id, err)
+	}
+	return resp
+}
+
+func balance(t *testing.T, e *Exchange) float64 {
+	t.Helper()
+	user, err := e.GetAccountBalance()
+	if err != nil {
+		t.Fatalf("GetAccountBalance: %v", err)
+	}
+	return user.Balance
+}
+
+func assertClose(t *testing.T, name string, got, want float64) {
+	t.Helper()
+	if math.Abs(got-want) > 0.01 {
+		t.Errorf("%s = %.4f, want %.4f", name, got, want)
+	}
+}
+
+func TestMarketFill(t *testing.T) {
+	e := newTestExchange(t, nil)
+	resp := openTrade(t, e, lnmarkets.TradeRequest{Type: lnmarkets.OrderTypeMarket, Side: lnmarkets.SideBuy})
+
+	if !resp.Running || resp.Side != lnmarkets.SideBuy {
+		t.Fatalf("trade = %+v, want a running long", resp)
+	}
+	assertClose(t, "entry price", resp.EntryPrice, testPrice)
+	assertClose(t, "margin", resp.Margin, testMargin)
+	assertClose(t, "opening fee", resp.OpeningFee, testFee)
+	assertClose(t, "balance", balance(t, e), testBalance-testMargin-testFee)
+}
+
+func TestMarketFillByMargin(t *testing.T) {
+	e := newTestExchange(t, nil)
+	resp := openTrade(t, e, lnmarkets.TradeRequest{Side: lnmarkets.SideSell, Margin: testMargin})
+
+	assertClose(t, "quantity", resp.Quantity, testQuantity)
+	assertClose(t, "opening fee", resp.OpeningFee, testFee)
+}
+
+func TestCreateTradeRejections(t *testing.T) {
+	tests := []struct {
+		name  string
+		trade lnmarkets.TradeRequest
+	}{
+		{"leverage below 1", lnmarkets.TradeRequest{Side: lnmarkets.SideBuy, Quantity: testQuantity, Leverage: 0.5}},
+		{"leverage above maximum", lnmarkets.TradeRequest{Side: lnmarkets.SideBuy, Quantity: testQuantity, Leverage: 150}},
+		{"limit without price", lnmarkets.TradeRequest{Type: lnmarkets.OrderTypeLimit, Side: lnmarkets.SideBuy, Quantity: testQuantity, Leverage: testLeverage}},
+		{"no size", lnmarkets.TradeRequest{Side: lnmarkets.SideBuy, Leverage: testLeverage}},
+		{"insufficient funds", lnmarkets.TradeRequest{Side: lnmarkets.SideBuy, Quantity: 100_000, Leverage: 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestExchange(t, nil)
+			if _, err := e.CreateTrade(&tt.trade); err == nil {
+				t.Fatal("CreateTrade succeeded, want an error")
+			}
+			assertClose(t, "balance", balance(t, e), testBalance)
+		})
+	}
+}
+
+func TestLimitFill(t *testing.T) {
+	tests := []struct {
+		name    string
+		side    string
+		limit   float64
+		resting float64
+		filling float64
+	}{
+		{"buy below the market", lnmarkets.SideBuy, 49_000, 49_500, 48_900},
+		{"sell above the market", lnmarkets.SideSell, 51_000, 50_500, 51_100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestExchange(t, nil)
+			resp := openTrade(t, e, lnmarkets.TradeRequest{Type: lnmarkets.OrderTypeLimit, Side: tt.side, Price: tt.limit})
+			if !resp.Open {
+				t.Fatalf("limit order is not resting: %+v", resp)
+			}
+
+			e.UpdatePrice(tt.resting)
+			if !position(t, e, resp.ID).Open {
+				t.Fatalf("limit order filled at %.0f", tt.resting)
+			}
+
+			e.UpdatePrice(tt.filling)
+			filled := position(t, e, resp.ID)
+			if !filled.Running {
+				t.Fatalf("limit order not filled at %.0f: %+v", tt.filling, filled)
+			}
+			assertClose(t, "entry price", filled.EntryPrice, tt.limit)
+		})
+	}
+}
+
+func TestCancelRefundsLimitOrder(t *testing.T) {
+	e := newTestExchange(t, nil)
+	resp := openTrade(t, e, lnmarkets.TradeRequest{Type: lnmarkets.OrderTypeLimit, Side: lnmarkets.SideBuy, Price: 49_000})
+
+	if _, err := e.CancelTrade(resp.ID); err != nil {
+		t.Fatalf("CancelTrade: %v", err)
+	}
+	if !position(t, e, resp.ID).Canceled {
+		t.Fatal("order not canceled")
+	}
+	assertClose(t, "balance", balance(t, e), testBalance)
+}
+
+func TestSettlement(t *testing.T) {
+	tests := []struct {
+		name       string
+		trade      lnmarkets.TradeRequest
+		price      float64
+		exitPrice  float64
+		pl         float64
+		closingFee float64
+	}{
+		{
+			name:       "long take profit",
+			trade:      lnmarkets.TradeRequest{Side: lnmarkets.SideBuy, TakeProfit: 55_000},
+			price:      56_000,
+			exitPrice:  55_000,
+			pl:         181_818.18,
+			closingFee: 1_818.18,
+		},
+		{
+			name:       "short take profit",
+			trade:      lnmarkets.TradeRequest{Side: lnmarkets.SideSell, TakeProfit: 40_000},
+			price:      39_000,
+			exitPrice:  40_000,
+			pl:         500_000,
+			closingFee: 2_500,
+		},
+		{
+			name:       "long stop loss",
+			trade:      lnmarkets.TradeRequest{Side: lnmarkets.SideBuy, StopLoss: 48_000},
+			price:      47_900,
+			exitPrice:  48_000,
+			pl:         -83_333.33,
+			closingFee: 2_083.33,
+		},
+		{
+			name:       "short stop loss",
+			trade:      lnmarkets.TradeRequest{Side: lnmarkets.SideSell, StopLoss: 52_000},
+			price:      52_100,
+			exitPrice:  52_000,
+			pl:         -76_923.08,
+			closingFee: 1_923.08,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestExchange(t, nil)
+			resp := openTrade(t, e, tt.trade)
+
+			e.UpdatePrice(tt.price)
+			closed := position(t, e, resp.ID)
+			if !closed.Closed {
+				t.Fatalf("position not closed at %.0f: %+v", tt.price, closed)
+			}
+			assertClose(t, "exit price", closed.ExitPrice, tt.exitPrice)
+			assertClose(t, "PL", closed.PL, tt.pl)
+			assertClose(t, "closing fee", closed.ClosingFee, tt.closingFee)
+			assertClose(t, "balance", balance(t, e), testBalance-testFee+tt.pl-tt.closingFee)
+		})
+	}
+}
+
+func TestLiquidation(t *testing.T) {
+	tests := []struct {
+		name        string
+		side        string
+		liquidation float64
+		safe        float64
+		crossed     float64
+	}{
+		{"long", lnmarkets.SideBuy, 45_454.55, 45_500, 45_400},
+		{"short", lnmarkets.SideSell, 55_555.56, 55_500, 55_600},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestExchange(t, nil)
+			resp := openTrade(t, e, lnmarkets.TradeRequest{Side: tt.side})
+			assertClose(t, "liquidation", resp.Liquidation, tt.liquidation)
+
+			e.UpdatePrice(tt.safe)
+			if !position(t, e, resp.ID).Running {
+				t.Fatalf("position liquidated at %.0f", tt.safe)
+			}
+
+			e.UpdatePrice(tt.crossed)
+			liquidated := position(t, e, resp.ID)
+			if !liquidated.Closed {
+				t.Fatalf("position not liquidated at %.0f", tt.crossed)
+			}
+			assertClose(t, "PL", liquidated.PL, -testMargin)
+			assertClose(t, "balance", balance(t, e), testBalance-testMargin-testFee)
+		})
+	}
+}
+
+func TestAddMarginMovesLiquidation(t *testing.T) {
+	e := newTestExchange(t, nil)
+	resp := openTrade(t, e, lnmarkets.TradeRequest{Side: lnmarkets.SideBuy})
+
+	updated, err := e.AddMargin(resp.ID, testMargin)
+	if err != nil {
+		t.Fatalf("AddMargin: %v", err)
+	}
+	// Twice the margin at 10x leaves 5x: 1/L = 1/50000 + 400000/1e11.
+	assertClose(t, "leverage", updated.Leverage, 5)
+	assertClose(t, "liquidation", updated.Liquidation, 41_666.67)
+	assertClose(t, "balance", balance(t, e), testBalance-2*testMargin-testFee)
+}
+
+func TestClosePositionChargesFee(t *testing.T) {
+	e := newTestExchange(t, nil)
+	resp := openTrade(t, e, lnmarkets.TradeRequest{Side: lnmarkets.SideBuy})
+
+	if err := e.ClosePosition(resp.ID); err != nil {
+		t.Fatalf("ClosePosition: %v", err)
+	}
+	closed := position(t, e, resp.ID)
+	assertClose(t, "PL", closed.PL, 0)
+	assertClose(t, "closing fee", closed.ClosingFee, testFee)
+	assertClose(t, "balance", balance(t, e), testBalance-2*testFee)
+}
+
+// memoryStore keeps what the simulator persists, like paperStore does in the
+// database.
+type memoryStore struct {
+	balance   float64
+	positions map[string]Position
+}
+
+func (m *memoryStore) SaveBalance(balance float64) error {
+	m.balance = balance
+	return nil
+}
+
+func (m *memoryStore) SavePosition(pos Position) error {
+	if m.positions == nil {
+		m.positions = make(map[string]Position)
+	}
+	m.positions[pos.ID] = pos
+	return nil
+}
+
+func (m *memoryStore) list() []Position {
+	positions := make([]Position, 0, len(m.positions))
+	for _, pos := range m.positions {
+		positions = append(positions, pos)
+	}
+	sort.Slice(positions, func(i, j int) bool { return positions[i].ID < positions[j].ID })
+	return positions
+}
+
+func TestRestoreRoundTrip(t *testing.T) {
+	store := &memoryStore{}
+	e := newTestExchange(t, store)
+
+	openTrade(t, e, lnmarkets.TradeRequest{Side: lnmarkets.SideBuy, TakeProfit: 55_000})
+	openTrade(t, e, lnmarkets.TradeRequest{Side: lnmarkets.SideSell})
+	openTrade(t, e, lnmarkets.TradeRequest{Type: lnmarkets.OrderTypeLimit, Side: lnmarkets.SideBuy, Price: 45_000})
+	e.UpdatePrice(56_000)
+
+	restored := NewExchange(Config{FeeRate: testFeeRate})
+	restored.Restore(store.balance, store.list())
+	restored.UpdatePrice(56_000)
+
+	assertClose(t, "balance", balance(t, restored), balance(t, e))
+	for _, positionType := range []string{"running", "open", "closed"} {
+		want, _ := e.GetPositions(positionType)
+		got, err := restored.GetPositions(positionType)
+		if err != nil {
+			t.Fatalf("GetPositions(%s): %v", positionType, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s positions after restore = %+v, want %+v", positionType, got, want)
+		}
+	}
+}
+
+func TestRestoreContinuesIDs(t *testing.T) {
+	e := NewExchange(Config{InitialBalance: testBalance, FeeRate: testFeeRate, IDPrefix: "paper-7"})
+	// Position 2 was never saved, so the count of positions lags the IDs.
+	e.Restore(testBalance, []Position{
+		{ID: "paper-7-1", Side: lnmarkets.SideBuy, Status: "closed"},
+		{ID: "paper-7-3", Side: lnmarkets.SideBuy, Status: "closed"},
+	})
+	e.UpdatePrice(testPrice)
+
+	resp := openTrade(t, e, lnmarkets.TradeRequest{Side: lnmarkets.SideBuy})
+	if resp.ID != "paper-7-4" {
+		t.Fatalf("new position ID = %s, want paper-7-4", resp.ID)
+	}
+}
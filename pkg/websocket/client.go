@@ -1,29 +1,91 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand"
+	"os"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// State is the connection state reported to OnStateChange callbacks.
+type State string
+
+const (
+	StateDisconnected State = "disconnected"
+	StateConnecting   State = "connecting"
+	StateConnected    State = "connected"
+	StateReconnecting State = "reconnecting"
+	// StateFailed means every reconnect attempt failed; the client stays
+	// down until Connect is called again.
+	StateFailed State = "failed"
+	// StateClosed means Disconnect was called.
+	StateClosed State = "closed"
+)
+
+const (
+	maxReconnectDelay = 30 * time.Second
+	// writeTimeout bounds a single frame write so a stalled connection
+	// cannot hold up the writer.
+	writeTimeout = 10 * time.Second
+)
+
+var errNotConnected = errors.New("websocket: not connected")
+
+// Client is a JSON-RPC websocket client. Frames are written by a single
+// writer goroutine fed through a queue, messages are read by a single reader
+// goroutine, and every handler runs on its own goroutine so a slow handler
+// cannot stall either of them.
 type Client struct {
-	conn     *websocket.Conn
-	url      string
-	mu       sync.Mutex
-	handlers map[string]func([]byte)
-	done     chan struct{}
+	conn    *websocket.Conn
+	url     string
+	mu      sync.Mutex
+	topics  map[string][]*Subscription
+	ctx     context.Context
+	cancel  context.CancelFunc
+	writes  chan outbound
+	wg      sync.WaitGroup
+	closed  sync.Once
+	started sync.Once
+
+	// ReconnectAttempts bounds consecutive reconnect attempts after the
+	// connection drops; zero retries forever. ReconnectDelay is the initial
+	// backoff, doubled after every failed attempt.
+	ReconnectAttempts int
+	ReconnectDelay    time.Duration
+	HeartbeatInterval time.Duration
+
+	state         State
+	subscriptions []string
+	stateHandlers []func(State)
+	credentials   *credentials
+
+	nextID  atomic.Uint64
+	pending map[string]chan callResult
 }
 
 type Message struct {
-	JSONRPC string      `json:"jsonrpc"`
-	Method  string      `json:"method"`
-	ID      string      `json:"id"`
-	Params  interface{} `json:"params,omitempty"`
-	Result  interface{} `json:"result,omitempty"`
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method,omitempty"`
+	ID      string          `json:"id,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// outbound is a frame queued for the writer goroutine.
+type outbound struct {
+	messageType int
+	data        []byte
+	result      chan error
 }
 
 type PriceUpdate struct {
@@ -31,158 +93,420 @@ type PriceUpdate struct {
 	Time  int64   `json:"time"`
 }
 
+// NewClient creates a client configured from WS_RECONNECT_ATTEMPTS,
+// WS_RECONNECT_DELAY (milliseconds) and WS_HEARTBEAT_INTERVAL (seconds).
 func NewClient(url string) *Client {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Client{
-		url:      url,
-		handlers: make(map[string]func([]byte)),
-		done:     make(chan struct{}),
+		url:               url,
+		topics:            make(map[string][]*Subscription),
+		pending:           make(map[string]chan callResult),
+		ctx:               ctx,
+		cancel:            cancel,
+		writes:            make(chan outbound),
+		ReconnectAttempts: envInt("WS_RECONNECT_ATTEMPTS", 5),
+		ReconnectDelay:    time.Duration(envInt("WS_RECONNECT_DELAY", 1000)) * time.Millisecond,
+		HeartbeatInterval: time.Duration(envInt("WS_HEARTBEAT_INTERVAL", 5)) * time.Second,
+		state:             StateDisconnected,
 	}
 }
 
 func (c *Client) Connect() error {
-	var err error
-	c.conn, _, err = websocket.DefaultDialer.Dial(c.url, nil)
+	if c.isClosed() {
+		return ErrClosed
+	}
+	c.setState(StateConnecting)
+
+	conn, err := c.dial()
 	if err != nil {
+		c.setState(StateDisconnected)
 		return fmt.Errorf("failed to connect to websocket: %v", err)
 	}
 
-	log.Printf("Connected to WebSocket: %s", c.url)
+	// Goroutines are only added while the client is open, so Disconnect's
+	// Wait covers every one of them.
+	c.mu.Lock()
+	if c.isClosed() {
+		c.mu.Unlock()
+		conn.Close()
+		return ErrClosed
+	}
+	c.conn = conn
+	c.started.Do(func() {
+		c.wg.Add(1)
+		go c.writeLoop()
+	})
+	c.wg.Add(1)
+	c.mu.Unlock()
 
-	go c.readMessages()
+	log.Printf("Connected to WebSocket: %s", c.url)
+	c.setState(StateConnected)
 
-	go c.heartbeat()
+	go c.readMessages(conn)
 
 	return nil
 }
 
+func (c *Client) dial() (*websocket.Conn, error) {
+	conn, _, err := websocket.DefaultDialer.DialContext(c.ctx, c.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// A connection that stops answering pings is treated as dropped.
+	deadline := 3 * c.heartbeatInterval()
+	conn.SetReadDeadline(time.Now().Add(deadline))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(deadline))
+	})
+
+	return conn, nil
+}
+
+// Disconnect closes the connection and waits for the reader and writer
+// goroutines to exit. Pending calls fail with ErrClosed and handlers receive
+// no further messages, although one that is already running finishes on its
+// own goroutine. It must not be called from an OnStateChange callback, which
+// runs on the reader goroutine.
 func (c *Client) Disconnect() error {
-	close(c.done)
-	if c.conn != nil {
-		return c.conn.Close()
+	var err error
+	c.closed.Do(func() {
+		c.mu.Lock()
+		c.cancel()
+		conn := c.conn
+		c.conn = nil
+		subs := c.topics
+		c.topics = make(map[string][]*Subscription)
+		c.mu.Unlock()
+
+		if conn != nil {
+			err = conn.Close()
+		}
+		c.wg.Wait()
+
+		c.failPending(ErrClosed)
+		for _, topicSubs := range subs {
+			for _, sub := range topicSubs {
+				sub.stop()
+			}
+		}
+		c.setState(StateClosed)
+	})
+	return err
+}
+
+// State returns the current connection state.
+func (c *Client) State() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// OnStateChange registers a callback invoked on every state transition.
+func (c *Client) OnStateChange(handler func(State)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stateHandlers = append(c.stateHandlers, handler)
+}
+
+func (c *Client) setState(state State) {
+	c.mu.Lock()
+	if c.state == state || c.state == StateClosed {
+		c.mu.Unlock()
+		return
 	}
-	return nil
+	c.state = state
+	handlers := append([]func(State){}, c.stateHandlers...)
+	c.mu.Unlock()
+
+	for _, handler := range handlers {
+		handler(state)
+	}
+}
+
+func (c *Client) isClosed() bool {
+	return c.ctx.Err() != nil
 }
 
-func (c *Client) readMessages() {
+func (c *Client) readMessages(conn *websocket.Conn) {
+	defer c.wg.Done()
+
 	for {
-		select {
-		case <-c.done:
-			return
-		default:
-			_, message, err := c.conn.ReadMessage()
-			if err != nil {
-				log.Printf("Error reading message: %v", err)
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			if c.isClosed() {
 				return
 			}
+			log.Printf("Error reading message: %v", err)
 
-			var msg Message
-			if err := json.Unmarshal(message, &msg); err != nil {
-				log.Printf("Error unmarshaling message: %v", err)
-				continue
+			c.mu.Lock()
+			if c.conn == conn {
+				c.conn = nil
 			}
+			c.mu.Unlock()
+			conn.Close()
+			c.failPending(ErrConnectionLost)
+
+			conn = c.reconnect()
+			if conn == nil {
+				return
+			}
+			continue
+		}
 
-			c.handleMessage(msg)
+		var msg Message
+		if err := json.Unmarshal(message, &msg); err != nil {
+			log.Printf("Error unmarshaling message: %v", err)
+			continue
 		}
+
+		c.handleMessage(msg)
 	}
 }
 
-func (c *Client) handleMessage(msg Message) {
-	switch msg.Method {
-	case "futures:btc_usd:last-price":
-		if handler, exists := c.handlers["price"]; exists {
-			if data, err := json.Marshal(msg.Result); err == nil {
-				handler(data)
-			}
+// reconnect dials with exponential backoff, signs in again if the client was
+// authenticated and replays the active subscriptions. It returns nil when the
+// client was closed or every attempt failed.
+func (c *Client) reconnect() *websocket.Conn {
+	c.setState(StateReconnecting)
+
+	delay := c.ReconnectDelay
+	if delay <= 0 {
+		delay = time.Second
+	}
+	for attempt := 1; c.ReconnectAttempts <= 0 || attempt <= c.ReconnectAttempts; attempt++ {
+		// Full jitter keeps many bots from reconnecting in lockstep.
+		wait := time.Duration(rand.Int63n(int64(delay)) + 1)
+		select {
+		case <-c.ctx.Done():
+			return nil
+		case <-time.After(wait):
 		}
-	case "futures:btc_usd:index":
-		if handler, exists := c.handlers["index"]; exists {
-			if data, err := json.Marshal(msg.Result); err == nil {
-				handler(data)
+
+		conn, err := c.dial()
+		if err != nil {
+			log.Printf("WebSocket reconnect attempt %d to %s failed: %v", attempt, c.url, err)
+			delay *= 2
+			if delay > maxReconnectDelay {
+				delay = maxReconnectDelay
 			}
+			continue
+		}
+
+		c.mu.Lock()
+		if c.isClosed() {
+			c.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		c.conn = conn
+		c.mu.Unlock()
+
+		log.Printf("Reconnected to WebSocket: %s", c.url)
+		c.setState(StateConnected)
+
+		// Replies are read by the caller's read loop, so the replay has to
+		// run alongside it.
+		go c.resubscribe()
+		return conn
+	}
+
+	log.Printf("Giving up reconnecting to %s after %d attempts", c.url, c.ReconnectAttempts)
+	c.setState(StateFailed)
+	return nil
+}
+
+func (c *Client) resubscribe() {
+	c.mu.Lock()
+	events := append([]string{}, c.subscriptions...)
+	creds := c.credentials
+	c.mu.Unlock()
+
+	// Private topics are rejected on a connection that is not signed in.
+	if creds != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultCallTimeout)
+		err := c.authenticate(ctx, creds)
+		cancel()
+		if err != nil {
+			log.Printf("Error re-authenticating on %s: %v", c.url, err)
 		}
-	default:
+	}
+
+	for _, event := range events {
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultCallTimeout)
+		_, err := c.Call(ctx, "subscribe", subscribeParams(event))
+		cancel()
+		if err != nil {
+			log.Printf("Error replaying subscription %s on %s: %v", event, c.url, err)
+		}
+	}
+}
+
+func (c *Client) handleMessage(msg Message) {
+	if msg.Method == "" && msg.ID != "" {
+		c.resolve(msg)
+		return
+	}
+
+	topic, data := notification(msg)
+	if !c.dispatch(topic, data) {
 		log.Printf("Received message: %s", msg.Method)
 	}
 }
 
-func (c *Client) heartbeat() {
-	ticker := time.NewTicker(5 * time.Second)
+// writeLoop is the only goroutine that writes to the connection. It serves
+// queued frames and sends a ping every heartbeat interval.
+func (c *Client) writeLoop() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.heartbeatInterval())
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-c.done:
+		case <-c.ctx.Done():
 			return
+		case out := <-c.writes:
+			out.result <- c.write(out.messageType, out.data)
 		case <-ticker.C:
-			c.mu.Lock()
-			if c.conn != nil {
-				if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-					log.Printf("Error sending ping: %v", err)
-				}
+			if err := c.write(websocket.PingMessage, nil); err != nil && err != errNotConnected {
+				log.Printf("Error sending ping: %v", err)
 			}
-			c.mu.Unlock()
 		}
 	}
 }
 
+func (c *Client) write(messageType int, data []byte) error {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+
+	if conn == nil {
+		return errNotConnected
+	}
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return conn.WriteMessage(messageType, data)
+}
+
+func (c *Client) heartbeatInterval() time.Duration {
+	if c.HeartbeatInterval <= 0 {
+		return 5 * time.Second
+	}
+	return c.HeartbeatInterval
+}
+
+func subscribeParams(event string) map[string]string {
+	return map[string]string{"event": event}
+}
+
+// Subscribe subscribes to event, waiting up to DefaultCallTimeout for the
+// server to accept it.
 func (c *Client) Subscribe(event string) error {
-	msg := Message{
-		JSONRPC: "2.0",
-		Method:  "subscribe",
-		ID:      fmt.Sprintf("sub_%d", time.Now().Unix()),
-		Params: map[string]string{
-			"event": event,
-		},
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultCallTimeout)
+	defer cancel()
+	return c.SubscribeContext(ctx, event)
+}
+
+// SubscribeContext subscribes to event and returns the server's error if it
+// rejects the subscription. Accepted subscriptions are replayed after every
+// reconnect.
+func (c *Client) SubscribeContext(ctx context.Context, event string) error {
+	if _, err := c.Call(ctx, "subscribe", subscribeParams(event)); err != nil {
+		return err
 	}
 
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, existing := range c.subscriptions {
+		if existing == event {
+			return nil
+		}
+	}
+	c.subscriptions = append(c.subscriptions, event)
+	return nil
+}
+
+// send queues msg for the writer goroutine and waits until it is written or
+// ctx is done. A frame abandoned after it was queued may still be written.
+func (c *Client) send(ctx context.Context, msg Message) error {
 	data, err := json.Marshal(msg)
 	if err != nil {
 		return err
 	}
 
+	// The writer is started by the first successful Connect, which also sets
+	// the connection.
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	connected := c.conn != nil
+	c.mu.Unlock()
+	if !connected {
+		if c.isClosed() {
+			return ErrClosed
+		}
+		return errNotConnected
+	}
 
-	if c.conn != nil {
-		return c.conn.WriteMessage(websocket.TextMessage, data)
+	out := outbound{messageType: websocket.TextMessage, data: data, result: make(chan error, 1)}
+	select {
+	case c.writes <- out:
+	case <-c.ctx.Done():
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 
-	return fmt.Errorf("not connected")
+	select {
+	case err := <-out.result:
+		return err
+	case <-c.ctx.Done():
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
+// OnPriceUpdate registers a raw handler for TopicLastPrice.
 func (c *Client) OnPriceUpdate(handler func([]byte)) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.handlers["price"] = handler
+	c.On(TopicLastPrice, func(data json.RawMessage) { handler(data) })
 }
 
+// OnIndexUpdate registers a raw handler for TopicIndex.
 func (c *Client) OnIndexUpdate(handler func([]byte)) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.handlers["index"] = handler
+	c.On(TopicIndex, func(data json.RawMessage) { handler(data) })
 }
 
-func (c *Client) SendEcho(message string) error {
-	msg := Message{
-		JSONRPC: "2.0",
-		Method:  "debug/echo",
-		ID:      fmt.Sprintf("echo_%d", time.Now().Unix()),
-		Params: map[string]string{
-			"hello": message,
-		},
+// Unsubscribe cancels a subscription and stops replaying it on reconnect.
+func (c *Client) Unsubscribe(ctx context.Context, event string) error {
+	c.mu.Lock()
+	for i, existing := range c.subscriptions {
+		if existing == event {
+			c.subscriptions = append(c.subscriptions[:i], c.subscriptions[i+1:]...)
+			break
+		}
 	}
+	c.mu.Unlock()
 
-	data, err := json.Marshal(msg)
+	_, err := c.Call(ctx, "unsubscribe", subscribeParams(event))
+	return err
+}
+
+func (c *Client) SendEcho(message string) error {
+	params, err := json.Marshal(map[string]string{"hello": message})
 	if err != nil {
 		return err
 	}
 
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	return c.send(context.Background(), Message{
+		JSONRPC: "2.0",
+		Method:  "debug/echo",
+		ID:      c.newID(),
+		Params:  params,
+	})
+}
 
-	if c.conn != nil {
-		return c.conn.WriteMessage(websocket.TextMessage, data)
+func envInt(key string, defaultValue int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil && v >= 0 {
+		return v
 	}
-
-	return fmt.Errorf("not connected")
+	return defaultValue
 }
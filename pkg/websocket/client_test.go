@@ -0,0 +1,427 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// testServer is a JSON-RPC websocket server that answers every call with
+// true, records the methods called on each connection and rejects private
+// topics on connections that have not called auth.
+type testServer struct {
+	*httptest.Server
+	t *testing.T
+
+	mu    sync.Mutex
+	conns []*serverConn
+	// reply, when set, answers calls instead of the default handler.
+	reply func(conn *serverConn, msg Message)
+}
+
+type serverConn struct {
+	conn    *websocket.Conn
+	mu      sync.Mutex
+	methods []string
+	authed  bool
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	s := &testServer{t: t}
+	upgrader := websocket.Upgrader{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		sc := &serverConn{conn: conn}
+		s.mu.Lock()
+		s.conns = append(s.conns, sc)
+		s.mu.Unlock()
+		s.serve(sc)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testServer) URL() string {
+	return "ws" + strings.TrimPrefix(s.Server.URL, "http")
+}
+
+func (s *testServer) serve(sc *serverConn) {
+	defer sc.conn.Close()
+	for {
+		var msg Message
+		if err := sc.conn.ReadJSON(&msg); err != nil {
+			return
+		}
+
+		sc.mu.Lock()
+		sc.methods = append(sc.methods, msg.Method)
+		if msg.Method == "auth" {
+			sc.authed = true
+		}
+		authed := sc.authed
+		sc.mu.Unlock()
+
+		s.mu.Lock()
+		reply := s.reply
+		s.mu.Unlock()
+		if reply != nil {
+			reply(sc, msg)
+			continue
+		}
+
+		response := Message{JSONRPC: "2.0", ID: msg.ID, Result: json.RawMessage("true")}
+		if msg.Method == "subscribe" && strings.Contains(string(msg.Params), "futures:user") && !authed {
+			response = Message{JSONRPC: "2.0", ID: msg.ID, Error: &RPCError{Code: 401, Message: "unauthorized"}}
+		}
+		sc.send(response)
+	}
+}
+
+func (sc *serverConn) send(msg Message) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.conn.WriteJSON(msg)
+}
+
+func (sc *serverConn) calls() []string {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return append([]string{}, sc.methods...)
+}
+
+// conn waits for the n-th connection, counting from zero.
+func (s *testServer) conn(n int) *serverConn {
+	s.t.Helper()
+	var sc *serverConn
+	waitFor(s.t, "connection", func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if len(s.conns) > n {
+			sc = s.conns[n]
+			return true
+		}
+		return false
+	})
+	return sc
+}
+
+// publish pushes data on topic over the latest connection.
+func (s *testServer) publish(topic string, data string) {
+	s.mu.Lock()
+	sc := s.conns[len(s.conns)-1]
+	s.mu.Unlock()
+
+	params, _ := json.Marshal(map[string]interface{}{"channel": topic, "data": json.RawMessage(data)})
+	sc.send(Message{JSONRPC: "2.0", Method: "subscription", Params: params})
+}
+
+// testClient returns a client connected to server that reconnects quickly.
+func testClient(t *testing.T, server *testServer) *Client {
+	t.Helper()
+	client := NewClient(server.URL())
+	client.ReconnectAttempts = 0
+	client.ReconnectDelay = 10 * time.Millisecond
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	t.Cleanup(func() { client.Disconnect() })
+	return client
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReauthenticatesOnReconnect(t *testing.T) {
+	server := newTestServer(t)
+	client := testClient(t, server)
+	ctx := context.Background()
+
+	if err := client.Authenticate(ctx, "key", "secret", "passphrase"); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	events := make(chan TradeEvent, 1)
+	_, err := Handle(ctx, client, TopicUserTrades, func(event TradeEvent) { events <- event })
+	if err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+
+	server.conn(0).conn.Close()
+
+	second := server.conn(1)
+	waitFor(t, "subscription replay", func() bool { return len(second.calls()) == 2 })
+	if calls := second.calls(); calls[0] != "auth" || calls[1] != "subscribe" {
+		t.Fatalf("calls after reconnect = %v, want [auth subscribe]", calls)
+	}
+
+	server.publish(TopicUserTrades, `{"event":"filled","trade":{"id":"t1"}}`)
+	select {
+	case event := <-events:
+		if event.Trade.ID != "t1" {
+			t.Errorf("trade id = %q, want t1", event.Trade.ID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no trade event after reconnect")
+	}
+}
+
+func TestDisconnectDuringBlockedRead(t *testing.T) {
+	server := newTestServer(t)
+	server.reply = func(*serverConn, Message) {}
+	client := testClient(t, server)
+
+	calls := make(chan error, 1)
+	go func() {
+		_, err := client.Call(context.Background(), "debug/echo", nil)
+		calls <- err
+	}()
+	waitFor(t, "call", func() bool { return len(server.conn(0).calls()) == 1 })
+
+	done := make(chan error, 1)
+	go func() { done <- client.Disconnect() }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Disconnect blocked on the reader")
+	}
+
+	if err := <-calls; !errors.Is(err, ErrClosed) {
+		t.Errorf("pending call error = %v, want ErrClosed", err)
+	}
+	if state := client.State(); state != StateClosed {
+		t.Errorf("state = %s, want %s", state, StateClosed)
+	}
+	if _, err := client.Call(context.Background(), "debug/echo", nil); !errors.Is(err, ErrClosed) {
+		t.Errorf("call after Disconnect = %v, want ErrClosed", err)
+	}
+}
+
+func TestConcurrentCalls(t *testing.T) {
+	server := newTestServer(t)
+	server.reply = func(sc *serverConn, msg Message) {
+		sc.send(Message{JSONRPC: "2.0", ID: msg.ID, Result: msg.Params})
+	}
+	client := testClient(t, server)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			result, err := client.Call(context.Background(), "debug/echo", map[string]int{"n": i})
+			if err != nil {
+				errs <- err
+				return
+			}
+			var echo struct{ N int }
+			if err := json.Unmarshal(result, &echo); err != nil || echo.N != i {
+				errs <- fmt.Errorf("call %d got response %s", i, result)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			if err := client.SendEcho("hello"); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestSendHonoursContext(t *testing.T) {
+	server := newTestServer(t)
+	client := NewClient(server.URL())
+	conn, err := client.dial()
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	// A connection without a writer goroutine stands in for a busy writer.
+	client.conn = conn
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.Call(ctx, "debug/echo", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Call error = %v, want deadline exceeded", err)
+	}
+}
+
+func TestHandlerPolicies(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   Policy
+		received []int
+		dropped  uint64
+	}{
+		{"DropOldest", DropOldest, []int{1, 4, 5}, 2},
+		{"DropNewest", DropNewest, []int{1, 2, 3}, 2},
+		{"Conflate", Conflate, []int{1, 5}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t)
+			client := testClient(t, server)
+			server.conn(0)
+
+			received := make(chan int, 10)
+			gate := make(chan struct{})
+			sub := client.On("numbers", func(data json.RawMessage) {
+				var n int
+				json.Unmarshal(data, &n)
+				received <- n
+				if n == 1 {
+					<-gate
+				}
+			}, WithBuffer(2), WithPolicy(tt.policy))
+			flushed := make(chan struct{})
+			client.On("flush", func(json.RawMessage) { close(flushed) })
+
+			// The handler blocks on the first message while the rest queue up.
+			server.publish("numbers", "1")
+			if n := <-received; n != 1 {
+				t.Fatalf("first message = %d, want 1", n)
+			}
+			for n := 2; n <= 5; n++ {
+				server.publish("numbers", strconv.Itoa(n))
+			}
+			server.publish("flush", "null")
+			<-flushed
+			close(gate)
+
+			got := []int{1}
+			for len(got) < len(tt.received) {
+				select {
+				case n := <-received:
+					got = append(got, n)
+				case <-time.After(5 * time.Second):
+					t.Fatalf("received %v, want %v", got, tt.received)
+				}
+			}
+			select {
+			case n := <-received:
+				t.Fatalf("received extra message %d after %v", n, got)
+			case <-time.After(50 * time.Millisecond):
+			}
+			for i := range got {
+				if got[i] != tt.received[i] {
+					t.Fatalf("received %v, want %v", got, tt.received)
+				}
+			}
+			if dropped := sub.Dropped(); dropped != tt.dropped {
+				t.Errorf("Dropped() = %d, want %d", dropped, tt.dropped)
+			}
+		})
+	}
+}
+
+func TestReconnectReplaysSubscriptions(t *testing.T) {
+	server := newTestServer(t)
+	client := testClient(t, server)
+
+	var mu sync.Mutex
+	var states []State
+	client.OnStateChange(func(state State) {
+		mu.Lock()
+		states = append(states, state)
+		mu.Unlock()
+	})
+
+	prices := make(chan PriceUpdate, 1)
+	_, err := Handle(context.Background(), client, TopicLastPrice, func(update PriceUpdate) { prices <- update })
+	if err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+
+	// A call in flight when the connection drops fails instead of hanging.
+	server.mu.Lock()
+	server.reply = func(*serverConn, Message) {}
+	server.mu.Unlock()
+	calls := make(chan error, 1)
+	go func() {
+		_, err := client.Call(context.Background(), "debug/echo", nil)
+		calls <- err
+	}()
+	waitFor(t, "call", func() bool { return len(server.conn(0).calls()) == 2 })
+	server.mu.Lock()
+	server.reply = nil
+	server.mu.Unlock()
+
+	server.conn(0).conn.Close()
+	if err := <-calls; !errors.Is(err, ErrConnectionLost) {
+		t.Errorf("call error = %v, want ErrConnectionLost", err)
+	}
+
+	second := server.conn(1)
+	waitFor(t, "subscription replay", func() bool { return len(second.calls()) == 1 })
+	if calls := second.calls(); calls[0] != "subscribe" {
+		t.Fatalf("calls after reconnect = %v, want [subscribe]", calls)
+	}
+
+	server.publish(TopicLastPrice, `{"price":65000,"time":1}`)
+	select {
+	case update := <-prices:
+		if update.Price != 65000 {
+			t.Errorf("price = %v, want 65000", update.Price)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no price after reconnect")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(states) != 2 || states[0] != StateReconnecting || states[1] != StateConnected {
+		t.Errorf("states = %v, want [reconnecting connected]", states)
+	}
+}
+
+func TestReconnectGivesUp(t *testing.T) {
+	server := newTestServer(t)
+	client := testClient(t, server)
+	client.ReconnectAttempts = 2
+
+	failed := make(chan struct{})
+	client.OnStateChange(func(state State) {
+		if state == StateFailed {
+			close(failed)
+		}
+	})
+
+	conn := server.conn(0)
+	server.Close()
+	conn.conn.Close()
+
+	select {
+	case <-failed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("state = %s, want %s", client.State(), StateFailed)
+	}
+	if _, err := client.Call(context.Background(), "debug/echo", nil); !errors.Is(err, errNotConnected) {
+		t.Errorf("call after giving up = %v, want errNotConnected", err)
+	}
+}
@@ -0,0 +1,163 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultHandlerBuffer is the number of messages a handler may fall behind
+// before its Policy applies.
+const DefaultHandlerBuffer = 64
+
+// Policy decides what happens to a message when a handler's buffer is full.
+type Policy int
+
+const (
+	// DropOldest discards the oldest buffered message to make room.
+	DropOldest Policy = iota
+	// DropNewest discards the incoming message.
+	DropNewest
+	// Conflate keeps only the latest message, for feeds such as prices where
+	// older values are worthless once a newer one arrives.
+	Conflate
+)
+
+// HandlerOption configures a handler registered with On, Listen or Handle.
+type HandlerOption func(*Subscription)
+
+// WithBuffer sets how many messages the handler may fall behind.
+func WithBuffer(size int) HandlerOption {
+	return func(s *Subscription) {
+		if size > 0 {
+			s.size = size
+		}
+	}
+}
+
+// WithPolicy sets what happens when the handler's buffer is full.
+func WithPolicy(policy Policy) HandlerOption {
+	return func(s *Subscription) {
+		s.policy = policy
+	}
+}
+
+// Subscription is a handler registered for a topic. Messages are queued in a
+// bounded buffer and delivered in order on the subscription's own goroutine.
+type Subscription struct {
+	client  *Client
+	topic   string
+	handler func(json.RawMessage)
+	policy  Policy
+	size    int
+
+	mu      sync.Mutex
+	queue   []json.RawMessage
+	ready   chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	dropped atomic.Uint64
+}
+
+func newSubscription(c *Client, topic string, handler func(json.RawMessage), opts []HandlerOption) *Subscription {
+	sub := &Subscription{
+		client:  c,
+		topic:   topic,
+		handler: handler,
+		size:    DefaultHandlerBuffer,
+		ready:   make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(sub)
+	}
+	if sub.policy == Conflate {
+		sub.size = 1
+	}
+	return sub
+}
+
+// Dropped returns how many messages were discarded because the handler fell
+// behind, not counting conflated ones.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// deliver queues data for the handler without blocking.
+func (s *Subscription) deliver(data json.RawMessage) {
+	dropped := false
+
+	s.mu.Lock()
+	if len(s.queue) >= s.size {
+		switch s.policy {
+		case DropNewest:
+			s.mu.Unlock()
+			s.drop()
+			return
+		case DropOldest:
+			s.queue = s.queue[1:]
+			dropped = true
+		case Conflate:
+			s.queue = s.queue[:0]
+		}
+	}
+	s.queue = append(s.queue, data)
+	s.mu.Unlock()
+
+	if dropped {
+		s.drop()
+	}
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Subscription) drop() {
+	if n := s.dropped.Add(1); n == 1 || n%1000 == 0 {
+		log.Printf("Handler for %s is falling behind, %d messages dropped", s.topic, n)
+	}
+}
+
+func (s *Subscription) next() (json.RawMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.queue) == 0 {
+		return nil, false
+	}
+	data := s.queue[0]
+	s.queue = s.queue[1:]
+	return data, true
+}
+
+func (s *Subscription) run() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.ready:
+		}
+
+		for {
+			data, ok := s.next()
+			if !ok {
+				break
+			}
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+			s.handler(data)
+		}
+	}
+}
+
+// stop ends delivery. A handler that is already running is not interrupted.
+func (s *Subscription) stop() {
+	s.once.Do(func() {
+		close(s.done)
+	})
+}
@@ -0,0 +1,116 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// DefaultCallTimeout bounds Subscribe and subscription replays.
+const DefaultCallTimeout = 10 * time.Second
+
+var (
+	// ErrConnectionLost fails calls whose connection dropped before the
+	// response arrived. The request may or may not have been processed.
+	ErrConnectionLost = errors.New("websocket: connection lost")
+	// ErrClosed fails calls made on, or pending when, the client is closed.
+	ErrClosed = errors.New("websocket: client closed")
+)
+
+// RPCError is a JSON-RPC error object returned by the server.
+type RPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("json-rpc error %d: %s", e.Code, e.Message)
+}
+
+// callResult is delivered to a pending call: the response, or the error
+// that ended the wait.
+type callResult struct {
+	msg Message
+	err error
+}
+
+// Call sends a JSON-RPC request and waits for the response with the same ID.
+// A JSON-RPC error response is returned as *RPCError.
+func (c *Client) Call(ctx context.Context, method string, params interface{}) (json.RawMessage, error) {
+	if c.isClosed() {
+		return nil, ErrClosed
+	}
+
+	id := c.newID()
+	reply := make(chan callResult, 1)
+
+	c.mu.Lock()
+	c.pending[id] = reply
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	var rawParams json.RawMessage
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return nil, err
+		}
+		rawParams = data
+	}
+
+	if err := c.send(ctx, Message{JSONRPC: "2.0", Method: method, ID: id, Params: rawParams}); err != nil {
+		return nil, err
+	}
+
+	select {
+	case res := <-reply:
+		if res.err != nil {
+			return nil, res.err
+		}
+		if res.msg.Error != nil {
+			return nil, res.msg.Error
+		}
+		return res.msg.Result, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// newID returns a request ID that is unique for the lifetime of the client.
+func (c *Client) newID() string {
+	return strconv.FormatUint(c.nextID.Add(1), 10)
+}
+
+// resolve delivers a response to the call waiting for it. Responses nobody
+// waits for, e.g. after a timeout, are dropped.
+func (c *Client) resolve(msg Message) {
+	c.mu.Lock()
+	reply, ok := c.pending[msg.ID]
+	delete(c.pending, msg.ID)
+	c.mu.Unlock()
+
+	if ok {
+		reply <- callResult{msg: msg}
+	}
+}
+
+// failPending fails every call still waiting for a response.
+func (c *Client) failPending(err error) {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = make(map[string]chan callResult)
+	c.mu.Unlock()
+
+	for _, reply := range pending {
+		reply <- callResult{err: err}
+	}
+}
@@ -0,0 +1,195 @@
+package websocket
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"btc-trading-bot/pkg/lnmarkets"
+)
+
+// LN Markets channels. The futures:user channel is private and requires
+// Authenticate.
+const (
+	TopicLastPrice  = "futures:btc_usd:last-price"
+	TopicIndex      = "futures:btc_usd:index"
+	TopicTicker     = "futures:btc_usd:ticker"
+	TopicUserTrades = "futures:user:trades"
+)
+
+type IndexUpdate struct {
+	Index float64 `json:"index"`
+	Time  int64   `json:"time"`
+}
+
+type TickerUpdate struct {
+	Index        float64 `json:"index"`
+	LastPrice    float64 `json:"lastPrice"`
+	BidPrice     float64 `json:"bidPrice"`
+	AskPrice     float64 `json:"askPrice"`
+	CarryFeeRate float64 `json:"carryFeeRate"`
+	Time         int64   `json:"time"`
+}
+
+// TradeEvent is a change to one of the authenticated user's trades, e.g.
+// a limit order being filled or a position being closed or liquidated.
+type TradeEvent struct {
+	Event string                  `json:"event"`
+	Trade lnmarkets.TradeResponse `json:"trade"`
+}
+
+// On registers handler for every message published on topic. Several
+// handlers may share a topic. It does not subscribe on the server; see
+// Listen.
+func (c *Client) On(topic string, handler func(json.RawMessage), opts ...HandlerOption) *Subscription {
+	sub := newSubscription(c, topic, handler, opts)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.isClosed() {
+		return sub
+	}
+	c.topics[topic] = append(c.topics[topic], sub)
+	go sub.run()
+	return sub
+}
+
+// Listen registers handler and subscribes to topic on the server unless
+// another handler already did.
+func (c *Client) Listen(ctx context.Context, topic string, handler func(json.RawMessage), opts ...HandlerOption) (*Subscription, error) {
+	c.mu.Lock()
+	first := len(c.topics[topic]) == 0
+	c.mu.Unlock()
+
+	sub := c.On(topic, handler, opts...)
+	if first {
+		if err := c.SubscribeContext(ctx, topic); err != nil {
+			sub.remove()
+			return nil, err
+		}
+	}
+	return sub, nil
+}
+
+// Handle is Listen with a typed decoder: every message on topic is decoded
+// into T before handler is called. Messages that fail to decode are logged
+// and skipped.
+func Handle[T any](ctx context.Context, c *Client, topic string, handler func(T), opts ...HandlerOption) (*Subscription, error) {
+	return c.Listen(ctx, topic, func(data json.RawMessage) {
+		var v T
+		if err := json.Unmarshal(data, &v); err != nil {
+			log.Printf("Error decoding %s message: %v", topic, err)
+			return
+		}
+		handler(v)
+	}, opts...)
+}
+
+// Unsubscribe removes the handler and, when it was the last one for its
+// topic, unsubscribes from the topic on the server.
+func (s *Subscription) Unsubscribe(ctx context.Context) error {
+	if s.remove() {
+		return s.client.Unsubscribe(ctx, s.topic)
+	}
+	return nil
+}
+
+// remove unregisters and stops the handler and reports whether the topic has
+// no handlers left.
+func (s *Subscription) remove() bool {
+	s.stop()
+
+	c := s.client
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	subs := c.topics[s.topic]
+	for i, sub := range subs {
+		if sub == s {
+			subs = append(subs[:i:i], subs[i+1:]...)
+			break
+		}
+	}
+	if len(subs) == 0 {
+		delete(c.topics, s.topic)
+		return true
+	}
+	c.topics[s.topic] = subs
+	return false
+}
+
+// dispatch queues data for every handler registered for topic and reports
+// whether there was any. It never blocks the read loop.
+func (c *Client) dispatch(topic string, data json.RawMessage) bool {
+	c.mu.Lock()
+	subs := append([]*Subscription{}, c.topics[topic]...)
+	c.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.deliver(data)
+	}
+	return len(subs) > 0
+}
+
+// notification extracts the topic and payload of a server push. LN Markets
+// either names the channel in the method and carries the data in result, or
+// sends a "subscription" notification with the channel and data in params.
+func notification(msg Message) (string, json.RawMessage) {
+	if msg.Method == "subscription" && len(msg.Params) > 0 {
+		var params struct {
+			Channel string          `json:"channel"`
+			Data    json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err == nil && params.Channel != "" {
+			return params.Channel, params.Data
+		}
+	}
+	if len(msg.Result) > 0 {
+		return msg.Method, msg.Result
+	}
+	return msg.Method, msg.Params
+}
+
+// credentials are the API credentials of the last successful Authenticate,
+// kept to sign in again after a reconnect.
+type credentials struct {
+	apiKey     string
+	secretKey  string
+	passphrase string
+}
+
+// Authenticate signs in with LN Markets API credentials so private topics
+// such as TopicUserTrades can be subscribed to. The signature follows the
+// REST API scheme over the timestamp and the "auth" method. The client signs
+// in again with the same credentials after every reconnect, before replaying
+// subscriptions.
+func (c *Client) Authenticate(ctx context.Context, apiKey, secretKey, passphrase string) error {
+	creds := &credentials{apiKey: apiKey, secretKey: secretKey, passphrase: passphrase}
+	if err := c.authenticate(ctx, creds); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.credentials = creds
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *Client) authenticate(ctx context.Context, creds *credentials) error {
+	timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	mac := hmac.New(sha256.New, []byte(creds.secretKey))
+	mac.Write([]byte(timestamp + "auth"))
+
+	_, err := c.Call(ctx, "auth", map[string]string{
+		"key":        creds.apiKey,
+		"passphrase": creds.passphrase,
+		"timestamp":  timestamp,
+		"signature":  base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+	})
+	return err
+}